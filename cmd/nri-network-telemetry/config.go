@@ -1,12 +1,15 @@
 package main
 
 import (
+	"time"
+
 	"github.com/kelseyhightower/envconfig"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/newrelic/nri-network-telemetry/internal/emitter"
 	"github.com/newrelic/nri-network-telemetry/internal/flowhandler"
 	"github.com/newrelic/nri-network-telemetry/internal/netinfo"
+	"github.com/newrelic/nri-network-telemetry/internal/pipeline"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -17,16 +20,34 @@ const DefaultEmitTarget = "INSIGHTS"
 type Config struct {
 	FlowConfig    flowhandler.Config
 	EmitConfig    emitter.EmitConfig
-	NetInfo       netinfo.NetInfo
+	SnmpConfig    netinfo.SnmpConfig
+	ThreatConfig  netinfo.ThreatConfig
+	RdnsConfig    netinfo.ResolverConfig
+	K8sConfig     netinfo.KubernetesConfig
+	PipeConfig    pipeline.Config
+	NetInfo       *netinfo.NetInfo
 	NrServiceName string `envconfig:"SERVICE_NAME"`
 	NrLicenseKey  string `envconfig:"NEW_RELIC_LICENSE_KEY"`
 	BindAddress   string `envconfig:"BIND_ADDRESS"`
 	NetsFile      string `envconfig:"NETWORKS_FILE"`
 	HostsFile     string `envconfig:"HOSTS_FILE"`
+	IfacesFile    string `envconfig:"INTERFACES_FILE"`
+	TagsFile      string `envconfig:"TAGS_FILE"`
+	AppsFile      string `envconfig:"APPLICATIONS_FILE"`
 	EmitTarget    string `envconfig:"EMIT_TARGET"`
 	HTTPPort      int    `envconfig:"HTTP_PORT"`
 	Debug         bool   `default:"false"`
 	NrEnabled     bool   `envconfig:"NEW_RELIC_ENABLED"`
+
+	// Networks and ASNs considered local for traffic direction
+	LocalNetworks []string `envconfig:"LOCAL_NETWORKS"`
+	LocalAsns     []uint32 `envconfig:"LOCAL_ASNS"`
+
+	// Published cloud provider range files
+	CloudFiles []string `envconfig:"CLOUD_FILES"`
+
+	// IEEE OUI registry files
+	OuiFiles []string `envconfig:"OUI_FILES"`
 }
 
 // Load sets some default values which are then overridden by the environment to finally return a populated Config object.
@@ -46,6 +67,102 @@ func (c *Config) Load() (err error) {
 		Port:           6343,
 		SflowEventType: "sflow",
 		IpfixEventType: "ipfix",
+		Schema:         flowhandler.FlowSchemaRaw,
+		FlowEventType:  flowhandler.DefaultFlowEventType,
+
+		ClockSkewThreshold: flowhandler.DefaultClockSkewThreshold,
+	}
+
+	// Set defaults for the SNMP poller
+	c.SnmpConfig = netinfo.SnmpConfig{
+		Enabled:   false,
+		Version:   "2c",
+		Port:      161,
+		Community: "public",
+		Interval:  netinfo.DefaultSnmpPollInterval,
+		Timeout:   5 * time.Second,
+		Retries:   1,
+		Workers:   netinfo.DefaultSnmpWorkers,
+	}
+
+	// Set defaults for threat list matching
+	c.ThreatConfig = netinfo.ThreatConfig{
+		Severity:       netinfo.ThreatSeverityMedium,
+		Events:         false,
+		EventType:      "networkThreat",
+		ReloadInterval: time.Minute,
+	}
+
+	// Set defaults for reverse DNS lookups
+	c.RdnsConfig = netinfo.ResolverConfig{
+		Enabled:     false,
+		CacheSize:   65536,
+		TTL:         time.Hour,
+		NegativeTTL: 5 * time.Minute,
+		Timeout:     netinfo.DefaultResolverTimeout,
+		Workers:     netinfo.DefaultResolverWorkers,
+	}
+
+	// Set defaults for Kubernetes enrichment, using the in-cluster service account
+	c.K8sConfig = netinfo.KubernetesConfig{
+		Enabled:   false,
+		TokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+		CAFile:    "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt",
+	}
+
+	// Set defaults for the pipeline stages, the event types depend on the schema
+	c.PipeConfig.Aggregate = pipeline.AggregateConfig{
+		Enabled:   false,
+		Window:    pipeline.DefaultAggregateWindow,
+		Keys:      pipeline.DefaultAggregateKeys,
+		EventType: pipeline.DefaultAggregateEventType,
+		MaxKeys:   pipeline.DefaultAggregateMaxKeys,
+	}
+	c.PipeConfig.Cardinality = pipeline.CardinalityConfig{
+		Enabled:   false,
+		Interval:  pipeline.DefaultCardinalityInterval,
+		EventType: pipeline.DefaultCardinalityEventType,
+		MaxKeys:   pipeline.DefaultCardinalityMaxKeys,
+		Minimum:   pipeline.DefaultCardinalityMinimum,
+	}
+	c.PipeConfig.Attack = pipeline.AttackConfig{
+		Enabled:            false,
+		EventType:          pipeline.DefaultAttackEventType,
+		Interval:           pipeline.DefaultAttackInterval,
+		PPS:                pipeline.DefaultAttackPPS,
+		BPS:                pipeline.DefaultAttackBPS,
+		LearnFactor:        pipeline.DefaultAttackLearnFactor,
+		MinPPS:             pipeline.DefaultAttackMinPPS,
+		VectorRatio:        pipeline.DefaultAttackVectorRatio,
+		AmplificationPorts: pipeline.DefaultAttackAmplificationPorts,
+		Cooldown:           pipeline.DefaultAttackCooldown,
+		MaxDestinations:    pipeline.DefaultAttackMaxDestinations,
+		TopSources:         pipeline.DefaultAttackTopSources,
+	}
+	c.PipeConfig.Scan = pipeline.ScanConfig{
+		Enabled:     false,
+		EventType:   pipeline.DefaultScanEventType,
+		Window:      pipeline.DefaultScanWindow,
+		Hosts:       pipeline.DefaultScanHosts,
+		Ports:       pipeline.DefaultScanPorts,
+		FailedRatio: pipeline.DefaultScanFailedRatio,
+		MaxSources:  pipeline.DefaultScanMaxSources,
+		Targets:     pipeline.DefaultScanTargets,
+	}
+	c.PipeConfig.Conversation = pipeline.ConversationConfig{
+		Enabled:   false,
+		EventType: pipeline.DefaultConversationEventType,
+		Window:    pipeline.DefaultConversationWindow,
+		Max:       pipeline.DefaultConversationMax,
+	}
+	c.PipeConfig.Dedup = pipeline.DedupConfig{
+		Enabled:        false,
+		EventType:      pipeline.DefaultDedupEventType,
+		Mode:           pipeline.DefaultDedupMode,
+		Action:         pipeline.DefaultDedupAction,
+		Window:         pipeline.DefaultDedupWindow,
+		MaxKeys:        pipeline.DefaultDedupMaxKeys,
+		ReportInterval: pipeline.DefaultDedupReportInterval,
 	}
 
 	// Set defaults for the Emitters
@@ -64,6 +181,31 @@ func (c *Config) Load() (err error) {
 		return err
 	}
 
+	// Load SNMP poller config from the Environment
+	if err = envconfig.Process(appName, &c.SnmpConfig); err != nil {
+		return err
+	}
+
+	// Load threat list config from the Environment
+	if err = envconfig.Process(appName, &c.ThreatConfig); err != nil {
+		return err
+	}
+
+	// Load reverse DNS config from the Environment
+	if err = envconfig.Process(appName, &c.RdnsConfig); err != nil {
+		return err
+	}
+
+	// Load Kubernetes config from the Environment
+	if err = envconfig.Process(appName, &c.K8sConfig); err != nil {
+		return err
+	}
+
+	// Load pipeline stage config from the Environment
+	if err = envconfig.Process(appName, &c.PipeConfig); err != nil {
+		return err
+	}
+
 	// Load Emitter config from Environment
 	if err = envconfig.Process(appName, &c.EmitConfig.Log); err != nil {
 		return err
@@ -91,6 +233,10 @@ func parseCommandLine(args []string) (conf Config, err error) {
 
 	netsFile := cli.Flag("nets", "ASN to Name CSV File").Short('a').String()
 	hostsFile := cli.Flag("hosts", "IP to Hostname CSV File").Short('h').String()
+	ifacesFile := cli.Flag("interfaces", "Agent Interface to Name/Role CSV File").Short('i').String()
+	tagsFile := cli.Flag("tags", "Network to Tag CSV File").String()
+	appsFile := cli.Flag("applications", "Port and Protocol to Application CSV File").String()
+	schema := cli.Flag("schema", "Flow records to emit (raw / normalized / both)").String()
 
 	_, err = cli.Parse(args)
 	if err != nil {
@@ -115,6 +261,25 @@ func parseCommandLine(args []string) (conf Config, err error) {
 		conf.EmitTarget = *emitTarget
 	}
 
+	if *schema != "" {
+		conf.FlowConfig.Schema = *schema
+	}
+
+	switch conf.FlowConfig.Schema {
+	case flowhandler.FlowSchemaRaw, flowhandler.FlowSchemaNormalized, flowhandler.FlowSchemaBoth:
+	default:
+		log.Fatalf("unknown flow schema '%s'", conf.FlowConfig.Schema)
+	}
+
+	// Pipeline stages see the raw events, unless only normalized ones are emitted
+	if len(conf.PipeConfig.EventTypes) == 0 {
+		if conf.FlowConfig.Schema == flowhandler.FlowSchemaNormalized {
+			conf.PipeConfig.EventTypes = []string{conf.FlowConfig.FlowEventType}
+		} else {
+			conf.PipeConfig.EventTypes = []string{conf.FlowConfig.SflowEventType, conf.FlowConfig.IpfixEventType}
+		}
+	}
+
 	log.Debugf("%s: Config before loading NetInfo: %+v", appName, conf)
 
 	if *netsFile != "" {
@@ -125,9 +290,36 @@ func parseCommandLine(args []string) (conf Config, err error) {
 		conf.HostsFile = *hostsFile
 	}
 
-	conf.NetInfo = netinfo.NewNetInfo(conf.NetsFile, conf.HostsFile)
+	if *ifacesFile != "" {
+		conf.IfacesFile = *ifacesFile
+	}
+
+	if *tagsFile != "" {
+		conf.TagsFile = *tagsFile
+	}
+
+	if *appsFile != "" {
+		conf.AppsFile = *appsFile
+	}
+
+	conf.NetInfo = netinfo.NewNetInfo(netinfo.Config{
+		NetworksFile:   conf.NetsFile,
+		HostsFile:      conf.HostsFile,
+		InterfacesFile: conf.IfacesFile,
+		TagsFile:       conf.TagsFile,
+		ServicesFile:   conf.AppsFile,
+		LocalNetworks:  conf.LocalNetworks,
+		LocalAsns:      conf.LocalAsns,
+		CloudFiles:     conf.CloudFiles,
+		OuiFiles:       conf.OuiFiles,
+		Snmp:           conf.SnmpConfig,
+		Threats:        conf.ThreatConfig,
+		Resolver:       conf.RdnsConfig,
+		Kubernetes:     conf.K8sConfig,
+	})
 
 	conf.FlowConfig.AsnPeerMap = conf.NetInfo.AsnPeerMap()
+	conf.FlowConfig.NetInfo = conf.NetInfo
 
 	log.Infof("%s: Finished parsing config", appName)
 
@@ -14,6 +14,8 @@ import (
 	"github.com/newrelic/nri-network-telemetry/internal/emitter"
 	"github.com/newrelic/nri-network-telemetry/internal/flowhandler"
 	"github.com/newrelic/nri-network-telemetry/internal/httpserver"
+	"github.com/newrelic/nri-network-telemetry/internal/netinfo"
+	"github.com/newrelic/nri-network-telemetry/internal/pipeline"
 )
 
 var (
@@ -24,9 +26,14 @@ var (
 const (
 	TimeoutShutdownUpstream = 20 * time.Second // Timeout for upstream to notice we are gone after killing /status/check
 	TimeoutShutdownFlow     = 15 * time.Second // Timeout allowed for the flow processor to drain
+	TimeoutShutdownPipeline = 15 * time.Second // Timeout allowed for the pipeline stages to drain and flush
 	TimeoutShutdownHTTP     = 10 * time.Second // Timeout allowed for the http server to finish up
 	TimeoutShutdownEmit     = 20 * time.Second // Timeout allowed for the emitter to drain
 	TimeoutShutdownNR       = 10 * time.Second // Timeout allowed for the New Relic go-agent to drain
+	TimeoutShutdownSnmp     = 10 * time.Second // Timeout allowed for in-flight SNMP polls to finish
+	TimeoutShutdownThreats  = 5 * time.Second  // Timeout allowed for the threat list watcher to stop
+	TimeoutShutdownRdns     = 5 * time.Second  // Timeout allowed for in-flight reverse DNS lookups to finish
+	TimeoutShutdownK8s      = 5 * time.Second  // Timeout allowed for the Kubernetes API watches to stop
 )
 
 func main() {
@@ -71,12 +78,103 @@ func main() {
 		}
 	}()
 
+	/***********************************************
+	 * Optional stages between the flow handlers and the emitter
+	 **********************************************/
+	resultChan := resultEmitter.EmitChan()
+	pipelineControlChan := make(chan pipeline.ControlMessage, 1)
+
+	flowPipeline, err := pipeline.New(config.PipeConfig, resultEmitter.EmitChan(), nrApp)
+	if err != nil {
+		log.Fatalf("Failed to create flow pipeline: %v", err)
+	}
+
+	if flowPipeline != nil {
+		resultChan = flowPipeline.InputChan()
+		pipelineControlChan <- pipeline.ControlMessageStart
+
+		go func() {
+			err := flowPipeline.Start(pipelineControlChan)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	/***********************************************
+	 * Optional SNMP poller for agent names
+	 **********************************************/
+	snmpControlChan := make(chan netinfo.ControlMessage, 1)
+	snmpPoller := config.NetInfo.SnmpPoller()
+
+	if snmpPoller != nil {
+		snmpControlChan <- netinfo.ControlMessageStart
+
+		go func() {
+			err := snmpPoller.Start(snmpControlChan)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	/***********************************************
+	 * Optional threat list reloading
+	 **********************************************/
+	threatControlChan := make(chan netinfo.ControlMessage, 1)
+	threatList := config.NetInfo.ThreatList()
+
+	if threatList != nil {
+		threatControlChan <- netinfo.ControlMessageStart
+
+		go func() {
+			err := threatList.Start(threatControlChan)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	/***********************************************
+	 * Optional reverse DNS lookups
+	 **********************************************/
+	rdnsControlChan := make(chan netinfo.ControlMessage, 1)
+	resolver := config.NetInfo.Resolver()
+
+	if resolver != nil {
+		rdnsControlChan <- netinfo.ControlMessageStart
+
+		go func() {
+			err := resolver.Start(rdnsControlChan)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	/***********************************************
+	 * Optional Kubernetes API watcher
+	 **********************************************/
+	k8sControlChan := make(chan netinfo.ControlMessage, 1)
+	k8sWatcher := config.NetInfo.KubernetesWatcher()
+
+	if k8sWatcher != nil {
+		k8sControlChan <- netinfo.ControlMessageStart
+
+		go func() {
+			err := k8sWatcher.Start(k8sControlChan)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}()
+	}
+
 	/***********************************************
 	 * Simple UDP listener, drops packets into the right parser
 	 **********************************************/
 	flowControlChan := make(chan flowhandler.ControlMessage, 1)
 	flowControlChan <- flowhandler.ControlMessageStart
-	fh := flowhandler.New(config.FlowConfig, resultEmitter.EmitChan(), nrApp)
+	fh := flowhandler.New(config.FlowConfig, resultChan, nrApp)
 
 	go func() {
 		err := fh.Start(flowControlChan)
@@ -90,7 +188,7 @@ func main() {
 	 **********************************************/
 	httpControlChan := make(chan httpserver.ControlMessage, 1)
 	httpControlChan <- httpserver.ControlMessageStart
-	apiHandler := httpserver.New(version, config.BindAddress, config.HTTPPort, nrApp)
+	apiHandler := httpserver.New(version, config.BindAddress, config.HTTPPort, flowPipeline, nrApp)
 
 	go func() {
 		err := apiHandler.Start(httpControlChan)
@@ -133,6 +231,66 @@ func main() {
 		log.Errorf("flow handler failed to shutdown cleanly after %f seconds", TimeoutShutdownFlow.Seconds())
 	}
 
+	// Flush the pipeline stages
+	if flowPipeline != nil {
+		pipelineControlChan <- pipeline.ControlMessageQuit
+		select {
+		case <-pipelineControlChan:
+			log.Debugf("pipeline shutdown cleanly")
+			close(pipelineControlChan)
+		case <-time.After(TimeoutShutdownPipeline):
+			log.Errorf("pipeline failed to shutdown cleanly after %f seconds", TimeoutShutdownPipeline.Seconds())
+		}
+	}
+
+	// Stop polling agents
+	if snmpPoller != nil {
+		snmpControlChan <- netinfo.ControlMessageQuit
+		select {
+		case <-snmpControlChan:
+			log.Debugf("snmp poller shutdown cleanly")
+			close(snmpControlChan)
+		case <-time.After(TimeoutShutdownSnmp):
+			log.Errorf("snmp poller failed to shutdown cleanly after %f seconds", TimeoutShutdownSnmp.Seconds())
+		}
+	}
+
+	// Stop watching threat lists
+	if threatList != nil {
+		threatControlChan <- netinfo.ControlMessageQuit
+		select {
+		case <-threatControlChan:
+			log.Debugf("threat list watcher shutdown cleanly")
+			close(threatControlChan)
+		case <-time.After(TimeoutShutdownThreats):
+			log.Errorf("threat list watcher failed to shutdown cleanly after %f seconds", TimeoutShutdownThreats.Seconds())
+		}
+	}
+
+	// Stop resolving hostnames
+	if resolver != nil {
+		rdnsControlChan <- netinfo.ControlMessageQuit
+		select {
+		case <-rdnsControlChan:
+			log.Debugf("resolver shutdown cleanly")
+			close(rdnsControlChan)
+		case <-time.After(TimeoutShutdownRdns):
+			log.Errorf("resolver failed to shutdown cleanly after %f seconds", TimeoutShutdownRdns.Seconds())
+		}
+	}
+
+	// Stop watching Kubernetes
+	if k8sWatcher != nil {
+		k8sControlChan <- netinfo.ControlMessageQuit
+		select {
+		case <-k8sControlChan:
+			log.Debugf("kubernetes watcher shutdown cleanly")
+			close(k8sControlChan)
+		case <-time.After(TimeoutShutdownK8s):
+			log.Errorf("kubernetes watcher failed to shutdown cleanly after %f seconds", TimeoutShutdownK8s.Seconds())
+		}
+	}
+
 	// Kill the emitter, wait for confirmation
 	emitterControlChan <- emitter.ControlMessageQuit
 	select {
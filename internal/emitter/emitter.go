@@ -3,6 +3,8 @@ package emitter
 import (
 	newrelic "github.com/newrelic/go-agent"
 	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
 )
 
 type ControlMessage int
@@ -19,7 +21,7 @@ const (
 type EmitInterface interface {
 	Start(controlChan chan ControlMessage) error
 	Validate() error
-	EmitChan() chan map[string]interface{}
+	EmitChan() chan flowrecord.Event
 }
 
 type EmitConfig struct {
@@ -37,12 +39,12 @@ func New(target string, config EmitConfig, nr newrelic.Application) EmitInterfac
 	switch target {
 	case "LOG":
 		return &logEmitter{
-			emitChan: make(chan map[string]interface{}, EmitChannelBufferSize),
+			emitChan: make(chan flowrecord.Event, EmitChannelBufferSize),
 			config:   config.Log,
 		}
 	case "INSIGHTS":
 		return &insightsEmitter{
-			emitChan: make(chan map[string]interface{}, EmitChannelBufferSize),
+			emitChan: make(chan flowrecord.Event, EmitChannelBufferSize),
 			config:   config.Insights,
 			nr:       nr,
 		}
@@ -7,6 +7,7 @@ import (
 	insights "github.com/newrelic/go-insights/client"
 	log "github.com/sirupsen/logrus"
 
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
 	util "github.com/newrelic/nri-network-telemetry/internal/util"
 )
 
@@ -17,7 +18,7 @@ type InsightsEmitterConfig struct {
 }
 
 type insightsEmitter struct {
-	emitChan chan map[string]interface{}
+	emitChan chan flowrecord.Event
 	config   InsightsEmitterConfig
 	nr       newrelic.Application
 }
@@ -44,7 +45,7 @@ func (e *insightsEmitter) Validate() error {
  * Return the channel we read from
  *
  ******************************************************************************/
-func (e *insightsEmitter) EmitChan() chan map[string]interface{} {
+func (e *insightsEmitter) EmitChan() chan flowrecord.Event {
 	return e.emitChan
 }
 
@@ -78,6 +79,10 @@ func (e *insightsEmitter) Start(controlChan chan ControlMessage) error {
 		return err
 	}
 
+	// Flow records are rendered into the same map, events are marshalled
+	// as they are queued
+	attrs := make(map[string]interface{})
+
 	for {
 		select {
 		case msg := <-controlChan:
@@ -94,7 +99,7 @@ func (e *insightsEmitter) Start(controlChan chan ControlMessage) error {
 				return nil
 			}
 		case msg := <-e.emitChan:
-			util.LogIfErr(client.EnqueueEvent(msg))
+			util.LogIfErr(client.EnqueueEvent(flowrecord.Render(msg, attrs)))
 		}
 	}
 }
@@ -2,6 +2,8 @@ package emitter
 
 import (
 	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
 )
 
 type LogEmitterConfig struct {
@@ -9,7 +11,7 @@ type LogEmitterConfig struct {
 }
 
 type logEmitter struct {
-	emitChan chan map[string]interface{}
+	emitChan chan flowrecord.Event
 	config   LogEmitterConfig
 }
 
@@ -27,7 +29,7 @@ func (e *logEmitter) Validate() error {
  * Return the channel we read from
  *
  ******************************************************************************/
-func (e *logEmitter) EmitChan() chan map[string]interface{} {
+func (e *logEmitter) EmitChan() chan flowrecord.Event {
 	return e.emitChan
 }
 
@@ -44,6 +46,9 @@ func (e *logEmitter) Start(controlChan chan ControlMessage) error {
 		return err
 	}
 
+	// Flow records are rendered into the same map, logrus copies it
+	attrs := make(map[string]interface{})
+
 	for {
 		select {
 		case msg := <-controlChan:
@@ -59,7 +64,7 @@ func (e *logEmitter) Start(controlChan chan ControlMessage) error {
 				return nil
 			}
 		case msg := <-e.emitChan:
-			log.WithFields(msg).Info("emitter message")
+			log.WithFields(flowrecord.Render(msg, attrs)).Info("emitter message")
 		}
 	}
 }
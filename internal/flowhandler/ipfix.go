@@ -1,15 +1,18 @@
 package flowhandler
 
 import (
+	"fmt"
 	"net"
+	"sync/atomic"
 	"time"
 
 	newrelic "github.com/newrelic/go-agent"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/calmh/ipfix"
-	"github.com/google/gopacket/layers"
 
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/netinfo"
 	"github.com/newrelic/nri-network-telemetry/internal/util"
 )
 
@@ -41,12 +44,15 @@ const (
  * Create a new IPFIXhandler instance
  *
  ******************************************************************************/
-func NewIpfixHandler(packetChan chan IpfixPacket, resultChan chan map[string]interface{}, eventType string, peerMap map[uint32]string, nr newrelic.Application) *IpfixHandler {
+func NewIpfixHandler(packetChan chan IpfixPacket, resultChan chan flowrecord.Event, eventType string, schema flowSchema, clock *exporterClock, peerMap map[uint32]string, netInfo *netinfo.NetInfo, nr newrelic.Application) *IpfixHandler {
 	return (&IpfixHandler{
 		packetChan: packetChan,
 		resultChan: resultChan,
 		eventType:  eventType,
+		schema:     schema,
+		clock:      clock,
 		peerMap:    peerMap,
+		netInfo:    netInfo,
 		nr:         nr,
 	})
 }
@@ -57,16 +63,22 @@ func NewIpfixHandler(packetChan chan IpfixPacket, resultChan chan map[string]int
  *
  ******************************************************************************/
 type IpfixHandler struct {
-	resultChan chan map[string]interface{}
+	resultChan chan flowrecord.Event
 	packetChan chan IpfixPacket
 	eventType  string
+	schema     flowSchema
+	clock      *exporterClock
 	peerMap    map[uint32]string
+	netInfo    *netinfo.NetInfo
 	nr         newrelic.Application
+
+	malformed uint64 // Records dropped or only partly translated, updated atomically
 }
 
 type IpfixPacket struct {
 	AgentIP   string
 	BytesRead int
+	Received  time.Time
 	Data      []byte
 }
 
@@ -77,15 +89,15 @@ type IpfixPacket struct {
  ******************************************************************************/
 func (h *IpfixHandler) Start() {
 	// Keep track of all the sessions
-	sessions := make(map[string]chan []byte)
+	sessions := make(map[string]chan IpfixPacket)
 
 	for packet := range h.packetChan {
 		if packetChan, ok := sessions[packet.AgentIP]; ok {
-			packetChan <- packet.Data
+			packetChan <- packet
 		} else {
-			sessions[packet.AgentIP] = make(chan []byte, flowBufferSizePacket)
+			sessions[packet.AgentIP] = make(chan IpfixPacket, flowBufferSizePacket)
 			go h.handlePacketForAgent(packet.AgentIP, sessions[packet.AgentIP])
-			sessions[packet.AgentIP] <- packet.Data
+			sessions[packet.AgentIP] <- packet
 		}
 	}
 }
@@ -95,7 +107,7 @@ func (h *IpfixHandler) Start() {
  * Per Agent, handle the packets coming in
  *
  ******************************************************************************/
-func (h *IpfixHandler) handlePacketForAgent(agent string, packetChan chan []byte) {
+func (h *IpfixHandler) handlePacketForAgent(agent string, packetChan chan IpfixPacket) {
 	session := ipfix.NewSession()
 	interpreter := ipfix.NewInterpreter(session)
 
@@ -104,7 +116,7 @@ func (h *IpfixHandler) handlePacketForAgent(agent string, packetChan chan []byte
 		util.LogIfErr(txn.AddAttribute("agent", agent))
 
 		parseSegment := newrelic.StartSegment(txn, "ParseBuffer")
-		msg, err := session.ParseBuffer(packet)
+		msg, err := session.ParseBuffer(packet.Data)
 
 		util.LogIfErr(parseSegment.End())
 
@@ -116,90 +128,223 @@ func (h *IpfixHandler) handlePacketForAgent(agent string, packetChan chan []byte
 			continue
 		}
 
+		clock := h.messageClock(agent, packet.Received, msg.Header)
 		recordsSeg := newrelic.StartSegment(txn, "ParseDataRecords")
 
 		for _, record := range msg.DataRecords {
-			rec := make(map[string]interface{})
+			h.handleRecord(txn, interpreter, agent, clock, record)
+		}
 
-			// Initial data
-			rec["eventType"] = h.eventType
-			rec["timestamp"] = time.Now()
-			rec["agent"] = agent
-			rec["templateId"] = record.TemplateID
+		util.LogIfErr(recordsSeg.End())
+		util.LogIfErr(txn.End())
+	}
+}
 
-			interpSeg := newrelic.StartSegment(txn, "InterpretRecord")
-			ifs := interpreter.Interpret(record)
+/******************************************************************************
+ *
+ * Translate, enrich and queue a single data record.  A record that can not be
+ * translated is dropped and counted, it must not take the collector down.
+ *
+ ******************************************************************************/
+func (h *IpfixHandler) handleRecord(txn newrelic.Transaction, interpreter *ipfix.Interpreter, agent string, clock messageClock, record ipfix.DataRecord) {
+	defer func() {
+		if r := recover(); r != nil {
+			h.countMalformed()
+			log.Warnf("IPFIXhandler: Dropping malformed record from %s (template %d): %v", agent, record.TemplateID, r)
+			util.LogIfErr(txn.NoticeError(fmt.Errorf("malformed IPFIX record: %v", r)))
+		}
+	}()
 
-			util.LogIfErr(interpSeg.End())
+	rec := flowrecord.New(h.eventType, flowrecord.FlowTypeIpfix, agent, clock.received)
+	fields := rec.Fields
 
-			copySeg := newrelic.StartSegment(txn, "CopyRecord")
+	// Initial data
+	fields["agent"] = agent
+	fields["templateId"] = record.TemplateID
 
-			for _, iif := range ifs {
-				rec[iif.Name] = iif.Value
-			}
+	interpSeg := newrelic.StartSegment(txn, "InterpretRecord")
+	ifs := interpreter.Interpret(record)
 
-			util.LogIfErr(copySeg.End())
+	util.LogIfErr(interpSeg.End())
 
-			translateSeg := newrelic.StartSegment(txn, "TranslateRecord")
+	copySeg := newrelic.StartSegment(txn, "CopyRecord")
 
-			if _, ok := rec["tcpControlBits"]; ok {
-				rec["tcpFlagNS"] = (rec["tcpControlBits"].(uint16)&0x0100 == 0x0100)
-				rec["tcpFlagCWR"] = (rec["tcpControlBits"].(uint16)&0x0080 == 0x0080)
-				rec["tcpFlagECE"] = (rec["tcpControlBits"].(uint16)&0x0040 == 0x0040)
-				rec["tcpFlagURG"] = (rec["tcpControlBits"].(uint16)&0x0020 == 0x0020)
-				rec["tcpFlagACK"] = (rec["tcpControlBits"].(uint16)&0x0010 == 0x0010)
-				rec["tcpFlagPSH"] = (rec["tcpControlBits"].(uint16)&0x0008 == 0x0008)
-				rec["tcpFlagRST"] = (rec["tcpControlBits"].(uint16)&0x0004 == 0x0004)
-				rec["tcpFlagSYN"] = (rec["tcpControlBits"].(uint16)&0x0002 == 0x0002)
-				rec["tcpFlagFIN"] = (rec["tcpControlBits"].(uint16)&0x0001 == 0x0001)
+	for _, iif := range ifs {
+		fields[iif.Name] = iif.Value
+	}
 
-				delete(rec, "tcpControlBits")
-			}
+	util.LogIfErr(copySeg.End())
 
-			if _, ok := rec["icmpTypeCodeIPv4"]; ok {
-				rec["icmpTypeCodeIPv4"] = rec["icmpTypeCodeIPv4"].(layers.ICMPv4TypeCode).String()
-			}
+	// Counters are read before translation removes the timestamps
+	ipfixCounters(rec)
 
-			if _, ok := rec["icmpTypeCodeIPv6"]; ok {
-				rec["icmpTypeCodeIPv6"] = rec["icmpTypeCodeIPv6"].(layers.ICMPv6TypeCode).String()
-			}
+	// Flows are timestamped when they ended, or else when they were exported
+	if rec.End.IsZero() {
+		clock.stamp(rec, clock.exported)
+	} else {
+		clock.stamp(rec, rec.End)
+	}
 
-			// *net.IP to String conversions
-			for _, name := range ipAddressFields {
-				if _, ok := rec[name]; ok {
-					rec[name] = rec[name].(*net.IP).String()
-				}
-			}
+	translateSeg := newrelic.StartSegment(txn, "TranslateRecord")
+
+	if malformed := ipfixTranslate(rec, h.peerMap); len(malformed) > 0 {
+		h.countMalformed()
+		log.Debugf("IPFIXhandler: Unexpected field types from %s (template %d): %v", agent, record.TemplateID, malformed)
+	}
+
+	util.LogIfErr(translateSeg.End())
+
+	ipfixFlow(rec)
+
+	enrichSeg := newrelic.StartSegment(txn, "EnrichRecord")
+	h.netInfo.Enrich(rec)
+
+	util.LogIfErr(enrichSeg.End())
+
+	// Send Events
+	queueSegment := newrelic.StartSegment(txn, "QueueForEmit")
+	h.schema.queue(h.resultChan, h.netInfo, rec)
+
+	util.LogIfErr(queueSegment.End())
+}
+
+// messageClock checks the export time of a message, packets queued without a
+// receive time are taken as received now
+func (h *IpfixHandler) messageClock(agent string, received time.Time, header ipfix.MessageHeader) messageClock {
+	if received.IsZero() {
+		received = time.Now()
+	}
+
+	exported := time.Unix(int64(header.ExportTime), 0)
+
+	if h.clock == nil {
+		return messageClock{received: received, exported: exported}
+	}
+
+	return h.clock.ipfix(agent, received, exported)
+}
+
+// countMalformed records a record that could not be fully translated
+func (h *IpfixHandler) countMalformed() {
+	atomic.AddUint64(&h.malformed, 1)
+
+	if h.nr != nil {
+		util.LogIfErr(h.nr.RecordCustomMetric("ipfixMalformedRecords", 1))
+	}
+}
+
+// MalformedRecords returns the number of records that could not be fully translated
+func (h *IpfixHandler) MalformedRecords() uint64 {
+	return atomic.LoadUint64(&h.malformed)
+}
+
+/******************************************************************************
+ *
+ * Pull the typed fields out of a translated record
+ *
+ ******************************************************************************/
+func ipfixFlow(rec *flowrecord.FlowRecord) {
+	fields := rec.Fields
+
+	if v, ok := ipfixUnsigned(fields["ingressInterface"]); ok {
+		rec.InputInterface = uint32(v)
+	}
 
-			// *net.IP to String conversions
-			for _, name := range macAddressFields {
-				if _, ok := rec[name]; ok {
-					rec[name] = rec[name].(*net.HardwareAddr).String()
+	if v, ok := ipfixUnsigned(fields["egressInterface"]); ok {
+		rec.OutputInterface = uint32(v)
+	}
+
+	if v, ok := ipfixUnsigned(fields["bgpSourceAsNumber"]); ok {
+		rec.SourceAS = uint32(v)
+	}
+
+	if v, ok := ipfixUnsigned(fields["bgpDestinationAsNumber"]); ok {
+		rec.DestinationAS = uint32(v)
+	}
+
+	if v, ok := ipfixUnsigned(fields["protocolIdentifier"]); ok {
+		rec.HasProtocol = true
+		rec.Protocol = uint8(v)
+	}
+
+	if v, ok := ipfixUnsigned(fields["sourceTransportPort"]); ok {
+		rec.SourcePort = uint16(v)
+	}
+
+	if v, ok := ipfixUnsigned(fields["destinationTransportPort"]); ok {
+		rec.DestinationPort = uint16(v)
+	}
+
+	for _, name := range macAddressFields {
+		if v, ok := fields[name].(string); ok {
+			if mac, err := net.ParseMAC(v); err == nil {
+				if rec.HardwareAddresses == nil {
+					rec.HardwareAddresses = make(map[string]net.HardwareAddr)
 				}
-			}
 
-			if _, ok := rec["bgpSourceAsNumber"]; ok {
-				rec["peerName"] = h.peerMap[rec["bgpSourceAsNumber"].(uint32)]
+				rec.HardwareAddresses[name] = mac
 			}
+		}
+	}
 
-			if _, ok := rec["flowStartMilliseconds"]; ok {
-				rec["duration"] = rec["flowEndMilliseconds"].(time.Time).Sub(rec["flowStartMilliseconds"].(time.Time)).Nanoseconds()
+	rec.SourceAddress = ipfixAddress(fields, "sourceIPv4Address", "sourceIPv6Address")
+	rec.DestinationAddress = ipfixAddress(fields, "destinationIPv4Address", "destinationIPv6Address")
+}
 
-				delete(rec, "flowStartMilliseconds")
-				delete(rec, "flowEndMilliseconds")
-			}
+// ipfixCounters reads the volume, sampling and times of a record, from the
+// first of the alternative fields present
+func ipfixCounters(rec *flowrecord.FlowRecord) {
+	fields := rec.Fields
 
-			util.LogIfErr(translateSeg.End())
+	for _, name := range []string{"octetDeltaCount", "octetTotalCount"} {
+		if v, ok := ipfixUnsigned(fields[name]); ok {
+			rec.Bytes = v
+			break
+		}
+	}
 
-			// Send Event
-			queueSegment := newrelic.StartSegment(txn, "QueueForEmit")
+	for _, name := range []string{"packetDeltaCount", "packetTotalCount"} {
+		if v, ok := ipfixUnsigned(fields[name]); ok {
+			rec.Packets = v
+			break
+		}
+	}
 
-			h.resultChan <- rec
+	for _, name := range []string{"samplingInterval", "samplerRandomInterval", "samplingPacketInterval"} {
+		if v, ok := ipfixUnsigned(fields[name]); ok && v > 0 {
+			rec.SamplingRate = uint32(v)
+			break
+		}
+	}
 
-			util.LogIfErr(queueSegment.End())
+	for _, unit := range ipfixTimeUnits {
+		if v, ok := fields["flowStart"+unit].(time.Time); ok && rec.Start.IsZero() {
+			rec.Start = v
 		}
 
-		util.LogIfErr(recordsSeg.End())
-		util.LogIfErr(txn.End())
+		if v, ok := fields["flowEnd"+unit].(time.Time); ok && rec.End.IsZero() {
+			rec.End = v
+		}
 	}
+
+	if v, ok := ipfixSysUpTime(fields, "flowStartSysUpTime"); ok && rec.Start.IsZero() {
+		rec.Start = v
+	}
+
+	if v, ok := ipfixSysUpTime(fields, "flowEndSysUpTime"); ok && rec.End.IsZero() {
+		rec.End = v
+	}
+}
+
+// ipfixAddress returns the first of the named address fields present in the record
+func ipfixAddress(rec map[string]interface{}, names ...string) net.IP {
+	for _, name := range names {
+		if v, ok := rec[name].(string); ok {
+			if ip := net.ParseIP(v); ip != nil {
+				return ip
+			}
+		}
+	}
+
+	return nil
 }
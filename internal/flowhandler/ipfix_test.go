@@ -2,8 +2,58 @@ package flowhandler
 
 import (
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
 )
 
 func TestIpfix(t *testing.T) {
 
 }
+
+func TestIpfixFlow(t *testing.T) {
+	flow := flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", time.Now())
+	flow.Fields = map[string]interface{}{
+		"ingressInterface":         uint32(3),
+		"protocolIdentifier":       uint8(6),
+		"sourceTransportPort":      uint16(51515),
+		"destinationTransportPort": uint16(443),
+		"sourceIPv4Address":        "10.0.0.1",
+		"destinationIPv6Address":   "2001:db8::1",
+		"sourceMacAddress":         "00:0c:29:aa:bb:cc",
+	}
+
+	ipfixFlow(flow)
+	assert.Equal(t, "192.0.2.1", flow.Agent)
+	assert.Equal(t, uint32(3), flow.InputInterface)
+	assert.True(t, flow.HasProtocol)
+	assert.Equal(t, uint8(6), flow.Protocol)
+	assert.Equal(t, uint16(443), flow.DestinationPort)
+	assert.Equal(t, "10.0.0.1", flow.SourceAddress.String())
+	assert.Equal(t, "2001:db8::1", flow.DestinationAddress.String())
+	assert.Equal(t, "00:0c:29:aa:bb:cc", flow.HardwareAddresses["sourceMacAddress"].String())
+}
+
+func TestIpfixCounters(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	end := start.Add(30 * time.Second)
+
+	rec := flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", time.Now())
+	rec.Fields = map[string]interface{}{
+		"octetTotalCount":       uint64(1500),
+		"packetDeltaCount":      uint64(3),
+		"samplingInterval":      uint32(0),
+		"samplerRandomInterval": uint32(100),
+		"flowStartSeconds":      start,
+		"flowEndMilliseconds":   end,
+	}
+
+	ipfixCounters(rec)
+	assert.Equal(t, uint64(1500), rec.Bytes)
+	assert.Equal(t, uint64(3), rec.Packets)
+	assert.Equal(t, uint32(100), rec.SamplingRate)
+	assert.Equal(t, start, rec.Start)
+	assert.Equal(t, end, rec.End)
+}
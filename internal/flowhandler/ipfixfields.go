@@ -0,0 +1,174 @@
+package flowhandler
+
+import (
+	"net"
+	"time"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// Absolute timestamp units, in the order they are preferred
+var ipfixTimeUnits = []string{"Milliseconds", "Seconds", "Microseconds", "Nanoseconds"}
+
+/******************************************************************************
+ *
+ * Translate the interpreted values of a record into attributes.  Nothing is
+ * assumed about the encoding an exporter chose: fields with an unexpected
+ * type are left as they were, and their names returned.
+ *
+ ******************************************************************************/
+func ipfixTranslate(rec *flowrecord.FlowRecord, peerMap map[uint32]string) []string {
+	fields := rec.Fields
+	malformed := []string{}
+
+	if v, ok := fields["tcpControlBits"]; ok {
+		if bits, ok := ipfixUnsigned(v); ok {
+			addTCPFlags(rec, uint16(bits))
+
+			delete(fields, "tcpControlBits")
+		} else {
+			malformed = append(malformed, "tcpControlBits")
+		}
+	}
+
+	if v, ok := fields["icmpTypeCodeIPv4"]; ok {
+		if code, ok := ipfixUnsigned(v); ok {
+			fields["icmpTypeCodeIPv4"] = layers.ICMPv4TypeCode(code).String()
+		} else if code, ok := v.(layers.ICMPv4TypeCode); ok {
+			fields["icmpTypeCodeIPv4"] = code.String()
+		} else {
+			malformed = append(malformed, "icmpTypeCodeIPv4")
+		}
+	}
+
+	if v, ok := fields["icmpTypeCodeIPv6"]; ok {
+		if code, ok := ipfixUnsigned(v); ok {
+			fields["icmpTypeCodeIPv6"] = layers.ICMPv6TypeCode(code).String()
+		} else if code, ok := v.(layers.ICMPv6TypeCode); ok {
+			fields["icmpTypeCodeIPv6"] = code.String()
+		} else {
+			malformed = append(malformed, "icmpTypeCodeIPv6")
+		}
+	}
+
+	// IP address to String conversions, hashed addresses are already strings
+	for _, name := range ipAddressFields {
+		switch ip := fields[name].(type) {
+		case nil, string:
+		case *net.IP:
+			fields[name] = ip.String()
+		case net.IP:
+			fields[name] = ip.String()
+		default:
+			malformed = append(malformed, name)
+		}
+	}
+
+	// MAC address to String conversions
+	for _, name := range macAddressFields {
+		if v, ok := fields[name]; ok {
+			if mac, ok := ipfixHardwareAddr(v); ok {
+				fields[name] = mac.String()
+			} else {
+				malformed = append(malformed, name)
+			}
+		}
+	}
+
+	ipfixIPHeader(rec).addAttributes(rec)
+
+	if asn, ok := ipfixUnsigned(fields["bgpSourceAsNumber"]); ok {
+		fields["peerName"] = peerMap[uint32(asn)]
+	}
+
+	if duration, ok := ipfixDuration(fields); ok {
+		fields["duration"] = duration.Nanoseconds()
+	}
+
+	// Times are emitted as the duration, and flowStart/flowEnd in the normalized schema
+	for _, unit := range ipfixTimeUnits {
+		if _, ok := fields["flowStart"+unit].(time.Time); ok {
+			delete(fields, "flowStart"+unit)
+		}
+
+		if _, ok := fields["flowEnd"+unit].(time.Time); ok {
+			delete(fields, "flowEnd"+unit)
+		}
+	}
+
+	return malformed
+}
+
+// ipfixUnsigned reads an unsigned field of any width, exporters may encode a
+// field in fewer bytes than the standard type or pick a different type
+func ipfixUnsigned(v interface{}) (uint64, bool) {
+	switch n := v.(type) {
+	case uint8:
+		return uint64(n), true
+	case uint16:
+		return uint64(n), true
+	case uint32:
+		return uint64(n), true
+	case uint64:
+		return n, true
+	}
+
+	return 0, false
+}
+
+// ipfixDuration is the length of the flow, from the first complete pair of
+// absolute times or else the sysUpTime relative ones
+func ipfixDuration(fields map[string]interface{}) (time.Duration, bool) {
+	for _, unit := range ipfixTimeUnits {
+		start, startOk := fields["flowStart"+unit].(time.Time)
+		end, endOk := fields["flowEnd"+unit].(time.Time)
+
+		if startOk && endOk {
+			return end.Sub(start), true
+		}
+	}
+
+	start, startOk := ipfixUnsigned(fields["flowStartSysUpTime"])
+	end, endOk := ipfixUnsigned(fields["flowEndSysUpTime"])
+
+	if startOk && endOk {
+		// Milliseconds since the exporter booted, wrapping at 32 bits
+		return time.Duration(uint32(end)-uint32(start)) * time.Millisecond, true
+	}
+
+	return 0, false
+}
+
+// ipfixSysUpTime places a sysUpTime relative field on the wall clock, when
+// the exporter sent its boot time
+func ipfixSysUpTime(fields map[string]interface{}, name string) (time.Time, bool) {
+	boot, ok := fields["systemInitTimeMilliseconds"].(time.Time)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	offset, ok := ipfixUnsigned(fields[name])
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return boot.Add(time.Duration(offset) * time.Millisecond), true
+}
+
+// ipfixHardwareAddr accepts MAC addresses as either raw bytes or already parsed
+func ipfixHardwareAddr(v interface{}) (net.HardwareAddr, bool) {
+	switch mac := v.(type) {
+	case []byte:
+		if len(mac) == 6 || len(mac) == 8 {
+			return net.HardwareAddr(mac), true
+		}
+	case net.HardwareAddr:
+		return mac, true
+	case *net.HardwareAddr:
+		return *mac, true
+	}
+
+	return nil, false
+}
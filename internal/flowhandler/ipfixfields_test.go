@@ -0,0 +1,160 @@
+package flowhandler
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/calmh/ipfix"
+	newrelic "github.com/newrelic/go-agent"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestIpfixTranslate(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+	src := net.ParseIP("10.0.0.1").To4()
+
+	rec := flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", start)
+	rec.Fields = map[string]interface{}{
+		"tcpControlBits":         uint8(0x12),
+		"icmpTypeCodeIPv4":       uint16(0x0303),
+		"sourceIPv4Address":      &src,
+		"destinationIPv4Address": "d41d8cd98f00b204e9800998ecf8427e",
+		"sourceMacAddress":       []byte{0x00, 0x0c, 0x29, 0xaa, 0xbb, 0xcc},
+		"bgpSourceAsNumber":      uint32(64500),
+		"flowStartMilliseconds":  start,
+		"flowEndMilliseconds":    start.Add(1500 * time.Millisecond),
+	}
+
+	malformed := ipfixTranslate(rec, map[uint32]string{64500: "upstream"})
+	assert.Empty(t, malformed)
+	assert.Equal(t, true, rec.Fields["tcpFlagSYN"])
+	assert.Equal(t, true, rec.Fields["tcpFlagACK"])
+	assert.NotContains(t, rec.Fields, "tcpControlBits")
+	assert.Equal(t, "DestinationUnreachable(Port)", rec.Fields["icmpTypeCodeIPv4"])
+	assert.Equal(t, "10.0.0.1", rec.Fields["sourceIPv4Address"])
+	assert.Equal(t, "d41d8cd98f00b204e9800998ecf8427e", rec.Fields["destinationIPv4Address"])
+	assert.Equal(t, "00:0c:29:aa:bb:cc", rec.Fields["sourceMacAddress"])
+	assert.Equal(t, "upstream", rec.Fields["peerName"])
+	assert.Equal(t, int64(1500*time.Millisecond), rec.Fields["duration"])
+	assert.NotContains(t, rec.Fields, "flowStartMilliseconds")
+	assert.NotContains(t, rec.Fields, "flowEndMilliseconds")
+}
+
+func TestIpfixTranslateMalformed(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+
+	// A start without an end, and fields in types no exporter should send
+	rec := flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", start)
+	rec.Fields = map[string]interface{}{
+		"tcpControlBits":        "SYN",
+		"icmpTypeCodeIPv6":      []byte{0x01},
+		"sourceIPv4Address":     []byte{0x0a},
+		"sourceMacAddress":      []byte{0x00, 0x0c},
+		"bgpSourceAsNumber":     "64500",
+		"flowStartMilliseconds": start,
+	}
+
+	assert.NotPanics(t, func() {
+		malformed := ipfixTranslate(rec, nil)
+		assert.ElementsMatch(t, []string{"tcpControlBits", "icmpTypeCodeIPv6", "sourceIPv4Address", "sourceMacAddress"}, malformed)
+	})
+
+	assert.Equal(t, "SYN", rec.Fields["tcpControlBits"])
+	assert.NotContains(t, rec.Fields, "peerName")
+	assert.NotContains(t, rec.Fields, "duration")
+}
+
+func TestIpfixDuration(t *testing.T) {
+	start := time.Unix(1600000000, 0)
+
+	for _, unit := range ipfixTimeUnits {
+		duration, ok := ipfixDuration(map[string]interface{}{
+			"flowStart" + unit: start,
+			"flowEnd" + unit:   start.Add(time.Minute),
+		})
+		assert.True(t, ok, unit)
+		assert.Equal(t, time.Minute, duration, unit)
+	}
+
+	// sysUpTime relative, across the 32 bit wrap
+	duration, ok := ipfixDuration(map[string]interface{}{
+		"flowStartSysUpTime": uint32(0xffffff00),
+		"flowEndSysUpTime":   uint32(0x00000100),
+	})
+	assert.True(t, ok)
+	assert.Equal(t, 512*time.Millisecond, duration)
+
+	_, ok = ipfixDuration(map[string]interface{}{"flowEndSeconds": start})
+	assert.False(t, ok)
+}
+
+func TestIpfixSysUpTime(t *testing.T) {
+	boot := time.Unix(1600000000, 0)
+
+	rec := flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", time.Now())
+	rec.Fields = map[string]interface{}{
+		"systemInitTimeMilliseconds": boot,
+		"flowStartSysUpTime":         uint32(1000),
+		"flowEndSysUpTime":           uint32(3000),
+	}
+
+	ipfixCounters(rec)
+	assert.Equal(t, boot.Add(time.Second), rec.Start)
+	assert.Equal(t, boot.Add(3*time.Second), rec.End)
+
+	// Without the boot time only the duration is known
+	_, ok := ipfixSysUpTime(map[string]interface{}{"flowStartSysUpTime": uint32(1000)}, "flowStartSysUpTime")
+	assert.False(t, ok)
+}
+
+func TestIpfixUnsigned(t *testing.T) {
+	for _, v := range []interface{}{uint8(7), uint16(7), uint32(7), uint64(7)} {
+		n, ok := ipfixUnsigned(v)
+		assert.True(t, ok)
+		assert.Equal(t, uint64(7), n)
+	}
+
+	_, ok := ipfixUnsigned(int8(7))
+	assert.False(t, ok)
+
+	_, ok = ipfixUnsigned(nil)
+	assert.False(t, ok)
+}
+
+func TestIpfixHardwareAddr(t *testing.T) {
+	expected, _ := net.ParseMAC("00:0c:29:aa:bb:cc")
+
+	mac, ok := ipfixHardwareAddr([]byte{0x00, 0x0c, 0x29, 0xaa, 0xbb, 0xcc})
+	assert.True(t, ok)
+	assert.Equal(t, expected, mac)
+
+	mac, ok = ipfixHardwareAddr(&expected)
+	assert.True(t, ok)
+	assert.Equal(t, expected, mac)
+
+	_, ok = ipfixHardwareAddr(uint64(1))
+	assert.False(t, ok)
+
+	_, ok = ipfixHardwareAddr([]byte{0x00, 0x0c})
+	assert.False(t, ok)
+}
+
+func TestIpfixHandleRecordRecovers(t *testing.T) {
+	cfg := newrelic.NewConfig("test", "")
+	cfg.Enabled = false
+
+	nr, err := newrelic.NewApplication(cfg)
+	assert.NoError(t, err)
+
+	h := NewIpfixHandler(nil, make(chan flowrecord.Event, 1), "ipfix", newFlowSchema("", ""), nil, nil, nil, nr)
+	txn := nr.StartTransaction("test", nil, nil)
+
+	// Interpreting without an interpreter panics
+	assert.NotPanics(t, func() {
+		h.handleRecord(txn, nil, "192.0.2.1", messageClock{}, ipfix.DataRecord{TemplateID: 256})
+	})
+	assert.Equal(t, uint64(1), h.MalformedRecords())
+}
@@ -0,0 +1,141 @@
+package flowhandler
+
+import (
+	"strconv"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// IPFIX fragmentFlags use the IPv4 flag bit order, with bit 0 the most significant
+const ipfixFragmentFlagMF = 0x20
+
+// Standard DSCP names (RFC 2474, 2597, 3246, 5865, 8622)
+var dscpNames = map[uint8]string{
+	0:  "DF",
+	1:  "LE",
+	8:  "CS1",
+	10: "AF11",
+	12: "AF12",
+	14: "AF13",
+	16: "CS2",
+	18: "AF21",
+	20: "AF22",
+	22: "AF23",
+	24: "CS3",
+	26: "AF31",
+	28: "AF32",
+	30: "AF33",
+	32: "CS4",
+	34: "AF41",
+	36: "AF42",
+	38: "AF43",
+	40: "CS5",
+	44: "VOICE-ADMIT",
+	46: "EF",
+	48: "CS6",
+	56: "CS7",
+}
+
+/******************************************************************************
+ *
+ * QoS and forwarding fields of an IP header, from either a sampled packet or
+ * a flow record.  Only the fields that were present are added.
+ *
+ ******************************************************************************/
+type ipHeader struct {
+	hasDSCP bool
+	dscp    uint8
+
+	hasECN bool
+	ecn    uint8
+
+	hasTTL bool
+	ttl    uint8
+
+	hasFragment bool
+	fragmented  bool
+}
+
+// setClass splits an IPv4 TOS or IPv6 traffic class byte into DSCP and ECN
+func (h *ipHeader) setClass(class uint8) {
+	h.hasDSCP, h.dscp = true, class>>2
+	h.hasECN, h.ecn = true, class&0x03
+}
+
+func (h ipHeader) addAttributes(rec *flowrecord.FlowRecord) {
+	if h.hasDSCP {
+		rec.Set("dscp", int32(h.dscp))
+		rec.Set("dscpName", DSCPName(h.dscp))
+	}
+
+	if h.hasECN {
+		rec.Set("ecn", int32(h.ecn))
+	}
+
+	if h.hasTTL {
+		rec.Set("ttl", int32(h.ttl))
+	}
+
+	if h.hasFragment {
+		rec.Set("fragmented", h.fragmented)
+	}
+}
+
+// DSCPName returns the standard name of a DSCP value, or the value itself
+func DSCPName(dscp uint8) string {
+	if name, ok := dscpNames[dscp]; ok {
+		return name
+	}
+
+	return strconv.Itoa(int(dscp))
+}
+
+// sflowIPv4Header reads a sampled IPv4 header
+func sflowIPv4Header(ip *layers.IPv4) ipHeader {
+	h := ipHeader{hasTTL: true, ttl: ip.TTL, hasFragment: true}
+	h.setClass(ip.TOS)
+	h.fragmented = ip.Flags&layers.IPv4MoreFragments != 0 || ip.FragOffset != 0
+
+	return h
+}
+
+// sflowIPv6Header reads a sampled IPv6 header, fragments carry an extension header
+func sflowIPv6Header(ip *layers.IPv6, packet gopacket.Packet) ipHeader {
+	h := ipHeader{hasTTL: true, ttl: ip.HopLimit, hasFragment: true}
+	h.setClass(ip.TrafficClass)
+	h.fragmented = packet.Layer(layers.LayerTypeIPv6Fragment) != nil
+
+	return h
+}
+
+// ipfixIPHeader reads the header fields of a flow record
+func ipfixIPHeader(rec *flowrecord.FlowRecord) ipHeader {
+	h := ipHeader{}
+
+	if class, ok := rec.Fields["ipClassOfService"].(uint8); ok {
+		h.setClass(class)
+	} else if dscp, ok := rec.Fields["ipDiffServCodePoint"].(uint8); ok {
+		h.hasDSCP, h.dscp = true, dscp
+	}
+
+	if ttl, ok := rec.Fields["ipTTL"].(uint8); ok {
+		h.hasTTL, h.ttl = true, ttl
+	} else if ttl, ok := rec.Fields["minimumTTL"].(uint8); ok {
+		h.hasTTL, h.ttl = true, ttl
+	}
+
+	if flags, ok := rec.Fields["fragmentFlags"].(uint8); ok {
+		h.hasFragment = true
+		h.fragmented = flags&ipfixFragmentFlagMF != 0
+	}
+
+	if offset, ok := rec.Fields["fragmentOffset"].(uint16); ok {
+		h.hasFragment = true
+		h.fragmented = h.fragmented || offset != 0
+	}
+
+	return h
+}
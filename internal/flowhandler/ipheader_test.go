@@ -0,0 +1,103 @@
+package flowhandler
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestDSCPName(t *testing.T) {
+	assert.Equal(t, "EF", DSCPName(46))
+	assert.Equal(t, "AF41", DSCPName(34))
+	assert.Equal(t, "DF", DSCPName(0))
+	assert.Equal(t, "7", DSCPName(7))
+}
+
+func TestSflowIPv4Header(t *testing.T) {
+	rec := flowrecord.New("sflow", flowrecord.FlowTypeSflow, "192.0.2.1", time.Time{})
+
+	// EF with ECN CE, first fragment
+	sflowIPv4Header(&layers.IPv4{TOS: 0xbb, TTL: 63, Flags: layers.IPv4MoreFragments}).addAttributes(rec)
+	assert.Equal(t, map[string]interface{}{
+		"dscp":       int32(46),
+		"dscpName":   "EF",
+		"ecn":        int32(3),
+		"ttl":        int32(63),
+		"fragmented": true,
+	}, rec.Fields)
+
+	rec = flowrecord.New("sflow", flowrecord.FlowTypeSflow, "192.0.2.1", time.Time{})
+
+	sflowIPv4Header(&layers.IPv4{TOS: 0x88, TTL: 255, Flags: layers.IPv4DontFragment}).addAttributes(rec)
+	assert.Equal(t, "AF41", rec.Fields["dscpName"])
+	assert.Equal(t, int32(0), rec.Fields["ecn"])
+	assert.Equal(t, false, rec.Fields["fragmented"])
+}
+
+func TestSflowIPv6Header(t *testing.T) {
+	ip := &layers.IPv6{
+		Version:      6,
+		TrafficClass: 0xb8,
+		HopLimit:     64,
+		NextHeader:   layers.IPProtocolNoNextHeader,
+		SrcIP:        net.ParseIP("2001:db8::1"),
+		DstIP:        net.ParseIP("2001:db8::2"),
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	assert.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip))
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv6, gopacket.Default)
+	decoded, ok := packet.NetworkLayer().(*layers.IPv6)
+	assert.True(t, ok)
+
+	rec := flowrecord.New("sflow", flowrecord.FlowTypeSflow, "192.0.2.1", time.Time{})
+	sflowIPv6Header(decoded, packet).addAttributes(rec)
+	assert.Equal(t, map[string]interface{}{
+		"dscp":       int32(46),
+		"dscpName":   "EF",
+		"ecn":        int32(0),
+		"ttl":        int32(64),
+		"fragmented": false,
+	}, rec.Fields)
+}
+
+func TestIpfixIPHeader(t *testing.T) {
+	rec := flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", time.Time{})
+	rec.Fields = map[string]interface{}{
+		"ipClassOfService": uint8(0x29),
+		"ipTTL":            uint8(120),
+		"fragmentOffset":   uint16(185),
+	}
+
+	ipfixIPHeader(rec).addAttributes(rec)
+	assert.Equal(t, int32(10), rec.Fields["dscp"])
+	assert.Equal(t, "AF11", rec.Fields["dscpName"])
+	assert.Equal(t, int32(1), rec.Fields["ecn"])
+	assert.Equal(t, int32(120), rec.Fields["ttl"])
+	assert.Equal(t, true, rec.Fields["fragmented"])
+
+	// Only a DSCP, without ECN
+	rec = flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", time.Time{})
+	rec.Fields = map[string]interface{}{"ipDiffServCodePoint": uint8(46)}
+
+	ipfixIPHeader(rec).addAttributes(rec)
+	assert.Equal(t, map[string]interface{}{
+		"ipDiffServCodePoint": uint8(46),
+		"dscp":                int32(46),
+		"dscpName":            "EF",
+	}, rec.Fields)
+
+	// Nothing to add
+	rec = flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", time.Time{})
+	rec.Fields = map[string]interface{}{}
+
+	ipfixIPHeader(rec).addAttributes(rec)
+	assert.Empty(t, rec.Fields)
+}
@@ -0,0 +1,80 @@
+package flowhandler
+
+import (
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/netinfo"
+)
+
+// Flow schemas the handlers can emit
+const (
+	FlowSchemaRaw        = "raw"
+	FlowSchemaNormalized = "normalized"
+	FlowSchemaBoth       = "both"
+)
+
+// DefaultFlowEventType is the event type of normalized records
+const DefaultFlowEventType = "networkFlow"
+
+/******************************************************************************
+ *
+ * flowSchema decides which records are emitted for a flow
+ *
+ ******************************************************************************/
+type flowSchema struct {
+	schema    string
+	eventType string
+}
+
+func newFlowSchema(schema string, eventType string) flowSchema {
+	switch schema {
+	case FlowSchemaNormalized, FlowSchemaBoth:
+	default:
+		schema = FlowSchemaRaw
+	}
+
+	if eventType == "" {
+		eventType = DefaultFlowEventType
+	}
+
+	return flowSchema{schema: schema, eventType: eventType}
+}
+
+// raw returns true if the protocol specific records are emitted
+func (s flowSchema) raw() bool {
+	return s.schema != FlowSchemaNormalized
+}
+
+// normalized returns true if the common schema records are emitted
+func (s flowSchema) normalized() bool {
+	return s.schema != FlowSchemaRaw
+}
+
+// queue sends the records for a flow, followed by a single threat event.
+// Every event is built before any is sent, as the pipeline may change a
+// record once it has it.
+func (s flowSchema) queue(resultChan chan flowrecord.Event, netInfo *netinfo.NetInfo, rec *flowrecord.FlowRecord) {
+	events := make([]flowrecord.Event, 0, 3)
+
+	primary := rec
+
+	if s.raw() {
+		events = append(events, rec)
+	}
+
+	if s.normalized() {
+		normalized := rec.Normalized(s.eventType)
+		events = append(events, normalized)
+
+		if !s.raw() {
+			primary = normalized
+		}
+	}
+
+	if event, ok := netInfo.ThreatEvent(primary); ok {
+		events = append(events, event)
+	}
+
+	for _, event := range events {
+		resultChan <- event
+	}
+}
@@ -0,0 +1,72 @@
+package flowhandler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestNewFlowSchema(t *testing.T) {
+	schema := newFlowSchema("", "")
+	assert.True(t, schema.raw())
+	assert.False(t, schema.normalized())
+	assert.Equal(t, DefaultFlowEventType, schema.eventType)
+
+	schema = newFlowSchema(FlowSchemaNormalized, "flows")
+	assert.False(t, schema.raw())
+	assert.True(t, schema.normalized())
+	assert.Equal(t, "flows", schema.eventType)
+
+	schema = newFlowSchema(FlowSchemaBoth, "")
+	assert.True(t, schema.raw())
+	assert.True(t, schema.normalized())
+}
+
+func TestFlowSchemaQueue(t *testing.T) {
+	resultChan := make(chan flowrecord.Event, 4)
+	rec := flowrecord.New("sflow", flowrecord.FlowTypeSflow, "192.0.2.1", time.Now())
+
+	newFlowSchema(FlowSchemaBoth, "").queue(resultChan, nil, rec)
+	assert.Equal(t, 2, len(resultChan))
+	assert.Equal(t, rec, <-resultChan)
+
+	event := <-resultChan
+	assert.Equal(t, DefaultFlowEventType, event.EventType())
+	assert.True(t, event.(*flowrecord.FlowRecord).IsNormalized())
+	assert.False(t, rec.IsNormalized())
+
+	newFlowSchema(FlowSchemaNormalized, "flows").queue(resultChan, nil, rec)
+	assert.Equal(t, 1, len(resultChan))
+	assert.Equal(t, "flows", (<-resultChan).EventType())
+
+	newFlowSchema(FlowSchemaRaw, "").queue(resultChan, nil, rec)
+	assert.Equal(t, 1, len(resultChan))
+	assert.Equal(t, rec, <-resultChan)
+}
+
+// Run with -race, the pipeline changes records as soon as they are queued
+func TestFlowSchemaQueueRace(t *testing.T) {
+	resultChan := make(chan flowrecord.Event)
+	done := make(chan bool)
+
+	go func() {
+		for event := range resultChan {
+			if rec, ok := event.(*flowrecord.FlowRecord); ok {
+				rec.Enrich("duplicate", true)
+			}
+		}
+
+		done <- true
+	}()
+
+	schema := newFlowSchema(FlowSchemaBoth, "")
+	for i := 0; i < 100; i++ {
+		schema.queue(resultChan, nil, flowrecord.New("sflow", flowrecord.FlowTypeSflow, "192.0.2.1", time.Now()))
+	}
+
+	close(resultChan)
+	<-done
+}
@@ -6,6 +6,8 @@ import (
 	"net"
 	"time"
 
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/netinfo"
 	"github.com/newrelic/nri-network-telemetry/internal/util"
 
 	newrelic "github.com/newrelic/go-agent"
@@ -33,6 +35,15 @@ type Config struct {
 	SflowEventType string `envconfig:"SFLOW_EVENT_TYPE"`
 	IpfixEventType string `envconfig:"IPFIX_EVENT_TYPE"`
 	AsnPeerMap     map[uint32]string
+	NetInfo        *netinfo.NetInfo
+
+	// Emit raw protocol records, normalized records, or both
+	Schema        string `envconfig:"FLOW_SCHEMA"`
+	FlowEventType string `envconfig:"FLOW_EVENT_TYPE"`
+
+	// Exporter clocks further off than the threshold are reported, and corrected if enabled
+	ClockSkewThreshold time.Duration `envconfig:"FLOW_CLOCK_SKEW_THRESHOLD"`
+	ClockSkewCorrect   bool          `envconfig:"FLOW_CLOCK_SKEW_CORRECT"`
 }
 
 /******************************************************************************
@@ -40,7 +51,7 @@ type Config struct {
  * Create a new FlowHandler instance
  *
  ******************************************************************************/
-func New(config Config, resultChan chan map[string]interface{}, nr newrelic.Application) *FlowHandler {
+func New(config Config, resultChan chan flowrecord.Event, nr newrelic.Application) *FlowHandler {
 	return (&FlowHandler{
 		config:     config,
 		nr:         nr,
@@ -57,7 +68,7 @@ func New(config Config, resultChan chan map[string]interface{}, nr newrelic.Appl
  ******************************************************************************/
 type FlowHandler struct {
 	config     Config
-	resultChan chan map[string]interface{}
+	resultChan chan flowrecord.Event
 	sflowChan  chan SflowPacket
 	ipfixChan  chan IpfixPacket
 	nr         newrelic.Application
@@ -74,10 +85,13 @@ func (s *FlowHandler) listenAddr() string {
  ******************************************************************************/
 func (s *FlowHandler) Start(controlChan chan ControlMessage) error {
 	// Start the goroutines here
-	ipfix := NewIpfixHandler(s.ipfixChan, s.resultChan, s.config.IpfixEventType, s.config.AsnPeerMap, s.nr)
+	schema := newFlowSchema(s.config.Schema, s.config.FlowEventType)
+	clock := newExporterClock(s.config.ClockSkewThreshold, s.config.ClockSkewCorrect, s.nr)
+
+	ipfix := NewIpfixHandler(s.ipfixChan, s.resultChan, s.config.IpfixEventType, schema, clock, s.config.AsnPeerMap, s.config.NetInfo, s.nr)
 	go ipfix.Start()
 
-	sflow := NewSflowHandler(s.sflowChan, s.resultChan, s.config.SflowEventType, s.nr)
+	sflow := NewSflowHandler(s.sflowChan, s.resultChan, s.config.SflowEventType, schema, clock, s.config.NetInfo, s.nr)
 	go sflow.Start()
 
 	/*
@@ -133,6 +147,8 @@ func (s *FlowHandler) Start(controlChan chan ControlMessage) error {
 			util.LogIfErr(conn.SetReadDeadline(time.Now().Add(time.Second * flowTimeoutRead)))
 
 			bytesRead, addr, err := conn.ReadFromUDP(buf)
+			received := time.Now()
+
 			if err != nil {
 				// Do not log read timeouts
 				if netErr, ok := err.(net.Error); ok && !netErr.Timeout() {
@@ -157,12 +173,16 @@ func (s *FlowHandler) Start(controlChan chan ControlMessage) error {
 			switch flowVersion {
 			case 5: // Sflow
 				util.LogIfErr(s.nr.RecordCustomMetric("sflowChanLength", float64(len(s.sflowChan))))
-				s.sflowChan <- buf[:bytesRead]
+				s.sflowChan <- (SflowPacket{
+					Received: received,
+					Data:     buf[:bytesRead],
+				})
 			case 10: // IPFIX
 				util.LogIfErr(s.nr.RecordCustomMetric("ipfixChanLength", float64(len(s.ipfixChan))))
 				s.ipfixChan <- (IpfixPacket{
 					AgentIP:   agentIP,
 					BytesRead: bytesRead,
+					Received:  received,
 					Data:      buf[:bytesRead],
 				})
 			default: // Unsupported
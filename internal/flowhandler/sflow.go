@@ -3,11 +3,14 @@ package flowhandler
 import (
 	"encoding/hex"
 	"errors"
+	"net"
 	"time"
 
 	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/netinfo"
 	"github.com/newrelic/nri-network-telemetry/internal/util"
 
 	newrelic "github.com/newrelic/go-agent"
@@ -16,16 +19,29 @@ import (
 
 const SflowPacketBufferSize = 2048
 
+// Compact flow samples encode the interface format in the top two bits, and
+// an index of all ones is an unknown interface
+const (
+	sflowInterfaceFormatShift = 30
+	sflowInterfaceUnknown     = 0x3fffffff
+)
+
+// IPv6 payload length does not include the fixed header
+const ipv6HeaderLength = 40
+
 /******************************************************************************
  *
  * Create a new SflowHandler instance
  *
  ******************************************************************************/
-func NewSflowHandler(packetChan chan SflowPacket, resultChan chan map[string]interface{}, eventType string, nr newrelic.Application) *SflowHandler {
+func NewSflowHandler(packetChan chan SflowPacket, resultChan chan flowrecord.Event, eventType string, schema flowSchema, clock *exporterClock, netInfo *netinfo.NetInfo, nr newrelic.Application) *SflowHandler {
 	return (&SflowHandler{
 		packetChan: packetChan,
 		resultChan: resultChan,
 		eventType:  eventType,
+		schema:     schema,
+		clock:      clock,
+		netInfo:    netInfo,
 		nr:         nr,
 	})
 }
@@ -36,13 +52,19 @@ func NewSflowHandler(packetChan chan SflowPacket, resultChan chan map[string]int
  *
  ******************************************************************************/
 type SflowHandler struct {
-	resultChan chan map[string]interface{}
+	resultChan chan flowrecord.Event
 	packetChan chan SflowPacket
 	eventType  string
+	schema     flowSchema
+	clock      *exporterClock
+	netInfo    *netinfo.NetInfo
 	nr         newrelic.Application
 }
 
-type SflowPacket []byte
+type SflowPacket struct {
+	Received time.Time
+	Data     []byte
+}
 
 /******************************************************************************
  *
@@ -61,13 +83,13 @@ func (h *SflowHandler) Start() {
 		util.LogIfErr(parseSegment.End())
 
 		decodeSegment := newrelic.StartSegment(txn, "DecodeLayers")
-		err := parser.DecodeLayers(packet, &decoded)
+		err := parser.DecodeLayers(packet.Data, &decoded)
 
 		util.LogIfErr(decodeSegment.End())
 
 		if err != nil {
 			log.Warnf("SflowHandler: Unable to create decoder: %v", err)
-			log.Debugf("%s", hex.Dump(packet))
+			log.Debugf("%s", hex.Dump(packet.Data))
 
 			util.LogIfErr(txn.NoticeError(err))
 			util.LogIfErr(txn.End())
@@ -77,7 +99,7 @@ func (h *SflowHandler) Start() {
 
 		util.LogIfErr(txn.AddAttribute("agent", sflow.AgentAddress.String()))
 
-		err = h.makeEvents(sflow, txn)
+		err = h.makeEvents(sflow, packet.Received, txn)
 		if err != nil {
 			log.Errorf("SflowHandler: Failed to make events with error: %v", err)
 			util.LogIfErr(txn.NoticeError(err))
@@ -95,16 +117,39 @@ func (h *SflowHandler) Start() {
  * Process Sflow packet
  *
  ******************************************************************************/
-func (h *SflowHandler) makeEvents(sflow layers.SFlowDatagram, txn newrelic.Transaction) error {
+func (h *SflowHandler) makeEvents(sflow layers.SFlowDatagram, received time.Time, txn newrelic.Transaction) error {
 	eventsSegment := newrelic.StartSegment(txn, "MakeEvents")
 
+	if received.IsZero() {
+		received = time.Now()
+	}
+
+	// Every sample in a datagram was sent at the same time
+	timestamp := received
+
+	if h.clock != nil {
+		timestamp = h.clock.sflow(sflow.AgentAddress.String(), received, sflow.AgentUptime)
+	}
+
 	for _, sample := range sflow.FlowSamples {
-		rec := make(map[string]interface{})
-		rec["eventType"] = h.eventType
-		rec["timestamp"] = time.Now()
-		rec["agent"] = sflow.AgentAddress.String()
-		rec["agentAddress"] = sflow.AgentAddress.String() // TODO: REMOVE THIS!
-		rec["samplingRate"] = int32(sample.SamplingRate)
+		rec := flowrecord.New(h.eventType, flowrecord.FlowTypeSflow, sflow.AgentAddress.String(), timestamp)
+		rec.Received = received
+		rec.Set("agent", sflow.AgentAddress.String())
+		rec.Set("agentAddress", sflow.AgentAddress.String()) // TODO: REMOVE THIS!
+		rec.Set("samplingRate", int32(sample.SamplingRate))
+
+		rec.InputInterface = sflowInterfaceIndex(sample, sample.InputInterfaceFormat, sample.InputInterface)
+		rec.OutputInterface = sflowInterfaceIndex(sample, sample.OutputInterfaceFormat, sample.OutputInterface)
+		rec.Packets = 1
+		rec.SamplingRate = sample.SamplingRate
+
+		if rec.InputInterface != 0 {
+			rec.Set("inputInterface", int64(rec.InputInterface))
+		}
+
+		if rec.OutputInterface != 0 {
+			rec.Set("outputInterface", int64(rec.OutputInterface))
+		}
 
 		for _, record := range sample.GetRecords() {
 			//nolint:gocritic
@@ -116,50 +161,93 @@ func (h *SflowHandler) makeEvents(sflow layers.SFlowDatagram, txn newrelic.Trans
 				for _, layer := range packet.Layers() {
 					switch layer.LayerType() {
 					case layers.LayerTypeDot1Q:
-						rec["dot1qVLAN"] = int32(layer.(*layers.Dot1Q).VLANIdentifier)
-						rec["dot1qNextLayer"] = layer.(*layers.Dot1Q).NextLayerType().String()
+						rec.Set("dot1qVLAN", int32(layer.(*layers.Dot1Q).VLANIdentifier))
+						rec.Set("dot1qNextLayer", layer.(*layers.Dot1Q).NextLayerType().String())
 					case layers.LayerTypeIPv4:
-						rec["length"] = int64(packet.NetworkLayer().(*layers.IPv4).Length)
-						rec["networkDestinationAddress"] = packet.NetworkLayer().NetworkFlow().Dst().String()
-						rec["networkFlowHash"] = util.Uint64ToS(packet.NetworkLayer().NetworkFlow().FastHash())
-						rec["networkNextLayer"] = layer.(*layers.IPv4).NextLayerType().String()
-						rec["networkSourceAddress"] = packet.NetworkLayer().NetworkFlow().Src().String()
-						rec["networkType"] = packet.NetworkLayer().LayerType().String()
-						rec["scaledByteCount"] = rec["length"].(int64) * int64(sample.SamplingRate)
-
+						length := int64(packet.NetworkLayer().(*layers.IPv4).Length)
+
+						rec.Set("length", length)
+						rec.Set("networkDestinationAddress", packet.NetworkLayer().NetworkFlow().Dst().String())
+						rec.Set("networkFlowHash", util.Uint64ToS(packet.NetworkLayer().NetworkFlow().FastHash()))
+						rec.Set("networkNextLayer", layer.(*layers.IPv4).NextLayerType().String())
+						rec.Set("networkSourceAddress", packet.NetworkLayer().NetworkFlow().Src().String())
+						rec.Set("networkType", packet.NetworkLayer().LayerType().String())
+						rec.Set("scaledByteCount", length*int64(sample.SamplingRate))
+
+						sflowIPv4Header(layer.(*layers.IPv4)).addAttributes(rec)
+
+						rec.Bytes = uint64(length)
+						rec.SourceAddress = layer.(*layers.IPv4).SrcIP
+						rec.DestinationAddress = layer.(*layers.IPv4).DstIP
+						rec.HasProtocol = true
+						rec.Protocol = uint8(layer.(*layers.IPv4).Protocol)
+					case layers.LayerTypeIPv6:
+						length := int64(layer.(*layers.IPv6).Length) + ipv6HeaderLength
+
+						rec.Set("length", length)
+						rec.Set("networkDestinationAddress", packet.NetworkLayer().NetworkFlow().Dst().String())
+						rec.Set("networkFlowHash", util.Uint64ToS(packet.NetworkLayer().NetworkFlow().FastHash()))
+						rec.Set("networkNextLayer", layer.(*layers.IPv6).NextLayerType().String())
+						rec.Set("networkSourceAddress", packet.NetworkLayer().NetworkFlow().Src().String())
+						rec.Set("networkType", packet.NetworkLayer().LayerType().String())
+						rec.Set("scaledByteCount", length*int64(sample.SamplingRate))
+
+						sflowIPv6Header(layer.(*layers.IPv6), packet).addAttributes(rec)
+
+						rec.Bytes = uint64(length)
+						rec.SourceAddress = layer.(*layers.IPv6).SrcIP
+						rec.DestinationAddress = layer.(*layers.IPv6).DstIP
+						rec.HasProtocol = true
+						rec.Protocol = uint8(layer.(*layers.IPv6).NextHeader)
 					case layers.LayerTypeEthernet:
-						rec["linkSourceAddress"] = packet.LinkLayer().LinkFlow().Src().String()
-						rec["linkDestinationAddress"] = packet.LinkLayer().LinkFlow().Dst().String()
-						rec["linkFlowHash"] = util.Uint64ToS(packet.LinkLayer().LinkFlow().FastHash())
-						rec["linkType"] = packet.LinkLayer().LayerType().String()
-						rec["linkNextLayer"] = layer.(*layers.Ethernet).NextLayerType().String()
+						rec.Set("linkSourceAddress", packet.LinkLayer().LinkFlow().Src().String())
+						rec.Set("linkDestinationAddress", packet.LinkLayer().LinkFlow().Dst().String())
+						rec.Set("linkFlowHash", util.Uint64ToS(packet.LinkLayer().LinkFlow().FastHash()))
+						rec.Set("linkType", packet.LinkLayer().LayerType().String())
+						rec.Set("linkNextLayer", layer.(*layers.Ethernet).NextLayerType().String())
+
+						rec.HardwareAddresses = map[string]net.HardwareAddr{
+							"linkSourceAddress":      layer.(*layers.Ethernet).SrcMAC,
+							"linkDestinationAddress": layer.(*layers.Ethernet).DstMAC,
+						}
 					case layers.LayerTypeTCP:
-						rec["transportSourcePort"] = packet.TransportLayer().TransportFlow().Src().String()
-						rec["transportDestinationPort"] = packet.TransportLayer().TransportFlow().Dst().String()
-						rec["transportFlowHash"] = util.Uint64ToS(packet.TransportLayer().TransportFlow().FastHash())
-						rec["transportType"] = packet.TransportLayer().LayerType().String()
-						rec["combinedHash"] = util.Uint64ToS(util.CombinedHash(packet))
-						rec["transportWindowSize"] = int32(layer.(*layers.TCP).Window)
+						rec.Set("transportSourcePort", packet.TransportLayer().TransportFlow().Src().String())
+						rec.Set("transportDestinationPort", packet.TransportLayer().TransportFlow().Dst().String())
+						rec.Set("transportFlowHash", util.Uint64ToS(packet.TransportLayer().TransportFlow().FastHash()))
+						rec.Set("transportType", packet.TransportLayer().LayerType().String())
+						rec.Set("combinedHash", util.Uint64ToS(util.CombinedHash(packet)))
+						rec.Set("transportWindowSize", int32(layer.(*layers.TCP).Window))
+
+						addTCPHeader(rec, layer.(*layers.TCP))
+
+						rec.SourcePort = uint16(layer.(*layers.TCP).SrcPort)
+						rec.DestinationPort = uint16(layer.(*layers.TCP).DstPort)
 					case layers.LayerTypeUDP:
-						rec["transportSourcePort"] = packet.TransportLayer().TransportFlow().Src().String()
-						rec["transportDestinationPort"] = packet.TransportLayer().TransportFlow().Dst().String()
-						rec["transportFlowHash"] = util.Uint64ToS(packet.TransportLayer().TransportFlow().FastHash())
-						rec["transportType"] = packet.TransportLayer().LayerType().String()
-						rec["combinedHash"] = util.Uint64ToS(util.CombinedHash(packet))
+						rec.Set("transportSourcePort", packet.TransportLayer().TransportFlow().Src().String())
+						rec.Set("transportDestinationPort", packet.TransportLayer().TransportFlow().Dst().String())
+						rec.Set("transportFlowHash", util.Uint64ToS(packet.TransportLayer().TransportFlow().FastHash()))
+						rec.Set("transportType", packet.TransportLayer().LayerType().String())
+						rec.Set("combinedHash", util.Uint64ToS(util.CombinedHash(packet)))
+
+						rec.SourcePort = uint16(layer.(*layers.UDP).SrcPort)
+						rec.DestinationPort = uint16(layer.(*layers.UDP).DstPort)
 					}
 				}
 
 			case layers.SFlowExtendedGatewayFlowRecord:
 				util.LogIfErr(txn.AddAttribute("SFlowExtendedSwitchFlowRecord", true))
 
-				rec["nextHop"] = record.NextHop.String()
-				rec["AS"] = record.AS
-				rec["sourceAS"] = record.SourceAS
-				rec["peerAS"] = record.PeerAS
-				rec["ASPathCount"] = record.ASPathCount
-				//rec["ASPath"] = record.ASPath
+				rec.Set("nextHop", record.NextHop.String())
+				rec.Set("AS", record.AS)
+				rec.Set("sourceAS", record.SourceAS)
+				rec.Set("peerAS", record.PeerAS)
+				rec.Set("ASPathCount", record.ASPathCount)
+				//rec.Set("ASPath", record.ASPath)
 				//rec["communities"]
-				rec["localPref"] = record.LocalPref
+				rec.Set("localPref", record.LocalPref)
+
+				rec.SourceAS = record.SourceAS
+				rec.DestinationAS = sflowDestinationAS(record)
 
 			case layers.SFlowExtendedSwitchFlowRecord:
 				util.LogIfErr(txn.AddAttribute("SFlowExtendedSwitchFlowRecord", true))
@@ -180,12 +268,56 @@ func (h *SflowHandler) makeEvents(sflow layers.SFlowDatagram, txn newrelic.Trans
 			}
 		}
 
-		// Send off the event
+		enrichSegment := newrelic.StartSegment(txn, "EnrichRecord")
+		h.netInfo.Enrich(rec)
+
+		util.LogIfErr(enrichSegment.End())
+
+		// Send off the events
 		queueSegment := newrelic.StartSegment(txn, "QueueForEmit")
-		h.resultChan <- rec
+		h.schema.queue(h.resultChan, h.netInfo, rec)
 
 		util.LogIfErr(queueSegment.End())
 	}
 
 	return eventsSegment.End()
 }
+
+/******************************************************************************
+ *
+ * Interface index, 0 when it is not known.  Samples of discarded packets or
+ * of packets sent out of several interfaces carry a reason code or an
+ * interface count instead, which is not an index either.  Expanded samples
+ * have the format in its own field, compact ones in the top two bits.
+ *
+ ******************************************************************************/
+func sflowInterfaceIndex(sample layers.SFlowFlowSample, format uint32, value uint32) uint32 {
+	if sample.Format == layers.SFlowTypeExpandedFlowSample {
+		if format != 0 {
+			return 0
+		}
+
+		return value
+	}
+
+	if value>>sflowInterfaceFormatShift != 0 || value == sflowInterfaceUnknown {
+		return 0
+	}
+
+	return value
+}
+
+/******************************************************************************
+ *
+ * Destination AS is the last member of the AS path, if there is one
+ *
+ ******************************************************************************/
+func sflowDestinationAS(record layers.SFlowExtendedGatewayFlowRecord) uint32 {
+	for i := len(record.ASPath) - 1; i >= 0; i-- {
+		if members := record.ASPath[i].Members; len(members) > 0 {
+			return members[len(members)-1]
+		}
+	}
+
+	return 0
+}
@@ -2,8 +2,28 @@ package flowhandler
 
 import (
 	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestSflow(t *testing.T) {
 
 }
+
+func TestSflowInterfaceIndex(t *testing.T) {
+	compact := layers.SFlowFlowSample{Format: layers.SFlowTypeFlowSample}
+
+	assert.Equal(t, uint32(7), sflowInterfaceIndex(compact, 0, 7))
+	assert.Equal(t, uint32(0), sflowInterfaceIndex(compact, 0, sflowInterfaceUnknown))
+
+	// Discarded packets carry a reason code, and multiple interfaces a count
+	assert.Equal(t, uint32(0), sflowInterfaceIndex(compact, 0, 1<<30|258))
+	assert.Equal(t, uint32(0), sflowInterfaceIndex(compact, 0, 2<<30|3))
+
+	// Expanded samples have the whole value for the index, and the format apart
+	expanded := layers.SFlowFlowSample{Format: layers.SFlowTypeExpandedFlowSample}
+	assert.Equal(t, uint32(1<<30|7), sflowInterfaceIndex(expanded, 0, 1<<30|7))
+	assert.Equal(t, uint32(0), sflowInterfaceIndex(expanded, 1, 258))
+	assert.Equal(t, uint32(0), sflowInterfaceIndex(expanded, 2, 3))
+}
@@ -0,0 +1,91 @@
+package flowhandler
+
+import (
+	"encoding/binary"
+
+	"github.com/google/gopacket/layers"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// TCP control bits, as carried in IPFIX tcpControlBits
+const (
+	tcpFlagFIN uint16 = 0x0001
+	tcpFlagSYN uint16 = 0x0002
+	tcpFlagRST uint16 = 0x0004
+	tcpFlagPSH uint16 = 0x0008
+	tcpFlagACK uint16 = 0x0010
+	tcpFlagURG uint16 = 0x0020
+	tcpFlagECE uint16 = 0x0040
+	tcpFlagCWR uint16 = 0x0080
+	tcpFlagNS  uint16 = 0x0100
+)
+
+/******************************************************************************
+ *
+ * Add a tcpFlag attribute for every control bit, set or not
+ *
+ ******************************************************************************/
+func addTCPFlags(rec *flowrecord.FlowRecord, bits uint16) {
+	rec.Set("tcpFlagNS", bits&tcpFlagNS == tcpFlagNS)
+	rec.Set("tcpFlagCWR", bits&tcpFlagCWR == tcpFlagCWR)
+	rec.Set("tcpFlagECE", bits&tcpFlagECE == tcpFlagECE)
+	rec.Set("tcpFlagURG", bits&tcpFlagURG == tcpFlagURG)
+	rec.Set("tcpFlagACK", bits&tcpFlagACK == tcpFlagACK)
+	rec.Set("tcpFlagPSH", bits&tcpFlagPSH == tcpFlagPSH)
+	rec.Set("tcpFlagRST", bits&tcpFlagRST == tcpFlagRST)
+	rec.Set("tcpFlagSYN", bits&tcpFlagSYN == tcpFlagSYN)
+	rec.Set("tcpFlagFIN", bits&tcpFlagFIN == tcpFlagFIN)
+}
+
+// tcpControlBits packs the flags of a decoded header the way IPFIX does
+func tcpControlBits(tcp *layers.TCP) uint16 {
+	var bits uint16
+
+	flags := []struct {
+		set bool
+		bit uint16
+	}{
+		{tcp.FIN, tcpFlagFIN}, {tcp.SYN, tcpFlagSYN}, {tcp.RST, tcpFlagRST},
+		{tcp.PSH, tcpFlagPSH}, {tcp.ACK, tcpFlagACK}, {tcp.URG, tcpFlagURG},
+		{tcp.ECE, tcpFlagECE}, {tcp.CWR, tcpFlagCWR}, {tcp.NS, tcpFlagNS},
+	}
+
+	for _, flag := range flags {
+		if flag.set {
+			bits |= flag.bit
+		}
+	}
+
+	return bits
+}
+
+/******************************************************************************
+ *
+ * TCP attributes of a sampled header: flags, sequence numbers and the
+ * handshake options
+ *
+ ******************************************************************************/
+func addTCPHeader(rec *flowrecord.FlowRecord, tcp *layers.TCP) {
+	addTCPFlags(rec, tcpControlBits(tcp))
+
+	rec.Set("tcpSequenceNumber", int64(tcp.Seq))
+
+	// The acknowledgment field is only meaningful with ACK set
+	if tcp.ACK {
+		rec.Set("tcpAcknowledgmentNumber", int64(tcp.Ack))
+	}
+
+	for _, option := range tcp.Options {
+		switch option.OptionType {
+		case layers.TCPOptionKindMSS:
+			if len(option.OptionData) == 2 {
+				rec.Set("tcpMSS", int32(binary.BigEndian.Uint16(option.OptionData)))
+			}
+		case layers.TCPOptionKindWindowScale:
+			if len(option.OptionData) == 1 {
+				rec.Set("tcpWindowScale", int32(option.OptionData[0]))
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package flowhandler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestTCPControlBits(t *testing.T) {
+	assert.Equal(t, tcpFlagSYN, tcpControlBits(&layers.TCP{SYN: true}))
+	assert.Equal(t, tcpFlagSYN|tcpFlagACK|tcpFlagECE, tcpControlBits(&layers.TCP{SYN: true, ACK: true, ECE: true}))
+	assert.Equal(t, tcpFlagNS|tcpFlagFIN, tcpControlBits(&layers.TCP{NS: true, FIN: true}))
+}
+
+func TestAddTCPHeader(t *testing.T) {
+	rec := flowrecord.New("sflow", flowrecord.FlowTypeSflow, "192.0.2.1", time.Time{})
+
+	addTCPHeader(rec, &layers.TCP{
+		SYN: true,
+		Seq: 3000000000,
+		Ack: 12345,
+		Options: []layers.TCPOption{
+			{OptionType: layers.TCPOptionKindMSS, OptionLength: 4, OptionData: []byte{0x05, 0xb4}},
+			{OptionType: layers.TCPOptionKindNop, OptionLength: 1},
+			{OptionType: layers.TCPOptionKindWindowScale, OptionLength: 3, OptionData: []byte{7}},
+		},
+	})
+
+	assert.Equal(t, map[string]interface{}{
+		"tcpFlagNS":         false,
+		"tcpFlagCWR":        false,
+		"tcpFlagECE":        false,
+		"tcpFlagURG":        false,
+		"tcpFlagACK":        false,
+		"tcpFlagPSH":        false,
+		"tcpFlagRST":        false,
+		"tcpFlagSYN":        true,
+		"tcpFlagFIN":        false,
+		"tcpSequenceNumber": int64(3000000000),
+		"tcpMSS":            int32(1460),
+		"tcpWindowScale":    int32(7),
+	}, rec.Fields)
+
+	rec = flowrecord.New("sflow", flowrecord.FlowTypeSflow, "192.0.2.1", time.Time{})
+
+	addTCPHeader(rec, &layers.TCP{ACK: true, PSH: true, Seq: 1, Ack: 2})
+	assert.Equal(t, true, rec.Fields["tcpFlagACK"])
+	assert.Equal(t, true, rec.Fields["tcpFlagPSH"])
+	assert.Equal(t, int64(2), rec.Fields["tcpAcknowledgmentNumber"])
+	assert.NotContains(t, rec.Fields, "tcpMSS")
+}
@@ -0,0 +1,153 @@
+package flowhandler
+
+import (
+	"sync"
+	"time"
+
+	newrelic "github.com/newrelic/go-agent"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/util"
+)
+
+// Exporter clocks further than this from ours are reported
+const DefaultClockSkewThreshold = time.Minute
+
+/******************************************************************************
+ *
+ * Event timestamps come from the exporter, not from when we got around to
+ * handling a packet.  IPFIX messages carry the exporter's wall clock, which
+ * is checked against the time the packet was received.  sFlow only carries
+ * the agent's uptime, which is anchored to the receive time of the agent's
+ * least delayed datagram.
+ *
+ ******************************************************************************/
+type exporterClock struct {
+	threshold time.Duration
+	correct   bool
+	nr        newrelic.Application
+
+	lock   sync.Mutex
+	skewed map[string]bool      // Agents currently reported as skewed
+	boot   map[string]time.Time // sFlow agents' boot time on our clock
+	uptime map[string]uint32    // sFlow agents' highest uptime since boot
+}
+
+func newExporterClock(threshold time.Duration, correct bool, nr newrelic.Application) *exporterClock {
+	if threshold <= 0 {
+		threshold = DefaultClockSkewThreshold
+	}
+
+	return &exporterClock{
+		threshold: threshold,
+		correct:   correct,
+		nr:        nr,
+		skewed:    make(map[string]bool),
+		boot:      make(map[string]time.Time),
+		uptime:    make(map[string]uint32),
+	}
+}
+
+// messageClock places the exporter times of a single message on our clock
+type messageClock struct {
+	received time.Time
+	exported time.Time
+	offset   time.Duration // Added to exporter times, only when correcting skew
+	skew     time.Duration
+	skewed   bool
+}
+
+// stamp sets the event and receive times of a record
+func (m messageClock) stamp(rec *flowrecord.FlowRecord, exporterTime time.Time) {
+	rec.Timestamp = exporterTime.Add(m.offset)
+	rec.Received = m.received
+
+	if m.offset != 0 {
+		if !rec.Start.IsZero() {
+			rec.Start = rec.Start.Add(m.offset)
+		}
+
+		if !rec.End.IsZero() {
+			rec.End = rec.End.Add(m.offset)
+		}
+	}
+
+	if m.skewed {
+		rec.Enrich("exporterClockSkew", m.skew.Milliseconds())
+	}
+}
+
+/******************************************************************************
+ *
+ * Check an IPFIX export time against the receive time of the message
+ *
+ ******************************************************************************/
+func (c *exporterClock) ipfix(agent string, received time.Time, exported time.Time) messageClock {
+	m := messageClock{received: received, exported: exported, skew: exported.Sub(received)}
+	m.skewed = c.check(agent, m.skew)
+
+	if m.skewed && c.correct {
+		m.offset = -m.skew
+	}
+
+	return m
+}
+
+/******************************************************************************
+ *
+ * The time an sFlow datagram was sent, from the agent's uptime in
+ * milliseconds.  The anchor only moves earlier, as less delayed datagrams
+ * arrive, so a backlog never moves it later.  It is reset when the uptime
+ * goes back further than reordering explains, as the agent restarted or its
+ * uptime wrapped.
+ *
+ ******************************************************************************/
+func (c *exporterClock) sflow(agent string, received time.Time, uptime uint32) time.Time {
+	since := time.Duration(uptime) * time.Millisecond
+	estimate := received.Add(-since)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	boot, ok := c.boot[agent]
+	last := c.uptime[agent]
+
+	if ok && uptime < last && time.Duration(last-uptime)*time.Millisecond > c.threshold {
+		log.Debugf("flowHandler: sFlow agent %s restarted, uptime %v", agent, since)
+		ok = false
+	}
+
+	if !ok || estimate.Before(boot) {
+		boot = estimate
+		c.boot[agent] = boot
+	}
+
+	if !ok || uptime > last {
+		c.uptime[agent] = uptime
+	}
+
+	return boot.Add(since)
+}
+
+// check reports an agent's clock moving beyond or back within the threshold
+func (c *exporterClock) check(agent string, skew time.Duration) bool {
+	beyond := skew > c.threshold || skew < -c.threshold
+
+	c.lock.Lock()
+	reported := c.skewed[agent]
+	c.skewed[agent] = beyond
+	c.lock.Unlock()
+
+	if beyond && !reported {
+		log.Warnf("flowHandler: Exporter %s clock is off by %v", agent, skew)
+	} else if !beyond && reported {
+		log.Infof("flowHandler: Exporter %s clock is back within %v", agent, c.threshold)
+	}
+
+	if beyond && c.nr != nil {
+		util.LogIfErr(c.nr.RecordCustomMetric("exporterClockSkew", skew.Seconds()))
+	}
+
+	return beyond
+}
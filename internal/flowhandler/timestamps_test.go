@@ -0,0 +1,96 @@
+package flowhandler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestExporterClockIpfix(t *testing.T) {
+	clock := newExporterClock(0, false, nil)
+	assert.Equal(t, DefaultClockSkewThreshold, clock.threshold)
+
+	received := time.Unix(1600000000, 0)
+
+	// Within the threshold the exporter's times are used as they are
+	m := clock.ipfix("192.0.2.1", received, received.Add(-2*time.Second))
+	assert.False(t, m.skewed)
+
+	rec := flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", received)
+	m.stamp(rec, received.Add(-5*time.Second))
+	assert.Equal(t, received.Add(-5*time.Second), rec.Timestamp)
+	assert.Equal(t, received, rec.Received)
+	assert.Nil(t, rec.Enrichment)
+
+	// Beyond it the skew is reported on every record
+	m = clock.ipfix("192.0.2.1", received, received.Add(-10*time.Minute))
+	assert.True(t, m.skewed)
+	assert.True(t, clock.skewed["192.0.2.1"])
+
+	rec = flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", received)
+	m.stamp(rec, received.Add(-10*time.Minute))
+	assert.Equal(t, received.Add(-10*time.Minute), rec.Timestamp)
+	assert.Equal(t, int64(-600000), rec.Enrichment["exporterClockSkew"])
+
+	// And back again
+	m = clock.ipfix("192.0.2.1", received, received)
+	assert.False(t, m.skewed)
+	assert.False(t, clock.skewed["192.0.2.1"])
+}
+
+func TestExporterClockCorrect(t *testing.T) {
+	clock := newExporterClock(time.Minute, true, nil)
+
+	received := time.Unix(1600000000, 0)
+	exported := received.Add(time.Hour)
+
+	rec := flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, "192.0.2.1", received)
+	rec.Start = exported.Add(-30 * time.Second)
+	rec.End = exported.Add(-10 * time.Second)
+
+	clock.ipfix("192.0.2.1", received, exported).stamp(rec, rec.End)
+	assert.Equal(t, received.Add(-10*time.Second), rec.Timestamp)
+	assert.Equal(t, received.Add(-30*time.Second), rec.Start)
+	assert.Equal(t, received.Add(-10*time.Second), rec.End)
+	assert.Equal(t, int64(3600000), rec.Enrichment["exporterClockSkew"])
+}
+
+func TestExporterClockSflow(t *testing.T) {
+	clock := newExporterClock(time.Minute, false, nil)
+
+	received := time.Unix(1600000000, 0)
+	boot := received.Add(-time.Hour)
+
+	// The first datagram anchors the agent's boot time
+	assert.Equal(t, received, clock.sflow("192.0.2.1", received, 3600000))
+
+	// A datagram that sat in a queue keeps the time it was sent
+	sent := clock.sflow("192.0.2.1", received.Add(5*time.Second), 3601000)
+	assert.Equal(t, boot.Add(3601*time.Second), sent)
+
+	// A less delayed datagram moves the anchor earlier
+	assert.Equal(t, received.Add(time.Second), clock.sflow("192.0.2.1", received.Add(time.Second), 3602000))
+	assert.Equal(t, boot.Add(-time.Second), clock.boot["192.0.2.1"])
+
+	// A backlog longer than the threshold keeps the anchor
+	sent = clock.sflow("192.0.2.1", received.Add(5*time.Minute), 3603000)
+	assert.Equal(t, boot.Add(-time.Second).Add(3603*time.Second), sent)
+
+	// Reordered datagrams keep it too
+	sent = clock.sflow("192.0.2.1", received.Add(5*time.Minute), 3602500)
+	assert.Equal(t, boot.Add(-time.Second).Add(3602500*time.Millisecond), sent)
+	assert.Equal(t, uint32(3603000), clock.uptime["192.0.2.1"])
+
+	// A restart anchors again
+	later := received.Add(time.Hour)
+	assert.Equal(t, later, clock.sflow("192.0.2.1", later, 1000))
+	assert.Equal(t, later.Add(-time.Second), clock.boot["192.0.2.1"])
+
+	// And so does the uptime wrapping
+	wrapped := later.Add(time.Second)
+	clock.uptime["192.0.2.1"] = 4294967000
+	assert.Equal(t, wrapped, clock.sflow("192.0.2.1", wrapped, 500))
+}
@@ -0,0 +1,352 @@
+package flowrecord
+
+import (
+	"net"
+	"time"
+)
+
+// Flow types, the protocol a record was exported with
+const (
+	FlowTypeSflow = "sflow"
+	FlowTypeIpfix = "ipfix"
+)
+
+// IP protocols that carry ports
+const (
+	protocolTCP  uint8 = 6
+	protocolUDP  uint8 = 17
+	protocolSCTP uint8 = 132
+)
+
+/******************************************************************************
+ *
+ * Event is anything the emitters can send
+ *
+ ******************************************************************************/
+type Event interface {
+	EventType() string
+	Attributes() map[string]interface{}
+}
+
+// Attributes is an Event built directly from a map, for events that are not
+// single flows
+type Attributes map[string]interface{}
+
+func (a Attributes) EventType() string {
+	eventType, _ := a["eventType"].(string)
+	return eventType
+}
+
+func (a Attributes) Attributes() map[string]interface{} {
+	return a
+}
+
+/******************************************************************************
+ *
+ * FlowRecord is a single flow as it moves through the pipeline.  The fields
+ * every protocol has are typed, protocol specific attributes are kept in
+ * Fields under the names they are emitted with, and attributes added by
+ * enrichment are kept in Enrichment.
+ *
+ ******************************************************************************/
+type FlowRecord struct {
+	Type      string // Event type the record is emitted as
+	FlowType  string
+	Timestamp time.Time // When the flow was seen, on the exporter's clock
+	Agent     string
+
+	Received time.Time // When the collector received it
+
+	InputInterface  uint32
+	OutputInterface uint32
+
+	SourceAddress      net.IP
+	DestinationAddress net.IP
+	SourceAS           uint32
+	DestinationAS      uint32
+
+	HasProtocol     bool
+	Protocol        uint8
+	SourcePort      uint16
+	DestinationPort uint16
+
+	Bytes        uint64
+	Packets      uint64
+	SamplingRate uint32 // 0 when unsampled or unknown
+	Start        time.Time
+	End          time.Time
+
+	// Hardware addresses in the record, by attribute name
+	HardwareAddresses map[string]net.HardwareAddr
+
+	Fields     map[string]interface{}
+	Enrichment map[string]interface{}
+
+	normalized bool
+}
+
+func New(eventType string, flowType string, agent string, timestamp time.Time) *FlowRecord {
+	return &FlowRecord{
+		Type:      eventType,
+		FlowType:  flowType,
+		Timestamp: timestamp,
+		Agent:     agent,
+		Fields:    make(map[string]interface{}),
+	}
+}
+
+func (r *FlowRecord) EventType() string {
+	return r.Type
+}
+
+// Set a protocol specific attribute
+func (r *FlowRecord) Set(name string, value interface{}) {
+	r.Fields[name] = value
+}
+
+// Get an attribute, from enrichment first then the protocol specific ones
+func (r *FlowRecord) Get(name string) (interface{}, bool) {
+	if value, ok := r.Enrichment[name]; ok {
+		return value, true
+	}
+
+	value, ok := r.Fields[name]
+
+	return value, ok
+}
+
+// Delete a protocol specific attribute
+func (r *FlowRecord) Delete(name string) {
+	delete(r.Fields, name)
+}
+
+// Enrich adds an attribute that is emitted with every schema
+func (r *FlowRecord) Enrich(name string, value interface{}) {
+	if r.Enrichment == nil {
+		r.Enrichment = make(map[string]interface{})
+	}
+
+	r.Enrichment[name] = value
+}
+
+// HasPorts returns true if the protocol of the flow carries ports
+func (r *FlowRecord) HasPorts() bool {
+	return r.HasProtocol && (r.Protocol == protocolTCP || r.Protocol == protocolUDP || r.Protocol == protocolSCTP)
+}
+
+// Normalized returns a view of the record in the common schema, with its own
+// enrichment.  The protocol specific Fields are shared, and are not changed
+// once a record has been queued.
+func (r *FlowRecord) Normalized(eventType string) *FlowRecord {
+	view := *r
+	view.Type = eventType
+	view.normalized = true
+
+	if r.Enrichment != nil {
+		view.Enrichment = make(map[string]interface{}, len(r.Enrichment))
+		for k, v := range r.Enrichment {
+			view.Enrichment[k] = v
+		}
+	}
+
+	return &view
+}
+
+// IsNormalized returns true for records in the common schema
+func (r *FlowRecord) IsNormalized() bool {
+	return r.normalized
+}
+
+// Clone returns a copy of the record that can be changed independently
+func (r *FlowRecord) Clone() *FlowRecord {
+	clone := *r
+
+	clone.Fields = make(map[string]interface{}, len(r.Fields))
+	for k, v := range r.Fields {
+		clone.Fields[k] = v
+	}
+
+	if r.Enrichment != nil {
+		clone.Enrichment = make(map[string]interface{}, len(r.Enrichment))
+		for k, v := range r.Enrichment {
+			clone.Enrichment[k] = v
+		}
+	}
+
+	return &clone
+}
+
+// Scale is the sampling rate, 1 when unsampled or unknown
+func (r *FlowRecord) Scale() uint64 {
+	if r.SamplingRate > 0 {
+		return uint64(r.SamplingRate)
+	}
+
+	return 1
+}
+
+// ScaledBytes estimates the bytes the flow represents
+func (r *FlowRecord) ScaledBytes() uint64 {
+	return r.Bytes * r.Scale()
+}
+
+// ScaledPackets estimates the packets the flow represents
+func (r *FlowRecord) ScaledPackets() uint64 {
+	return r.Packets * r.Scale()
+}
+
+/******************************************************************************
+ *
+ * Value looks up an attribute by name.  The typed fields are known by their
+ * normalized schema names, whichever schema the record is emitted with, and
+ * anything else is looked up with Get.
+ *
+ ******************************************************************************/
+func (r *FlowRecord) Value(name string) (interface{}, bool) {
+	switch name {
+	case "flowType":
+		return r.FlowType, true
+	case "agent":
+		return r.Agent, true
+	case "srcAddr":
+		return r.SourceAddress.String(), r.SourceAddress != nil
+	case "dstAddr":
+		return r.DestinationAddress.String(), r.DestinationAddress != nil
+	case "protocol":
+		return int32(r.Protocol), r.HasProtocol
+	case "srcPort":
+		return int32(r.SourcePort), r.HasPorts()
+	case "dstPort":
+		return int32(r.DestinationPort), r.HasPorts()
+	case "inIf":
+		return int64(r.InputInterface), r.InputInterface != 0
+	case "outIf":
+		return int64(r.OutputInterface), r.OutputInterface != 0
+	case "srcAS":
+		return int64(r.SourceAS), r.SourceAS != 0
+	case "dstAS":
+		return int64(r.DestinationAS), r.DestinationAS != 0
+	case "bytes":
+		return int64(r.Bytes), true
+	case "packets":
+		return int64(r.Packets), true
+	case "scaledBytes":
+		return int64(r.ScaledBytes()), true
+	case "scaledPackets":
+		return int64(r.ScaledPackets()), true
+	case "samplingRate":
+		return int64(r.Scale()), true
+	}
+
+	return r.Get(name)
+}
+
+/******************************************************************************
+ *
+ * Render the record for emitting
+ *
+ ******************************************************************************/
+func (r *FlowRecord) Attributes() map[string]interface{} {
+	size := len(r.Fields) + len(r.Enrichment) + 3
+	if r.normalized {
+		size = len(r.Enrichment) + 20
+	}
+
+	attrs := make(map[string]interface{}, size)
+	r.writeAttributes(attrs)
+
+	return attrs
+}
+
+// writeAttributes renders the record into an empty map
+func (r *FlowRecord) writeAttributes(attrs map[string]interface{}) {
+	if r.normalized {
+		r.writeNormalizedAttributes(attrs)
+	} else {
+		for k, v := range r.Fields {
+			attrs[k] = v
+		}
+	}
+
+	for k, v := range r.Enrichment {
+		attrs[k] = v
+	}
+
+	attrs["eventType"] = r.Type
+	attrs["timestamp"] = r.Timestamp
+
+	if !r.Received.IsZero() {
+		attrs["receivedTimestamp"] = r.Received.UnixNano() / int64(time.Millisecond)
+	}
+}
+
+// Render returns the attributes of an event.  Flow records are rendered into
+// attrs, which the caller reuses for every event, so the result is only good
+// until the next call.
+func Render(event Event, attrs map[string]interface{}) map[string]interface{} {
+	rec, ok := event.(*FlowRecord)
+	if !ok {
+		return event.Attributes()
+	}
+
+	for k := range attrs {
+		delete(attrs, k)
+	}
+
+	rec.writeAttributes(attrs)
+
+	return attrs
+}
+
+// writeNormalizedAttributes is the common schema, the same for every protocol
+func (r *FlowRecord) writeNormalizedAttributes(attrs map[string]interface{}) {
+	attrs["flowType"] = r.FlowType
+	attrs["agent"] = r.Agent
+
+	if r.SourceAddress != nil {
+		attrs["srcAddr"] = r.SourceAddress.String()
+	}
+
+	if r.DestinationAddress != nil {
+		attrs["dstAddr"] = r.DestinationAddress.String()
+	}
+
+	if r.HasProtocol {
+		attrs["protocol"] = int32(r.Protocol)
+	}
+
+	if r.HasPorts() {
+		attrs["srcPort"] = int32(r.SourcePort)
+		attrs["dstPort"] = int32(r.DestinationPort)
+	}
+
+	if r.InputInterface != 0 {
+		attrs["inIf"] = int64(r.InputInterface)
+	}
+
+	if r.OutputInterface != 0 {
+		attrs["outIf"] = int64(r.OutputInterface)
+	}
+
+	if r.SourceAS != 0 {
+		attrs["srcAS"] = int64(r.SourceAS)
+	}
+
+	if r.DestinationAS != 0 {
+		attrs["dstAS"] = int64(r.DestinationAS)
+	}
+
+	attrs["bytes"] = int64(r.Bytes)
+	attrs["packets"] = int64(r.Packets)
+	attrs["samplingRate"] = int64(r.Scale())
+	attrs["scaledBytes"] = int64(r.ScaledBytes())
+	attrs["scaledPackets"] = int64(r.ScaledPackets())
+
+	if !r.Start.IsZero() {
+		attrs["flowStart"] = r.Start.UnixNano() / int64(time.Millisecond)
+	}
+
+	if !r.End.IsZero() {
+		attrs["flowEnd"] = r.End.UnixNano() / int64(time.Millisecond)
+	}
+}
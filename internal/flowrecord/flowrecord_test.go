@@ -0,0 +1,184 @@
+package flowrecord
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRawAttributes(t *testing.T) {
+	timestamp := time.Unix(1600000000, 0)
+
+	rec := New("sflow", FlowTypeSflow, "192.0.2.1", timestamp)
+	rec.Set("srcIP", "10.0.0.1")
+	rec.Set("bogus", 1)
+	rec.Delete("bogus")
+	rec.Enrich("direction", "outbound")
+	rec.Received = timestamp.Add(time.Second)
+
+	assert.Equal(t, map[string]interface{}{
+		"eventType":         "sflow",
+		"timestamp":         timestamp,
+		"receivedTimestamp": int64(1600000001000),
+		"srcIP":             "10.0.0.1",
+		"direction":         "outbound",
+	}, rec.Attributes())
+
+	value, ok := rec.Get("direction")
+	assert.True(t, ok)
+	assert.Equal(t, "outbound", value)
+
+	_, ok = rec.Get("bogus")
+	assert.False(t, ok)
+}
+
+func TestNormalizedAttributes(t *testing.T) {
+	timestamp := time.Unix(1600000000, 0)
+
+	rec := New("sflow", FlowTypeSflow, "192.0.2.1", timestamp)
+	rec.InputInterface = 3
+	rec.SourceAddress = net.ParseIP("10.0.0.1")
+	rec.DestinationAddress = net.ParseIP("10.0.0.2")
+	rec.HasProtocol = true
+	rec.Protocol = protocolTCP
+	rec.SourcePort = 51515
+	rec.DestinationPort = 443
+	rec.Bytes = 1500
+	rec.Packets = 1
+	rec.SamplingRate = 1000
+	rec.Set("srcIP", "10.0.0.1")
+	rec.Enrich("direction", "internal")
+
+	view := rec.Normalized("networkFlow")
+	assert.True(t, view.IsNormalized())
+	assert.False(t, rec.IsNormalized())
+
+	assert.Equal(t, map[string]interface{}{
+		"eventType":     "networkFlow",
+		"timestamp":     timestamp,
+		"flowType":      FlowTypeSflow,
+		"agent":         "192.0.2.1",
+		"srcAddr":       "10.0.0.1",
+		"dstAddr":       "10.0.0.2",
+		"protocol":      int32(6),
+		"srcPort":       int32(51515),
+		"dstPort":       int32(443),
+		"inIf":          int64(3),
+		"bytes":         int64(1500),
+		"packets":       int64(1),
+		"samplingRate":  int64(1000),
+		"scaledBytes":   int64(1500000),
+		"scaledPackets": int64(1000),
+		"direction":     "internal",
+	}, view.Attributes())
+
+	// Unsampled flows with times, and no ports for ICMP
+	rec.Protocol = 1
+	rec.SamplingRate = 0
+	rec.Bytes = 84
+	rec.Start = timestamp.Add(-time.Second)
+	rec.End = timestamp
+
+	attrs := rec.Normalized("networkFlow").Attributes()
+	assert.NotContains(t, attrs, "srcPort")
+	assert.Equal(t, int64(1), attrs["samplingRate"])
+	assert.Equal(t, int64(84), attrs["scaledBytes"])
+	assert.Equal(t, int64(1599999999000), attrs["flowStart"])
+	assert.Equal(t, int64(1600000000000), attrs["flowEnd"])
+
+	// Enriching one schema does not change the other
+	view = rec.Normalized("networkFlow")
+	view.Enrich("duplicate", true)
+	rec.Enrich("direction", "outbound")
+	assert.NotContains(t, rec.Enrichment, "duplicate")
+	assert.Equal(t, "internal", view.Enrichment["direction"])
+}
+
+func TestValue(t *testing.T) {
+	rec := New("ipfix", FlowTypeIpfix, "192.0.2.1", time.Now())
+	rec.SourceAddress = net.ParseIP("10.0.0.1")
+	rec.HasProtocol = true
+	rec.Protocol = protocolUDP
+	rec.DestinationPort = 53
+	rec.Bytes = 100
+	rec.SamplingRate = 10
+	rec.Set("dstPort", "shadowed")
+	rec.Set("vlanId", uint16(10))
+	rec.Enrich("direction", "inbound")
+
+	tests := []struct {
+		name     string
+		expected interface{}
+		found    bool
+	}{
+		{"agent", "192.0.2.1", true},
+		{"srcAddr", "10.0.0.1", true},
+		{"protocol", int32(17), true},
+		{"dstPort", int32(53), true},
+		{"scaledBytes", int64(1000), true},
+		{"direction", "inbound", true},
+		{"vlanId", uint16(10), true},
+		{"dstAS", int64(0), false},
+		{"bogus", nil, false},
+	}
+
+	for _, test := range tests {
+		value, ok := rec.Value(test.name)
+		assert.Equal(t, test.found, ok, test.name)
+
+		if test.found {
+			assert.Equal(t, test.expected, value, test.name)
+		}
+	}
+
+	_, ok := New("sflow", FlowTypeSflow, "192.0.2.1", time.Now()).Value("dstAddr")
+	assert.False(t, ok)
+}
+
+func TestClone(t *testing.T) {
+	rec := New("ipfix", FlowTypeIpfix, "192.0.2.1", time.Now())
+	rec.Set("sourceIPv4Address", "10.0.0.1")
+
+	clone := rec.Clone()
+	clone.Set("sourceIPv4Address", "10.0.0.2")
+	clone.Enrich("threatMatch", true)
+
+	assert.Equal(t, "10.0.0.1", rec.Fields["sourceIPv4Address"])
+	assert.Nil(t, rec.Enrichment)
+	assert.Equal(t, true, clone.Enrichment["threatMatch"])
+}
+
+func TestRender(t *testing.T) {
+	timestamp := time.Unix(1600000000, 0)
+
+	rec := New("sflow", FlowTypeSflow, "192.0.2.1", timestamp)
+	rec.Set("srcIP", "10.0.0.1")
+
+	attrs := make(map[string]interface{})
+	attrs["stale"] = true
+
+	assert.Equal(t, rec.Attributes(), Render(rec, attrs))
+	assert.NotContains(t, attrs, "stale")
+
+	view := rec.Normalized("networkFlow")
+	assert.Equal(t, view.Attributes(), Render(view, attrs))
+	assert.NotContains(t, attrs, "srcIP")
+
+	event := Attributes{"eventType": "networkAttack"}
+	assert.Equal(t, event.Attributes(), Render(event, attrs))
+
+	// Rendering into a used map does not allocate a new one every time
+	rendered := testing.AllocsPerRun(100, func() { Render(rec, attrs) })
+	allocated := testing.AllocsPerRun(100, func() { rec.Attributes() })
+	assert.Less(t, rendered, allocated)
+}
+
+func TestAttributesEvent(t *testing.T) {
+	event := Attributes{"eventType": "networkAttack", "bytes": 1}
+	assert.Equal(t, "networkAttack", event.EventType())
+	assert.Equal(t, 1, event.Attributes()["bytes"])
+
+	assert.Equal(t, "", Attributes{}.EventType())
+}
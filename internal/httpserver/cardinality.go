@@ -0,0 +1,73 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"github.com/newrelic/nri-network-telemetry/internal/pipeline"
+)
+
+const cardinalityDefaultLimit = 10
+
+/******************************************************************************
+ *
+ * Cardinality handlers
+ *
+ ******************************************************************************/
+func (s *Server) cardinalityIndexHandler(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, http.StatusOK, map[string][]string{
+		"dimensions": pipeline.CardinalityDimensions,
+		"intervals":  {"last", "current"},
+	})
+}
+
+// cardinalityHandler lists the keys of a dimension, with the query parameters
+// interval, key, limit and minimum
+func (s *Server) cardinalityHandler(rw http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	limit := cardinalityDefaultLimit
+
+	var current bool
+
+	switch interval := params.Get("interval"); interval {
+	case "", "last":
+		current = false
+	case "current":
+		current = true
+	default:
+		writeError(rw, http.StatusBadRequest, "invalid interval '"+interval+"'")
+		return
+	}
+
+	if value := params.Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeError(rw, http.StatusBadRequest, "invalid limit '"+value+"'")
+			return
+		}
+
+		limit = n
+	}
+
+	var minimum uint64
+
+	if value := params.Get("minimum"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "invalid minimum '"+value+"'")
+			return
+		}
+
+		minimum = n
+	}
+
+	result, err := s.pipeline.Cardinality().Query(mux.Vars(r)["dimension"], current, params.Get("key"), minimum, limit)
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, result)
+}
@@ -0,0 +1,65 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/pipeline"
+)
+
+func TestCardinalityHandler(t *testing.T) {
+	flowPipeline, err := pipeline.New(pipeline.Config{
+		EventTypes:  []string{"sflow"},
+		Cardinality: pipeline.CardinalityConfig{Enabled: true},
+	}, make(chan flowrecord.Event, 10), nil)
+	assert.NoError(t, err)
+
+	for i := 0; i < 20; i++ {
+		rec := flowrecord.New("sflow", flowrecord.FlowTypeSflow, "192.0.2.1", time.Now())
+		rec.SourceAddress = net.ParseIP(fmt.Sprintf("10.0.0.%d", i))
+		rec.DestinationAddress = net.ParseIP("192.0.2.7")
+
+		flowPipeline.Cardinality().Process(rec, func(flowrecord.Event) {})
+	}
+
+	s := New("test", "127.0.0.1", 0, flowPipeline, nil)
+
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest("GET", "/cardinality", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Contains(t, rw.Body.String(), `"intervals":["last","current"]`)
+
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest("GET", "/cardinality/sourcesPerDestination?interval=current&minimum=10", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	var result pipeline.CardinalityResult
+	assert.NoError(t, json.Unmarshal(rw.Body.Bytes(), &result))
+	assert.Equal(t, "sourcesPerDestination", result.Dimension)
+	assert.Equal(t, []pipeline.CardinalityEntry{{Key: "192.0.2.7", Estimate: 20, Flows: 20}}, result.Entries)
+
+	// Nothing closed yet
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest("GET", "/cardinality/sourcesPerDestination", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Contains(t, rw.Body.String(), `"entries":[]`)
+
+	for _, url := range []string{"/cardinality/bogus", "/cardinality/portsPerSource?interval=x", "/cardinality/portsPerSource?limit=-1", "/cardinality/portsPerSource?minimum=x"} {
+		rw = httptest.NewRecorder()
+		s.ServeHTTP(rw, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, http.StatusBadRequest, rw.Code, url)
+	}
+
+	// Without the stage there are no routes
+	rw = httptest.NewRecorder()
+	New("test", "127.0.0.1", 0, nil, nil).ServeHTTP(rw, httptest.NewRequest("GET", "/cardinality", nil))
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
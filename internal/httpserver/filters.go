@@ -0,0 +1,18 @@
+package httpserver
+
+import (
+	"net/http"
+)
+
+/******************************************************************************
+ *
+ * Filter handlers
+ *
+ ******************************************************************************/
+
+// filtersHandler lists the filters with how often each matched
+func (s *Server) filtersHandler(rw http.ResponseWriter, r *http.Request) {
+	writeJSON(rw, http.StatusOK, map[string]interface{}{
+		"filters": s.pipeline.Filter().Status(),
+	})
+}
@@ -0,0 +1,53 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/pipeline"
+)
+
+func TestFiltersHandler(t *testing.T) {
+	fileh, err := ioutil.TempFile("", "httpserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(fileh.Name())
+
+	_, err = fileh.WriteString(`{"filters": [{"name": "small", "eventType": "sflow", "action": "exclude", "expression": "bytes < 100"}]}`)
+	assert.NoError(t, err)
+	fileh.Close()
+
+	flowPipeline, err := pipeline.New(pipeline.Config{
+		EventTypes: []string{"sflow"},
+		Filter:     pipeline.FilterConfig{File: fileh.Name()},
+	}, make(chan flowrecord.Event, 10), nil)
+	assert.NoError(t, err)
+
+	flowPipeline.Filter().Process(flowrecord.Attributes{"eventType": "sflow", "bytes": 10}, func(flowrecord.Event) {})
+
+	s := New("test", "127.0.0.1", 0, flowPipeline, nil)
+
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest("GET", "/filters", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+
+	var body struct {
+		Filters []pipeline.FilterStatus `json:"filters"`
+	}
+
+	assert.NoError(t, json.Unmarshal(rw.Body.Bytes(), &body))
+	assert.Equal(t, []pipeline.FilterStatus{{Name: "small", EventType: "sflow", Action: "exclude", Expression: "bytes < 100", Evaluated: 1, Matched: 1}}, body.Filters)
+
+	// Without filters there is no route
+	rw = httptest.NewRecorder()
+	New("test", "127.0.0.1", 0, nil, nil).ServeHTTP(rw, httptest.NewRequest("GET", "/filters", nil))
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
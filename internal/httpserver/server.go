@@ -10,6 +10,8 @@ import (
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
+
+	"github.com/newrelic/nri-network-telemetry/internal/pipeline"
 )
 
 type ControlMessage int
@@ -27,6 +29,9 @@ type Server struct {
 	port    int
 	handler http.Handler
 	nr      newrelic.Application
+
+	// Live data from the flow pipeline, the routes are only added for enabled stages
+	pipeline *pipeline.Pipeline
 }
 
 /******************************************************************************
@@ -34,12 +39,13 @@ type Server struct {
  * Create a new HTTPserver instance
  *
  ******************************************************************************/
-func New(version string, address string, port int, nr newrelic.Application) *Server {
+func New(version string, address string, port int, flowPipeline *pipeline.Pipeline, nr newrelic.Application) *Server {
 	return (&Server{
-		version: version,
-		address: address,
-		port:    port,
-		nr:      nr,
+		version:  version,
+		address:  address,
+		port:     port,
+		nr:       nr,
+		pipeline: flowPipeline,
 	}).initializeHandler()
 }
 
@@ -55,6 +61,20 @@ func (s *Server) initializeHandler() *Server {
 	router.HandleFunc(newrelic.WrapHandleFunc(s.nr, "/version", s.versionHandler)).Methods("GET")
 	router.HandleFunc(newrelic.WrapHandleFunc(s.nr, "/status/check", s.statusCheckHandler)).Methods("GET")
 
+	if s.pipeline.TopTalkers() != nil {
+		router.HandleFunc(newrelic.WrapHandleFunc(s.nr, "/topn", s.topNIndexHandler)).Methods("GET")
+		router.HandleFunc(newrelic.WrapHandleFunc(s.nr, "/topn/{dimension}", s.topNHandler)).Methods("GET")
+	}
+
+	if s.pipeline.Cardinality() != nil {
+		router.HandleFunc(newrelic.WrapHandleFunc(s.nr, "/cardinality", s.cardinalityIndexHandler)).Methods("GET")
+		router.HandleFunc(newrelic.WrapHandleFunc(s.nr, "/cardinality/{dimension}", s.cardinalityHandler)).Methods("GET")
+	}
+
+	if s.pipeline.Filter() != nil {
+		router.HandleFunc(newrelic.WrapHandleFunc(s.nr, "/filters", s.filtersHandler)).Methods("GET")
+	}
+
 	// Wrap all requests with the logging handler (apache-like logs)
 	s.handler = handlers.LoggingHandler(os.Stdout, router)
 
@@ -0,0 +1,113 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/pipeline"
+)
+
+const (
+	topNDefaultWindow = "1m"
+	topNDefaultLimit  = 10
+)
+
+/******************************************************************************
+ *
+ * Top-N talker handlers
+ *
+ ******************************************************************************/
+func (s *Server) topNIndexHandler(rw http.ResponseWriter, r *http.Request) {
+	windows := make([]string, 0, len(pipeline.TopNWindows))
+	for window := range pipeline.TopNWindows {
+		windows = append(windows, window)
+	}
+
+	sort.Slice(windows, func(i, j int) bool {
+		return pipeline.TopNWindows[windows[i]] < pipeline.TopNWindows[windows[j]]
+	})
+
+	writeJSON(rw, http.StatusOK, map[string][]string{
+		"dimensions": pipeline.TopNDimensions,
+		"windows":    windows,
+	})
+}
+
+// topNHandler ranks a dimension, with the query parameters window, limit,
+// prefix, network and minBytes
+func (s *Server) topNHandler(rw http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	query := pipeline.TopNQuery{
+		Dimension: mux.Vars(r)["dimension"],
+		Window:    params.Get("window"),
+		Limit:     topNDefaultLimit,
+		Prefix:    params.Get("prefix"),
+	}
+
+	if query.Window == "" {
+		query.Window = topNDefaultWindow
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			writeError(rw, http.StatusBadRequest, "invalid limit '"+limit+"'")
+			return
+		}
+
+		query.Limit = n
+	}
+
+	if minBytes := params.Get("minBytes"); minBytes != "" {
+		n, err := strconv.ParseUint(minBytes, 10, 64)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "invalid minBytes '"+minBytes+"'")
+			return
+		}
+
+		query.MinBytes = n
+	}
+
+	if network := params.Get("network"); network != "" {
+		_, ipNet, err := net.ParseCIDR(network)
+		if err != nil {
+			writeError(rw, http.StatusBadRequest, "invalid network '"+network+"'")
+			return
+		}
+
+		query.Network = ipNet
+	}
+
+	result, err := s.pipeline.TopTalkers().Top(query, time.Now())
+	if err != nil {
+		writeError(rw, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(rw, http.StatusOK, result)
+}
+
+/******************************************************************************
+ *
+ * JSON responses
+ *
+ ******************************************************************************/
+func writeJSON(rw http.ResponseWriter, status int, body interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+
+	if err := json.NewEncoder(rw).Encode(body); err != nil {
+		log.Errorf("HTTPserver: failed to write response: %v", err)
+	}
+}
+
+func writeError(rw http.ResponseWriter, status int, message string) {
+	writeJSON(rw, status, map[string]string{"error": message})
+}
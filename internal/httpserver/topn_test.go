@@ -0,0 +1,62 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/pipeline"
+)
+
+func TestTopNHandler(t *testing.T) {
+	flowPipeline, err := pipeline.New(pipeline.Config{
+		EventTypes: []string{"sflow"},
+		TopN:       pipeline.TopNConfig{Enabled: true},
+	}, make(chan flowrecord.Event, 10), nil)
+	assert.NoError(t, err)
+
+	for _, src := range []string{"10.0.0.1", "10.0.0.2", "192.0.2.7"} {
+		rec := flowrecord.New("sflow", flowrecord.FlowTypeSflow, "192.0.2.1", time.Now())
+		rec.SourceAddress = net.ParseIP(src)
+		rec.Bytes = 100
+
+		flowPipeline.TopTalkers().Process(rec, func(flowrecord.Event) {})
+	}
+
+	s := New("test", "127.0.0.1", 0, flowPipeline, nil)
+
+	// Index of dimensions and windows
+	rw := httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest("GET", "/topn", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Contains(t, rw.Body.String(), `"windows":["1m","5m","15m"]`)
+
+	rw = httptest.NewRecorder()
+	s.ServeHTTP(rw, httptest.NewRequest("GET", "/topn/source?window=5m&network=10.0.0.0/8&limit=1", nil))
+	assert.Equal(t, http.StatusOK, rw.Code)
+	assert.Equal(t, "application/json", rw.Header().Get("Content-Type"))
+
+	var result pipeline.TopNResult
+	assert.NoError(t, json.Unmarshal(rw.Body.Bytes(), &result))
+	assert.Equal(t, "source", result.Dimension)
+	assert.Equal(t, "5m", result.Window)
+	assert.Equal(t, 1, len(result.Entries))
+	assert.Equal(t, "10.0.0.1", result.Entries[0].Key)
+
+	for _, url := range []string{"/topn/bogus", "/topn/source?window=1h", "/topn/source?limit=x", "/topn/source?network=x", "/topn/source?minBytes=-1"} {
+		rw = httptest.NewRecorder()
+		s.ServeHTTP(rw, httptest.NewRequest("GET", url, nil))
+		assert.Equal(t, http.StatusBadRequest, rw.Code, url)
+	}
+
+	// Without the stage there are no routes
+	rw = httptest.NewRecorder()
+	New("test", "127.0.0.1", 0, nil, nil).ServeHTTP(rw, httptest.NewRequest("GET", "/topn", nil))
+	assert.Equal(t, http.StatusNotFound, rw.Code)
+}
@@ -0,0 +1,225 @@
+package netinfo
+
+import (
+	"encoding/json"
+	"net"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/yl2chen/cidranger"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// Cloud providers we can read published ranges for
+const (
+	CloudProviderAWS   = "aws"
+	CloudProviderGCP   = "gcp"
+	CloudProviderAzure = "azure"
+)
+
+// CloudRange describes where a network is hosted
+type CloudRange struct {
+	Provider string
+	Region   string
+	Service  string
+}
+
+// specificity ranks how much a range tells us, so that overlapping entries
+// for the same network keep the most useful one
+func (c CloudRange) specificity() int {
+	score := 0
+
+	if c.Region != "" {
+		score++
+	}
+
+	// AWS lists every range under AMAZON and Azure under AzureCloud, as well
+	// as under the actual service
+	if c.Service != "" && c.Service != "AMAZON" && c.Service != "AzureCloud" {
+		score += 2
+	}
+
+	return score
+}
+
+// cloudNetwork is a RangerEntry for a published range
+type cloudNetwork struct {
+	ipNet net.IPNet
+	cloud CloudRange
+}
+
+// Minimum required for RangerEntry
+func (c *cloudNetwork) Network() net.IPNet {
+	return c.ipNet
+}
+
+/******************************************************************************
+ *
+ * Published range files.  The provider is detected from the content:
+ *
+ *   AWS ip-ranges.json:     {"prefixes": [{"ip_prefix", "region", "service"}],
+ *                            "ipv6_prefixes": [{"ipv6_prefix", "region", "service"}]}
+ *   GCP cloud.json:         {"prefixes": [{"ipv4Prefix" | "ipv6Prefix", "scope", "service"}]}
+ *   Azure ServiceTags.json: {"values": [{"name", "properties": {"region", "systemService", "addressPrefixes"}}]}
+ ******************************************************************************/
+type cloudRangeFile struct {
+	Prefixes     []cloudPrefix     `json:"prefixes"`
+	IPv6Prefixes []cloudPrefix     `json:"ipv6_prefixes"`
+	Values       []azureServiceTag `json:"values"`
+}
+
+type cloudPrefix struct {
+	AwsIPv4Prefix string `json:"ip_prefix"`
+	AwsIPv6Prefix string `json:"ipv6_prefix"`
+	GcpIPv4Prefix string `json:"ipv4Prefix"`
+	GcpIPv6Prefix string `json:"ipv6Prefix"`
+	Region        string `json:"region"`
+	Scope         string `json:"scope"`
+	Service       string `json:"service"`
+}
+
+type azureServiceTag struct {
+	Name       string `json:"name"`
+	Properties struct {
+		Region          string   `json:"region"`
+		SystemService   string   `json:"systemService"`
+		AddressPrefixes []string `json:"addressPrefixes"`
+	} `json:"properties"`
+}
+
+/******************************************************************************
+ *
+ * Read the cloud provider ranges into the config.  A file that can not be
+ * read is logged and skipped, so it does not take the others with it.
+ *
+ ******************************************************************************/
+func (n *NetInfo) loadCloudRanges(filenames []string) (count uint32, err error) {
+	n.clouds = cidranger.NewPCTrieRanger()
+
+	networks := make(map[string]*cloudNetwork)
+
+	for _, filename := range filenames {
+		if err = loadCloudRangeFile(filename, networks); err != nil {
+			log.Errorf("failed to load cloud ranges from '%s': %v", filename, err)
+		}
+	}
+
+	for _, entry := range networks {
+		if err = n.clouds.Insert(entry); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	return count, nil
+}
+
+func loadCloudRangeFile(filename string, networks map[string]*cloudNetwork) error {
+	fileh, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fileh.Close()
+
+	var file cloudRangeFile
+
+	if err = json.NewDecoder(fileh).Decode(&file); err != nil {
+		return err
+	}
+
+	add := func(prefix string, cloud CloudRange) {
+		if prefix == "" {
+			return
+		}
+
+		_, network, err := net.ParseCIDR(prefix)
+		if err != nil {
+			log.Errorf("failed to parse cloud network with error: %v", err.Error())
+			return
+		}
+
+		if existing, ok := networks[network.String()]; ok && existing.cloud.specificity() >= cloud.specificity() {
+			return
+		}
+
+		networks[network.String()] = &cloudNetwork{ipNet: *network, cloud: cloud}
+	}
+
+	for _, prefix := range append(file.Prefixes, file.IPv6Prefixes...) {
+		switch {
+		case prefix.AwsIPv4Prefix != "" || prefix.AwsIPv6Prefix != "":
+			cloud := CloudRange{Provider: CloudProviderAWS, Region: prefix.Region, Service: prefix.Service}
+			add(prefix.AwsIPv4Prefix, cloud)
+			add(prefix.AwsIPv6Prefix, cloud)
+		default:
+			cloud := CloudRange{Provider: CloudProviderGCP, Region: prefix.Scope, Service: prefix.Service}
+			add(prefix.GcpIPv4Prefix, cloud)
+			add(prefix.GcpIPv6Prefix, cloud)
+		}
+	}
+
+	for _, tag := range file.Values {
+		cloud := CloudRange{
+			Provider: CloudProviderAzure,
+			Region:   tag.Properties.Region,
+			Service:  tag.Properties.SystemService,
+		}
+
+		// Most tags have no system service, their name is the service with
+		// the region after a dot
+		if cloud.Service == "" {
+			cloud.Service = strings.SplitN(tag.Name, ".", 2)[0]
+		}
+
+		for _, prefix := range tag.Properties.AddressPrefixes {
+			add(prefix, cloud)
+		}
+	}
+
+	return nil
+}
+
+// CloudRange returns where the address is hosted, from the most specific
+// published range containing it
+func (n *NetInfo) CloudRange(ip net.IP) (CloudRange, bool) {
+	if n.clouds == nil || ip == nil {
+		return CloudRange{}, false
+	}
+
+	entries, err := n.clouds.ContainingNetworks(ip)
+	if err != nil || len(entries) == 0 {
+		return CloudRange{}, false
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		a, b := entries[i].Network(), entries[j].Network()
+		aLen, _ := a.Mask.Size()
+		bLen, _ := b.Mask.Size()
+
+		return aLen > bLen
+	})
+
+	return entries[0].(*cloudNetwork).cloud, true
+}
+
+func (n *NetInfo) enrichCloud(rec *flowrecord.FlowRecord) {
+	cloud, ok := n.CloudRange(rec.DestinationAddress)
+	if !ok {
+		return
+	}
+
+	rec.Enrich("destinationCloudProvider", cloud.Provider)
+
+	if cloud.Region != "" {
+		rec.Enrich("destinationCloudRegion", cloud.Region)
+	}
+
+	if cloud.Service != "" {
+		rec.Enrich("destinationCloudService", cloud.Service)
+	}
+}
@@ -0,0 +1,90 @@
+package netinfo
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestCloudRanges(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cloud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := [][2]string{
+		{"ip-ranges.json", `{"syncToken": "1", "prefixes": [
+			{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "AMAZON"},
+			{"ip_prefix": "3.5.140.0/22", "region": "ap-northeast-2", "service": "S3"},
+			{"ip_prefix": "52.94.0.0/16", "region": "us-east-1", "service": "AMAZON"}
+		], "ipv6_prefixes": [
+			{"ipv6_prefix": "2600:1f14::/35", "region": "us-west-2", "service": "EC2"}
+		]}`},
+		{"cloud.json", `{"prefixes": [
+			{"ipv4Prefix": "34.80.0.0/15", "service": "Google Cloud", "scope": "asia-east1"},
+			{"ipv6Prefix": "2600:1900::/35", "service": "Google Cloud", "scope": "us-central1"}
+		]}`},
+		{"ServiceTags_Public.json", `{"values": [
+			{"name": "AzureCloud", "properties": {"region": "", "systemService": "", "addressPrefixes": ["13.64.0.0/11"]}},
+			{"name": "AzureCloud.westus", "properties": {"region": "westus", "systemService": "", "addressPrefixes": ["13.64.0.0/16"]}},
+			{"name": "Storage.WestUS", "properties": {"region": "westus", "systemService": "AzureStorage", "addressPrefixes": ["13.64.0.0/16"]}},
+			{"name": "PowerPlatformInfra.WestUS", "properties": {"region": "westus", "systemService": "", "addressPrefixes": ["20.0.0.0/16"]}}
+		]}`},
+		{"broken.json", `{"prefixes": [`},
+	}
+
+	var filenames []string
+
+	for _, file := range files {
+		filename := filepath.Join(dir, file[0])
+		assert.NoError(t, ioutil.WriteFile(filename, []byte(file[1]), 0644))
+
+		filenames = append(filenames, filename)
+	}
+
+	// Files that can not be read are skipped
+	filenames = append(filenames, filepath.Join(dir, "missing.json"))
+
+	n := &NetInfo{}
+	count, err := n.loadCloudRanges(filenames)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(8), count)
+
+	tests := []struct {
+		ip       string
+		expected CloudRange
+	}{
+		{"3.5.141.1", CloudRange{Provider: CloudProviderAWS, Region: "ap-northeast-2", Service: "S3"}},
+		{"52.94.1.1", CloudRange{Provider: CloudProviderAWS, Region: "us-east-1", Service: "AMAZON"}},
+		{"2600:1f14::1", CloudRange{Provider: CloudProviderAWS, Region: "us-west-2", Service: "EC2"}},
+		{"34.81.0.1", CloudRange{Provider: CloudProviderGCP, Region: "asia-east1", Service: "Google Cloud"}},
+		{"2600:1900::1", CloudRange{Provider: CloudProviderGCP, Region: "us-central1", Service: "Google Cloud"}},
+		{"13.64.1.1", CloudRange{Provider: CloudProviderAzure, Region: "westus", Service: "AzureStorage"}},
+		{"13.70.1.1", CloudRange{Provider: CloudProviderAzure, Service: "AzureCloud"}},
+		{"20.0.1.1", CloudRange{Provider: CloudProviderAzure, Region: "westus", Service: "PowerPlatformInfra"}},
+	}
+
+	for _, test := range tests {
+		cloud, ok := n.CloudRange(net.ParseIP(test.ip))
+		assert.True(t, ok, test.ip)
+		assert.Equal(t, test.expected, cloud, test.ip)
+	}
+
+	_, ok := n.CloudRange(net.ParseIP("10.0.0.1"))
+	assert.False(t, ok)
+
+	rec := &flowrecord.FlowRecord{DestinationAddress: net.ParseIP("3.5.141.1")}
+	n.Enrich(rec)
+	assert.Equal(t, map[string]interface{}{
+		"destinationCloudProvider": CloudProviderAWS,
+		"destinationCloudRegion":   "ap-northeast-2",
+		"destinationCloudService":  "S3",
+	}, rec.Enrichment)
+}
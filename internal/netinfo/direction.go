@@ -0,0 +1,134 @@
+package netinfo
+
+import (
+	"net"
+
+	"github.com/yl2chen/cidranger"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// Traffic directions relative to the local networks
+const (
+	DirectionInbound  = "inbound"
+	DirectionOutbound = "outbound"
+	DirectionInternal = "internal"
+	DirectionExternal = "external"
+)
+
+/******************************************************************************
+ *
+ * Build the set of local networks and ASNs
+ *
+ ******************************************************************************/
+func (n *NetInfo) loadLocal(networks []string, asns []uint32) (count uint32, err error) {
+	n.localNetworks = cidranger.NewPCTrieRanger()
+	n.localAsns = make(map[uint32]bool)
+
+	for _, cidr := range networks {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Errorf("failed to parse local network with error: %v", err.Error())
+			continue
+		}
+
+		if err = n.localNetworks.Insert(cidranger.NewBasicRangerEntry(*network)); err != nil {
+			return count, err
+		}
+
+		count++
+	}
+
+	for _, asn := range asns {
+		n.localAsns[asn] = true
+		count++
+	}
+
+	return count, nil
+}
+
+// Asn returns the ASN of the most specific network containing the address
+func (n *NetInfo) Asn(ip net.IP) (uint32, bool) {
+	if n.networks == nil || ip == nil {
+		return 0, false
+	}
+
+	entries, err := n.networks.ContainingNetworks(ip)
+	if err != nil || len(entries) == 0 {
+		return 0, false
+	}
+
+	var (
+		best    Network
+		bestLen = -1
+	)
+
+	for _, entry := range entries {
+		network := entry.(Network)
+		ipNet := network.Network()
+
+		if ones, _ := ipNet.Mask.Size(); ones > bestLen {
+			best, bestLen = network, ones
+		}
+	}
+
+	return best.Asn(), true
+}
+
+// IsLocal returns true if the address, or the ASN it belongs to, is one of ours.
+// A non-zero asn reported by the exporter is used in preference to a lookup.
+func (n *NetInfo) IsLocal(ip net.IP, asn uint32) bool {
+	if ip != nil && n.localNetworks != nil {
+		if ok, err := n.localNetworks.Contains(ip); err == nil && ok {
+			return true
+		}
+	}
+
+	if len(n.localAsns) == 0 {
+		return false
+	}
+
+	if asn == 0 {
+		asn, _ = n.Asn(ip)
+	}
+
+	return asn != 0 && n.localAsns[asn]
+}
+
+// Direction classifies traffic between the two endpoints
+func (n *NetInfo) Direction(rec *flowrecord.FlowRecord) (direction string, sourceLocal bool, destinationLocal bool) {
+	sourceLocal = n.IsLocal(rec.SourceAddress, rec.SourceAS)
+	destinationLocal = n.IsLocal(rec.DestinationAddress, rec.DestinationAS)
+
+	switch {
+	case sourceLocal && destinationLocal:
+		direction = DirectionInternal
+	case sourceLocal:
+		direction = DirectionOutbound
+	case destinationLocal:
+		direction = DirectionInbound
+	default:
+		direction = DirectionExternal
+	}
+
+	return direction, sourceLocal, destinationLocal
+}
+
+func (n *NetInfo) enrichDirection(rec *flowrecord.FlowRecord) {
+	if n.localNetworks == nil {
+		return
+	}
+
+	if rec.SourceAddress == nil && rec.DestinationAddress == nil {
+		return
+	}
+
+	direction, sourceLocal, destinationLocal := n.Direction(rec)
+
+	rec.Enrich("direction", direction)
+	rec.Enrich("sourceLocal", sourceLocal)
+	rec.Enrich("destinationLocal", destinationLocal)
+	rec.Enrich("boundary", sourceLocal != destinationLocal)
+}
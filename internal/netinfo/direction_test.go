@@ -0,0 +1,56 @@
+package netinfo
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/yl2chen/cidranger"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestDirection(t *testing.T) {
+	n := &NetInfo{networks: cidranger.NewPCTrieRanger()}
+
+	_, network, _ := net.ParseCIDR("198.51.100.0/24")
+	assert.NoError(t, n.networks.Insert(NewNetwork(*network, 64500)))
+
+	count, err := n.loadLocal([]string{"10.0.0.0/8", "2001:db8::/32", "bogus"}, []uint32{64500})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), count)
+
+	local := net.ParseIP("10.1.1.1")
+	localAsn := net.ParseIP("198.51.100.7")
+	remote := net.ParseIP("203.0.113.9")
+
+	tests := []struct {
+		flow     *flowrecord.FlowRecord
+		expected string
+	}{
+		{&flowrecord.FlowRecord{SourceAddress: local, DestinationAddress: localAsn}, DirectionInternal},
+		{&flowrecord.FlowRecord{SourceAddress: local, DestinationAddress: remote}, DirectionOutbound},
+		{&flowrecord.FlowRecord{SourceAddress: remote, DestinationAddress: net.ParseIP("2001:db8::1")}, DirectionInbound},
+		{&flowrecord.FlowRecord{SourceAddress: remote, DestinationAddress: remote}, DirectionExternal},
+		{&flowrecord.FlowRecord{SourceAddress: remote, DestinationAddress: remote, DestinationAS: 64500}, DirectionInbound},
+	}
+
+	for _, test := range tests {
+		direction, _, _ := n.Direction(test.flow)
+		assert.Equal(t, test.expected, direction)
+	}
+
+	rec := &flowrecord.FlowRecord{SourceAddress: remote, DestinationAddress: local}
+	n.Enrich(rec)
+	assert.Equal(t, map[string]interface{}{
+		"direction":        DirectionInbound,
+		"sourceLocal":      false,
+		"destinationLocal": true,
+		"boundary":         true,
+	}, rec.Enrichment)
+
+	// Nothing is added without any local networks configured
+	rec = &flowrecord.FlowRecord{SourceAddress: remote, DestinationAddress: local}
+	(&NetInfo{}).Enrich(rec)
+	assert.Empty(t, rec.Enrichment)
+}
@@ -0,0 +1,74 @@
+package netinfo
+
+import (
+	"net"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+/******************************************************************************
+ *
+ * Add everything we know about the flow to the record
+ *
+ ******************************************************************************/
+func (n *NetInfo) Enrich(rec *flowrecord.FlowRecord) {
+	if n == nil {
+		return
+	}
+
+	n.snmp.Observe(rec.Agent)
+
+	if name, ok := n.snmp.SysName(rec.Agent); ok {
+		rec.Enrich("agentName", name)
+	}
+
+	n.enrichInterface("input", rec.Agent, rec.InputInterface, rec)
+	n.enrichInterface("output", rec.Agent, rec.OutputInterface, rec)
+
+	n.enrichTags("source", rec.SourceAddress, rec)
+	n.enrichTags("destination", rec.DestinationAddress, rec)
+
+	n.enrichHostnames(rec)
+
+	n.enrichKubernetes("source", rec.SourceAddress, rec)
+	n.enrichKubernetes("destination", rec.DestinationAddress, rec)
+
+	n.enrichDirection(rec)
+	n.enrichApplication(rec)
+	n.enrichThreat(rec)
+	n.enrichCloud(rec)
+	n.enrichVendors(rec)
+}
+
+func (n *NetInfo) enrichInterface(prefix string, agent string, index uint32, rec *flowrecord.FlowRecord) {
+	if index == 0 {
+		return
+	}
+
+	iface, ok := n.Interface(agent, index)
+	if !ok {
+		return
+	}
+
+	if iface.Name != "" {
+		rec.Enrich(prefix+"InterfaceName", iface.Name)
+	}
+
+	if iface.Description != "" {
+		rec.Enrich(prefix+"InterfaceDescription", iface.Description)
+	}
+
+	if iface.Speed > 0 {
+		rec.Enrich(prefix+"InterfaceSpeed", int64(iface.Speed))
+	}
+
+	if iface.Role != "" {
+		rec.Enrich(prefix+"InterfaceRole", iface.Role)
+	}
+}
+
+func (n *NetInfo) enrichTags(prefix string, ip net.IP, rec *flowrecord.FlowRecord) {
+	for k, v := range n.Tags(ip) {
+		rec.Enrich(prefix+"."+k, v)
+	}
+}
@@ -0,0 +1,37 @@
+package netinfo
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestEnrich(t *testing.T) {
+	n := &NetInfo{
+		interfaces: map[interfaceKey]Interface{
+			{agent: "10.0.0.1", index: 1}: {Name: "Ethernet1", Speed: 10000, Role: InterfaceRoleTransit},
+			{agent: "10.0.0.1", index: 2}: {Name: "Ethernet2", Description: "Core", Role: InterfaceRoleInternal},
+		},
+	}
+
+	rec := &flowrecord.FlowRecord{Agent: "10.0.0.1", InputInterface: 1, OutputInterface: 2}
+	n.Enrich(rec)
+
+	assert.Equal(t, map[string]interface{}{
+		"inputInterfaceName":         "Ethernet1",
+		"inputInterfaceSpeed":        int64(10000),
+		"inputInterfaceRole":         "transit",
+		"outputInterfaceName":        "Ethernet2",
+		"outputInterfaceDescription": "Core",
+		"outputInterfaceRole":        "internal",
+	}, rec.Enrichment)
+
+	// A nil NetInfo leaves records alone
+	var empty *NetInfo
+
+	rec = &flowrecord.FlowRecord{Agent: "10.0.0.1", InputInterface: 1}
+	empty.Enrich(rec)
+	assert.Empty(t, rec.Enrichment)
+}
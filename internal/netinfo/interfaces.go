@@ -0,0 +1,130 @@
+package netinfo
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Interface roles supported in the interfaces file
+const (
+	InterfaceRoleTransit  = "transit"
+	InterfaceRolePeering  = "peering"
+	InterfaceRoleCustomer = "customer"
+	InterfaceRoleInternal = "internal"
+)
+
+// Interface describes a single interface on a flow exporter
+type Interface struct {
+	Name        string
+	Description string
+	Speed       uint64 // Mbps
+	Role        string
+}
+
+type interfaceKey struct {
+	agent string
+	index uint32
+}
+
+func validInterfaceRole(role string) bool {
+	switch role {
+	case "", InterfaceRoleTransit, InterfaceRolePeering, InterfaceRoleCustomer, InterfaceRoleInternal:
+		return true
+	}
+
+	return false
+}
+
+/******************************************************************************
+ *
+ * Read the Interface mapping into the config
+ *
+ * Expected Format:
+ *   agent_ip,if_index,name,description,speed_mbps,role
+ ******************************************************************************/
+func (n *NetInfo) loadInterfaces(filename string) (count uint32, err error) {
+	n.interfaces = make(map[interfaceKey]Interface)
+
+	if filename == "" {
+		return 0, nil
+	}
+
+	fileh, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer fileh.Close()
+
+	reader := csv.NewReader(fileh)
+	reader.FieldsPerRecord = 6
+	reader.TrimLeadingSpace = true
+
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		key, iface, err := parseInterface(line)
+		if err != nil {
+			log.Errorf("failed to parse interface with error: %v", err.Error())
+			continue
+		}
+
+		n.interfaces[key] = iface
+		count++
+	}
+
+	return count, nil
+}
+
+func parseInterface(line []string) (interfaceKey, Interface, error) {
+	agent := net.ParseIP(line[0])
+	if agent == nil {
+		return interfaceKey{}, Interface{}, fmt.Errorf("invalid agent address '%s'", line[0])
+	}
+
+	index, err := strconv.ParseUint(line[1], 10, 32)
+	if err != nil {
+		return interfaceKey{}, Interface{}, err
+	}
+
+	iface := Interface{
+		Name:        line[2],
+		Description: line[3],
+		Role:        strings.ToLower(line[5]),
+	}
+
+	if line[4] != "" {
+		if iface.Speed, err = strconv.ParseUint(line[4], 10, 64); err != nil {
+			return interfaceKey{}, Interface{}, err
+		}
+	}
+
+	if !validInterfaceRole(iface.Role) {
+		return interfaceKey{}, Interface{}, fmt.Errorf("unknown interface role '%s'", line[5])
+	}
+
+	return interfaceKey{agent: agent.String(), index: uint32(index)}, iface, nil
+}
+
+// Interface returns the details of ifIndex index on the given agent, preferring
+// the interfaces file over anything learned through SNMP
+func (n *NetInfo) Interface(agent string, index uint32) (Interface, bool) {
+	if iface, ok := n.interfaces[interfaceKey{agent: agent, index: index}]; ok {
+		return iface, ok
+	}
+
+	return n.snmp.Interface(agent, index)
+}
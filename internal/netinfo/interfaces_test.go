@@ -0,0 +1,54 @@
+package netinfo
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTestFile(t *testing.T, data string) string {
+	fileh, err := ioutil.TempFile("", "netinfo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fileh.Close()
+
+	if _, err := fileh.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+
+	return fileh.Name()
+}
+
+func TestInterfaces(t *testing.T) {
+	filename := writeTestFile(t, `10.0.0.1,1,Ethernet1,Uplink to ISP A,10000,transit
+10.0.0.1,2,Ethernet2,IX peering,100000,Peering
+10.0.0.1,bad,Ethernet3,,,internal
+10.0.0.2,1,Ethernet1,,,unknown
+10.0.0.2,2,Ethernet2,,,
+`)
+	defer os.Remove(filename)
+
+	n := &NetInfo{}
+	count, err := n.loadInterfaces(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(3), count)
+
+	iface, ok := n.Interface("10.0.0.1", 1)
+	assert.True(t, ok)
+	assert.Equal(t, Interface{Name: "Ethernet1", Description: "Uplink to ISP A", Speed: 10000, Role: InterfaceRoleTransit}, iface)
+
+	iface, ok = n.Interface("10.0.0.1", 2)
+	assert.True(t, ok)
+	assert.Equal(t, InterfaceRolePeering, iface.Role)
+
+	_, ok = n.Interface("10.0.0.2", 1)
+	assert.False(t, ok)
+
+	iface, ok = n.Interface("10.0.0.2", 2)
+	assert.True(t, ok)
+	assert.Equal(t, "", iface.Role)
+}
@@ -0,0 +1,665 @@
+package netinfo
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+const (
+	kubernetesBackoffMin     = time.Second
+	kubernetesBackoffMax     = time.Minute
+	kubernetesWatchTimeout   = 5 * time.Minute
+	kubernetesRebuildPeriod  = time.Second
+	kubernetesRequestTimeout = 30 * time.Second
+)
+
+// Kinds of Kubernetes objects an address can belong to
+const (
+	KubernetesKindPod     = "pod"
+	KubernetesKindService = "service"
+	KubernetesKindNode    = "node"
+)
+
+var errKubernetesExpired = errors.New("resource version expired")
+
+// KubernetesConfig controls enriching flows from the Kubernetes API
+type KubernetesConfig struct {
+	Enabled   bool   `envconfig:"KUBERNETES_ENABLED"`
+	APIServer string `envconfig:"KUBERNETES_API_SERVER"`
+	TokenFile string `envconfig:"KUBERNETES_TOKEN_FILE"`
+	CAFile    string `envconfig:"KUBERNETES_CA_FILE"`
+	Insecure  bool   `envconfig:"KUBERNETES_INSECURE"`
+}
+
+// KubernetesEndpoint describes what an address belongs to in the cluster
+type KubernetesEndpoint struct {
+	Kind         string
+	Namespace    string
+	Pod          string
+	Workload     string
+	WorkloadKind string
+	Service      string
+	Node         string
+}
+
+/******************************************************************************
+ *
+ * The parts of the Kubernetes API objects we care about
+ *
+ ******************************************************************************/
+type k8sObjectMeta struct {
+	Name            string            `json:"name"`
+	Namespace       string            `json:"namespace"`
+	ResourceVersion string            `json:"resourceVersion"`
+	Labels          map[string]string `json:"labels"`
+	OwnerReferences []struct {
+		Kind       string `json:"kind"`
+		Name       string `json:"name"`
+		Controller bool   `json:"controller"`
+	} `json:"ownerReferences"`
+}
+
+func (m k8sObjectMeta) key() string {
+	return m.Namespace + "/" + m.Name
+}
+
+type k8sPod struct {
+	Metadata k8sObjectMeta `json:"metadata"`
+	Spec     struct {
+		NodeName    string `json:"nodeName"`
+		HostNetwork bool   `json:"hostNetwork"`
+	} `json:"spec"`
+	Status struct {
+		PodIP  string `json:"podIP"`
+		PodIPs []struct {
+			IP string `json:"ip"`
+		} `json:"podIPs"`
+	} `json:"status"`
+}
+
+type k8sService struct {
+	Metadata k8sObjectMeta `json:"metadata"`
+	Spec     struct {
+		ClusterIP   string            `json:"clusterIP"`
+		ClusterIPs  []string          `json:"clusterIPs"`
+		ExternalIPs []string          `json:"externalIPs"`
+		Selector    map[string]string `json:"selector"`
+	} `json:"spec"`
+	Status struct {
+		LoadBalancer struct {
+			Ingress []struct {
+				IP string `json:"ip"`
+			} `json:"ingress"`
+		} `json:"loadBalancer"`
+	} `json:"status"`
+}
+
+type k8sNode struct {
+	Metadata k8sObjectMeta `json:"metadata"`
+	Status   struct {
+		Addresses []struct {
+			Type    string `json:"type"`
+			Address string `json:"address"`
+		} `json:"addresses"`
+	} `json:"status"`
+}
+
+type k8sList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []json.RawMessage `json:"items"`
+}
+
+type k8sWatchEvent struct {
+	Type   string          `json:"type"`
+	Object json.RawMessage `json:"object"`
+}
+
+type k8sStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+/******************************************************************************
+ *
+ * KubernetesWatcher keeps a copy of the pods, services and nodes in the
+ * cluster, and an index of their addresses
+ *
+ ******************************************************************************/
+type KubernetesWatcher struct {
+	config KubernetesConfig
+	client *http.Client
+
+	lock     sync.RWMutex
+	pods     map[string]k8sPod
+	services map[string]k8sService
+	nodes    map[string]k8sNode
+	index    map[string]KubernetesEndpoint
+	dirty    bool
+}
+
+func NewKubernetesWatcher(config KubernetesConfig) (*KubernetesWatcher, error) {
+	if config.APIServer == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("no API server configured and not running in a cluster")
+		}
+
+		config.APIServer = "https://" + net.JoinHostPort(host, port)
+	}
+
+	config.APIServer = strings.TrimSuffix(config.APIServer, "/")
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: config.Insecure} //nolint:gosec
+
+	if config.CAFile != "" && !config.Insecure {
+		if ca, err := ioutil.ReadFile(config.CAFile); err == nil {
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(ca)
+			tlsConfig.RootCAs = pool
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	return &KubernetesWatcher{
+		config: config,
+		client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		pods:     make(map[string]k8sPod),
+		services: make(map[string]k8sService),
+		nodes:    make(map[string]k8sNode),
+		index:    make(map[string]KubernetesEndpoint),
+	}, nil
+}
+
+// Endpoint returns what the address belongs to in the cluster
+func (k *KubernetesWatcher) Endpoint(ip net.IP) (KubernetesEndpoint, bool) {
+	if k == nil || ip == nil {
+		return KubernetesEndpoint{}, false
+	}
+
+	k.lock.RLock()
+	defer k.lock.RUnlock()
+
+	endpoint, ok := k.index[ip.String()]
+
+	return endpoint, ok
+}
+
+/******************************************************************************
+ *
+ * Start watching the cluster
+ *
+ ******************************************************************************/
+func (k *KubernetesWatcher) Start(controlChan chan ControlMessage) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+
+	for _, resource := range []string{"pods", "services", "nodes"} {
+		wg.Add(1)
+
+		go func(resource string) {
+			defer wg.Done()
+			k.watch(ctx, resource)
+		}(resource)
+	}
+
+	rebuild := time.NewTicker(kubernetesRebuildPeriod)
+	defer rebuild.Stop()
+
+	log.Infof("KubernetesWatcher: Watching '%s'", k.config.APIServer)
+
+	for {
+		select {
+		case msg := <-controlChan:
+			switch msg {
+			case ControlMessageStart:
+				// We're ready!
+				log.Debug("KubernetesWatcher: Control Message: Start")
+				continue
+			case ControlMessageQuit:
+				log.Debug("KubernetesWatcher: Control Message: Quit")
+				cancel()
+				wg.Wait()
+
+				controlChan <- ControlMessageDone // Signal exit
+
+				return nil
+			}
+		case <-rebuild.C:
+			k.rebuildIndex()
+		}
+	}
+}
+
+// watch lists then watches a resource until the context is cancelled. While
+// the API is unreachable the last known state is kept.
+func (k *KubernetesWatcher) watch(ctx context.Context, resource string) {
+	backoff := kubernetesBackoffMin
+
+	for ctx.Err() == nil {
+		version, err := k.list(ctx, resource)
+
+		for err == nil && ctx.Err() == nil {
+			var events int
+
+			version, events, err = k.watchFrom(ctx, resource, version)
+
+			switch {
+			case events > 0:
+				backoff = kubernetesBackoffMin
+			case err == nil:
+				// A watch that ends at once is not retried any faster than one that fails
+				log.Debugf("KubernetesWatcher: Watch of %s ended without events, retrying in %s", resource, backoff)
+
+				if !waitBackoff(ctx, &backoff) {
+					return
+				}
+			}
+		}
+
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err != errKubernetesExpired {
+			log.Warnf("KubernetesWatcher: Failed to watch %s, retrying in %s: %v", resource, backoff, err)
+
+			if !waitBackoff(ctx, &backoff) {
+				return
+			}
+		}
+	}
+}
+
+// waitBackoff sleeps for the backoff then doubles it, returning false if the
+// context is cancelled first
+func waitBackoff(ctx context.Context, backoff *time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(*backoff):
+	}
+
+	if *backoff *= 2; *backoff > kubernetesBackoffMax {
+		*backoff = kubernetesBackoffMax
+	}
+
+	return true
+}
+
+func (k *KubernetesWatcher) request(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, k.config.APIServer+path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	if k.config.TokenFile != "" {
+		// Read every time, service account tokens are rotated
+		if token, err := ioutil.ReadFile(k.config.TokenFile); err == nil {
+			req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+		}
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusGone {
+			return nil, errKubernetesExpired
+		}
+
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return resp, nil
+}
+
+// list replaces everything we know about the resource
+func (k *KubernetesWatcher) list(ctx context.Context, resource string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, kubernetesRequestTimeout)
+	defer cancel()
+
+	resp, err := k.request(ctx, "/api/v1/"+resource)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var list k8sList
+
+	if err = json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return "", err
+	}
+
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	switch resource {
+	case "pods":
+		k.pods = make(map[string]k8sPod)
+	case "services":
+		k.services = make(map[string]k8sService)
+	case "nodes":
+		k.nodes = make(map[string]k8sNode)
+	}
+
+	for _, item := range list.Items {
+		if err = k.apply(resource, "ADDED", item); err != nil {
+			log.Warnf("KubernetesWatcher: Skipping %s: %v", resource, err)
+		}
+	}
+
+	k.dirty = true
+
+	log.Debugf("KubernetesWatcher: Listed %d %s", len(list.Items), resource)
+
+	return list.Metadata.ResourceVersion, nil
+}
+
+// watchFrom applies changes until the server closes the watch, returning the
+// last version seen so the watch can be resumed, and how many events it had
+func (k *KubernetesWatcher) watchFrom(ctx context.Context, resource string, version string) (string, int, error) {
+	path := fmt.Sprintf("/api/v1/%s?watch=1&allowWatchBookmarks=true&resourceVersion=%s&timeoutSeconds=%d",
+		resource, version, int(kubernetesWatchTimeout.Seconds()))
+
+	resp, err := k.request(ctx, path)
+	if err != nil {
+		return version, 0, err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(resp.Body))
+
+	for events := 0; ; events++ {
+		var event k8sWatchEvent
+
+		if err = decoder.Decode(&event); err != nil {
+			switch {
+			case ctx.Err() != nil:
+				return version, events, ctx.Err()
+			case err == io.EOF:
+				// The server ends every watch eventually
+				return version, events, nil
+			}
+
+			return version, events, err
+		}
+
+		switch event.Type {
+		case "ERROR":
+			var status k8sStatus
+			if err = json.Unmarshal(event.Object, &status); err == nil && status.Code == http.StatusGone {
+				return version, events, errKubernetesExpired
+			}
+
+			return version, events, fmt.Errorf("watch error: %s", status.Message)
+		case "BOOKMARK":
+			var meta struct {
+				Metadata k8sObjectMeta `json:"metadata"`
+			}
+
+			if err = json.Unmarshal(event.Object, &meta); err == nil {
+				version = meta.Metadata.ResourceVersion
+			}
+		default:
+			k.lock.Lock()
+			if err = k.apply(resource, event.Type, event.Object); err != nil {
+				log.Warnf("KubernetesWatcher: Skipping %s event: %v", resource, err)
+			}
+			k.dirty = true
+			k.lock.Unlock()
+
+			var meta struct {
+				Metadata k8sObjectMeta `json:"metadata"`
+			}
+
+			if err = json.Unmarshal(event.Object, &meta); err == nil {
+				version = meta.Metadata.ResourceVersion
+			}
+		}
+	}
+}
+
+// apply a single change, the lock must be held
+func (k *KubernetesWatcher) apply(resource string, eventType string, data json.RawMessage) error {
+	switch resource {
+	case "pods":
+		var pod k8sPod
+		if err := json.Unmarshal(data, &pod); err != nil {
+			return err
+		}
+
+		if eventType == "DELETED" {
+			delete(k.pods, pod.Metadata.key())
+		} else {
+			k.pods[pod.Metadata.key()] = pod
+		}
+	case "services":
+		var service k8sService
+		if err := json.Unmarshal(data, &service); err != nil {
+			return err
+		}
+
+		if eventType == "DELETED" {
+			delete(k.services, service.Metadata.key())
+		} else {
+			k.services[service.Metadata.key()] = service
+		}
+	case "nodes":
+		var node k8sNode
+		if err := json.Unmarshal(data, &node); err != nil {
+			return err
+		}
+
+		if eventType == "DELETED" {
+			delete(k.nodes, node.Metadata.Name)
+		} else {
+			k.nodes[node.Metadata.Name] = node
+		}
+	}
+
+	return nil
+}
+
+/******************************************************************************
+ *
+ * Rebuild the address index after changes
+ *
+ ******************************************************************************/
+func (k *KubernetesWatcher) rebuildIndex() {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	if !k.dirty {
+		return
+	}
+
+	index := make(map[string]KubernetesEndpoint)
+
+	// Nodes first, so host network pods don't hide them
+	for _, node := range k.nodes {
+		for _, address := range node.Status.Addresses {
+			if net.ParseIP(address.Address) != nil {
+				index[address.Address] = KubernetesEndpoint{Kind: KubernetesKindNode, Node: node.Metadata.Name}
+			}
+		}
+	}
+
+	for _, service := range k.services {
+		endpoint := KubernetesEndpoint{
+			Kind:      KubernetesKindService,
+			Namespace: service.Metadata.Namespace,
+			Service:   service.Metadata.Name,
+		}
+
+		for _, address := range serviceAddresses(service) {
+			if address != "" && address != "None" {
+				index[address] = endpoint
+			}
+		}
+	}
+
+	// Services that select pods, by namespace and in name order
+	selectors := make(map[string][]k8sService)
+
+	for _, service := range k.services {
+		if len(service.Spec.Selector) > 0 {
+			namespace := service.Metadata.Namespace
+			selectors[namespace] = append(selectors[namespace], service)
+		}
+	}
+
+	for _, services := range selectors {
+		sort.Slice(services, func(i, j int) bool { return services[i].Metadata.Name < services[j].Metadata.Name })
+	}
+
+	for _, pod := range k.pods {
+		if pod.Spec.HostNetwork {
+			continue
+		}
+
+		endpoint := KubernetesEndpoint{
+			Kind:      KubernetesKindPod,
+			Namespace: pod.Metadata.Namespace,
+			Pod:       pod.Metadata.Name,
+			Node:      pod.Spec.NodeName,
+			Service:   podService(pod, selectors[pod.Metadata.Namespace]),
+		}
+		endpoint.Workload, endpoint.WorkloadKind = podWorkload(pod)
+
+		for _, address := range podAddresses(pod) {
+			index[address] = endpoint
+		}
+	}
+
+	k.index = index
+	k.dirty = false
+}
+
+func serviceAddresses(service k8sService) []string {
+	addresses := append([]string{service.Spec.ClusterIP}, service.Spec.ClusterIPs...)
+	addresses = append(addresses, service.Spec.ExternalIPs...)
+
+	for _, ingress := range service.Status.LoadBalancer.Ingress {
+		addresses = append(addresses, ingress.IP)
+	}
+
+	return addresses
+}
+
+func podAddresses(pod k8sPod) []string {
+	addresses := []string{}
+
+	if pod.Status.PodIP != "" {
+		addresses = append(addresses, pod.Status.PodIP)
+	}
+
+	for _, ip := range pod.Status.PodIPs {
+		if ip.IP != "" && ip.IP != pod.Status.PodIP {
+			addresses = append(addresses, ip.IP)
+		}
+	}
+
+	return addresses
+}
+
+// podService returns the first of the services, in name order, selecting the pod
+func podService(pod k8sPod, services []k8sService) string {
+	for _, service := range services {
+		matches := true
+
+		for key, value := range service.Spec.Selector {
+			if pod.Metadata.Labels[key] != value {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return service.Metadata.Name
+		}
+	}
+
+	return ""
+}
+
+// podWorkload returns the controller of the pod, following ReplicaSets
+// created by Deployments through the pod-template-hash label
+func podWorkload(pod k8sPod) (string, string) {
+	for _, owner := range pod.Metadata.OwnerReferences {
+		if !owner.Controller {
+			continue
+		}
+
+		if hash := pod.Metadata.Labels["pod-template-hash"]; owner.Kind == "ReplicaSet" && hash != "" {
+			if name := strings.TrimSuffix(owner.Name, "-"+hash); name != owner.Name {
+				return name, "Deployment"
+			}
+		}
+
+		return owner.Name, owner.Kind
+	}
+
+	return "", ""
+}
+
+/******************************************************************************
+ *
+ * Kubernetes attributes for the record
+ *
+ ******************************************************************************/
+func (n *NetInfo) enrichKubernetes(prefix string, ip net.IP, rec *flowrecord.FlowRecord) {
+	endpoint, ok := n.kubernetes.Endpoint(ip)
+	if !ok {
+		return
+	}
+
+	rec.Enrich(prefix+"K8sKind", endpoint.Kind)
+
+	enrich := func(name string, value string) {
+		if value != "" {
+			rec.Enrich(prefix+name, value)
+		}
+	}
+
+	enrich("K8sNamespace", endpoint.Namespace)
+	enrich("K8sPod", endpoint.Pod)
+	enrich("K8sWorkload", endpoint.Workload)
+	enrich("K8sWorkloadKind", endpoint.WorkloadKind)
+	enrich("K8sService", endpoint.Service)
+	enrich("K8sNode", endpoint.Node)
+}
@@ -0,0 +1,259 @@
+package netinfo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+const (
+	testK8sPods = `{"metadata": {"resourceVersion": "10"}, "items": [
+		{"metadata": {"name": "web-7d9f8-abcde", "namespace": "shop",
+			"labels": {"app": "web", "pod-template-hash": "7d9f8"},
+			"ownerReferences": [{"kind": "ReplicaSet", "name": "web-7d9f8", "controller": true}]},
+		 "spec": {"nodeName": "node-1"},
+		 "status": {"podIP": "10.1.0.5", "podIPs": [{"ip": "10.1.0.5"}, {"ip": "fd00::5"}]}},
+		{"metadata": {"name": "proxy", "namespace": "kube-system"},
+		 "spec": {"nodeName": "node-1", "hostNetwork": true},
+		 "status": {"podIP": "192.168.1.10"}}
+	]}`
+	testK8sServices = `{"metadata": {"resourceVersion": "11"}, "items": [
+		{"metadata": {"name": "web", "namespace": "shop"},
+		 "spec": {"clusterIP": "10.96.0.10", "selector": {"app": "web"}}}
+	]}`
+	testK8sNodes = `{"metadata": {"resourceVersion": "12"}, "items": [
+		{"metadata": {"name": "node-1"},
+		 "status": {"addresses": [{"type": "InternalIP", "address": "192.168.1.10"}, {"type": "Hostname", "address": "node-1"}]}}
+	]}`
+	testK8sPodEvent = `{"type": "ADDED", "object":
+		{"metadata": {"name": "db-0", "namespace": "shop", "resourceVersion": "13",
+			"ownerReferences": [{"kind": "StatefulSet", "name": "db", "controller": true}]},
+		 "spec": {"nodeName": "node-2"},
+		 "status": {"podIP": "10.1.0.6"}}}`
+)
+
+// fakeKubernetesAPI serves fixed lists, and a single pod event on watches
+func fakeKubernetesAPI(t *testing.T) *httptest.Server {
+	lists := map[string]string{
+		"/api/v1/pods":     testK8sPods,
+		"/api/v1/services": testK8sServices,
+		"/api/v1/nodes":    testK8sNodes,
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer test-token", r.Header.Get("Authorization"))
+
+		list, ok := lists[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if r.URL.Query().Get("watch") == "" {
+			fmt.Fprint(w, list)
+			return
+		}
+
+		if r.URL.Path == "/api/v1/pods" {
+			fmt.Fprintln(w, testK8sPodEvent)
+		}
+
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+}
+
+func TestKubernetesWatcher(t *testing.T) {
+	server := fakeKubernetesAPI(t)
+	defer server.Close()
+
+	k, err := NewKubernetesWatcher(KubernetesConfig{
+		APIServer: server.URL + "/",
+		TokenFile: writeTestFile(t, "test-token\n"),
+	})
+	assert.NoError(t, err)
+
+	controlChan := make(chan ControlMessage)
+
+	go func() {
+		assert.NoError(t, k.Start(controlChan))
+	}()
+
+	assert.Eventually(t, func() bool {
+		_, ok := k.Endpoint(net.ParseIP("10.1.0.6"))
+		return ok
+	}, 5*time.Second, 10*time.Millisecond)
+
+	endpoint, ok := k.Endpoint(net.ParseIP("10.1.0.5"))
+	assert.True(t, ok)
+	assert.Equal(t, KubernetesEndpoint{
+		Kind:         KubernetesKindPod,
+		Namespace:    "shop",
+		Pod:          "web-7d9f8-abcde",
+		Workload:     "web",
+		WorkloadKind: "Deployment",
+		Service:      "web",
+		Node:         "node-1",
+	}, endpoint)
+
+	endpoint, ok = k.Endpoint(net.ParseIP("fd00::5"))
+	assert.True(t, ok)
+	assert.Equal(t, "web-7d9f8-abcde", endpoint.Pod)
+
+	endpoint, ok = k.Endpoint(net.ParseIP("10.1.0.6"))
+	assert.True(t, ok)
+	assert.Equal(t, "db", endpoint.Workload)
+	assert.Equal(t, "StatefulSet", endpoint.WorkloadKind)
+
+	endpoint, ok = k.Endpoint(net.ParseIP("10.96.0.10"))
+	assert.True(t, ok)
+	assert.Equal(t, KubernetesEndpoint{Kind: KubernetesKindService, Namespace: "shop", Service: "web"}, endpoint)
+
+	// Host network pods share the node address
+	endpoint, ok = k.Endpoint(net.ParseIP("192.168.1.10"))
+	assert.True(t, ok)
+	assert.Equal(t, KubernetesEndpoint{Kind: KubernetesKindNode, Node: "node-1"}, endpoint)
+
+	n := &NetInfo{kubernetes: k}
+
+	rec := &flowrecord.FlowRecord{SourceAddress: net.ParseIP("10.1.0.6"), DestinationAddress: net.ParseIP("10.96.0.10")}
+	n.Enrich(rec)
+	assert.Equal(t, map[string]interface{}{
+		"sourceK8sKind":           "pod",
+		"sourceK8sNamespace":      "shop",
+		"sourceK8sPod":            "db-0",
+		"sourceK8sWorkload":       "db",
+		"sourceK8sWorkloadKind":   "StatefulSet",
+		"sourceK8sNode":           "node-2",
+		"destinationK8sKind":      "service",
+		"destinationK8sNamespace": "shop",
+		"destinationK8sService":   "web",
+	}, rec.Enrichment)
+
+	controlChan <- ControlMessageQuit
+	assert.Equal(t, ControlMessageDone, <-controlChan)
+}
+
+func TestKubernetesPodService(t *testing.T) {
+	var pod k8sPod
+	assert.NoError(t, json.Unmarshal([]byte(`{"metadata": {"name": "web-0", "namespace": "shop", "labels": {"app": "web"}},
+		"status": {"podIP": "10.1.0.5"}}`), &pod))
+
+	var services []k8sService
+	assert.NoError(t, json.Unmarshal([]byte(`[
+		{"metadata": {"name": "web-b", "namespace": "shop"}, "spec": {"selector": {"app": "web"}}},
+		{"metadata": {"name": "web-a", "namespace": "shop"}, "spec": {"selector": {"app": "web"}}},
+		{"metadata": {"name": "a-web", "namespace": "other"}, "spec": {"selector": {"app": "web"}}},
+		{"metadata": {"name": "a-all", "namespace": "shop"}, "spec": {}},
+		{"metadata": {"name": "a-db", "namespace": "shop"}, "spec": {"selector": {"app": "db"}}}
+	]`), &services))
+
+	k := &KubernetesWatcher{
+		pods:     map[string]k8sPod{pod.Metadata.key(): pod},
+		services: make(map[string]k8sService),
+	}
+
+	for _, service := range services {
+		k.services[service.Metadata.key()] = service
+	}
+
+	// Of several services selecting the pod, always the first by name
+	for i := 0; i < 10; i++ {
+		k.dirty = true
+		k.rebuildIndex()
+
+		endpoint, ok := k.Endpoint(net.ParseIP("10.1.0.5"))
+		assert.True(t, ok)
+		assert.Equal(t, "web-a", endpoint.Service)
+	}
+}
+
+func TestKubernetesUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	k, err := NewKubernetesWatcher(KubernetesConfig{APIServer: server.URL})
+	assert.NoError(t, err)
+
+	controlChan := make(chan ControlMessage)
+
+	go func() {
+		assert.NoError(t, k.Start(controlChan))
+	}()
+
+	_, ok := k.Endpoint(net.ParseIP("10.1.0.5"))
+	assert.False(t, ok)
+
+	controlChan <- ControlMessageQuit
+	assert.Equal(t, ControlMessageDone, <-controlChan)
+}
+
+func TestKubernetesWatchFrom(t *testing.T) {
+	var body string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	k, err := NewKubernetesWatcher(KubernetesConfig{APIServer: server.URL})
+	assert.NoError(t, err)
+
+	k.pods = make(map[string]k8sPod)
+
+	body = testK8sPodEvent
+	version, events, err := k.watchFrom(context.Background(), "pods", "10")
+	assert.NoError(t, err)
+	assert.Equal(t, "13", version)
+	assert.Equal(t, 1, events)
+
+	// An empty watch ends normally, but without events
+	body = ""
+	version, events, err = k.watchFrom(context.Background(), "pods", "13")
+	assert.NoError(t, err)
+	assert.Equal(t, "13", version)
+	assert.Equal(t, 0, events)
+
+	body = "<html>"
+	_, _, err = k.watchFrom(context.Background(), "pods", "13")
+	assert.Error(t, err)
+
+	body = testK8sPodEvent[:20]
+	_, events, err = k.watchFrom(context.Background(), "pods", "13")
+	assert.Error(t, err)
+	assert.Equal(t, 0, events)
+}
+
+func TestKubernetesWatchBackoff(t *testing.T) {
+	var watches int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") != "" {
+			atomic.AddInt32(&watches, 1)
+			return
+		}
+
+		fmt.Fprint(w, `{"metadata": {"resourceVersion": "1"}, "items": []}`)
+	}))
+	defer server.Close()
+
+	k, err := NewKubernetesWatcher(KubernetesConfig{APIServer: server.URL})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	// Watches that end at once wait for the backoff before the next
+	k.watch(ctx, "pods")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&watches))
+}
@@ -1,24 +1,55 @@
 package netinfo
 
 import (
+	"strings"
+
 	"github.com/yl2chen/cidranger"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// Config holds the data files and settings used to build NetInfo
+type Config struct {
+	NetworksFile   string
+	HostsFile      string
+	InterfacesFile string
+	TagsFile       string
+	ServicesFile   string
+	CloudFiles     []string
+	OuiFiles       []string
+	LocalNetworks  []string
+	LocalAsns      []uint32
+	Snmp           SnmpConfig
+	Threats        ThreatConfig
+	Resolver       ResolverConfig
+	Kubernetes     KubernetesConfig
+}
+
 type NetInfo struct {
-	networks cidranger.Ranger
-	asns     map[uint32]string
-	hosts    map[string]string
+	networks   cidranger.Ranger
+	asns       map[uint32]string
+	hosts      map[string]string
+	interfaces map[interfaceKey]Interface
+	tags       cidranger.Ranger
+	services   []serviceRule
+	clouds     cidranger.Ranger
+	ouis       map[string]string
+	snmp       *SnmpPoller
+	threats    *ThreatList
+	resolver   *Resolver
+	kubernetes *KubernetesWatcher
+
+	localNetworks cidranger.Ranger
+	localAsns     map[uint32]bool
 }
 
-func NewNetInfo(networkFile string, hostFile string) NetInfo {
-	n := NetInfo{}
+func NewNetInfo(config Config) *NetInfo {
+	n := &NetInfo{}
 
-	if networkFile != "" {
-		log.Infof("loading network data from '%s'", networkFile)
+	if config.NetworksFile != "" {
+		log.Infof("loading network data from '%s'", config.NetworksFile)
 
-		count, err := n.loadNetworks(networkFile)
+		count, err := n.loadNetworks(config.NetworksFile)
 		if err != nil {
 			log.Errorf("failed with error: %v", err.Error())
 		}
@@ -26,10 +57,10 @@ func NewNetInfo(networkFile string, hostFile string) NetInfo {
 		log.Debugf("loaded %d network entries", count)
 	}
 
-	if hostFile != "" {
-		log.Infof("loading host data from '%s'", hostFile)
+	if config.HostsFile != "" {
+		log.Infof("loading host data from '%s'", config.HostsFile)
 
-		count, err := n.loadHosts(hostFile)
+		count, err := n.loadHosts(config.HostsFile)
 		if err != nil {
 			log.Errorf("failed with error: %v", err.Error())
 		}
@@ -37,6 +68,106 @@ func NewNetInfo(networkFile string, hostFile string) NetInfo {
 		log.Debugf("loaded %d host entries", count)
 	}
 
+	if config.InterfacesFile != "" {
+		log.Infof("loading interface data from '%s'", config.InterfacesFile)
+
+		count, err := n.loadInterfaces(config.InterfacesFile)
+		if err != nil {
+			log.Errorf("failed with error: %v", err.Error())
+		}
+
+		log.Debugf("loaded %d interface entries", count)
+	}
+
+	if config.TagsFile != "" {
+		log.Infof("loading tag data from '%s'", config.TagsFile)
+
+		count, err := n.loadTags(config.TagsFile)
+		if err != nil {
+			log.Errorf("failed with error: %v", err.Error())
+		}
+
+		log.Debugf("loaded %d tag entries", count)
+	}
+
+	if config.ServicesFile != "" {
+		log.Infof("loading application data from '%s'", config.ServicesFile)
+
+		count, err := n.loadServices(config.ServicesFile)
+		if err != nil {
+			log.Errorf("failed with error: %v", err.Error())
+		}
+
+		log.Debugf("loaded %d application entries", count)
+	}
+
+	if len(config.CloudFiles) > 0 {
+		log.Infof("loading cloud range data from '%s'", strings.Join(config.CloudFiles, "', '"))
+
+		count, err := n.loadCloudRanges(config.CloudFiles)
+		if err != nil {
+			log.Errorf("failed with error: %v", err.Error())
+		}
+
+		log.Debugf("loaded %d cloud range entries", count)
+	}
+
+	if len(config.OuiFiles) > 0 {
+		log.Infof("loading OUI data from '%s'", strings.Join(config.OuiFiles, "', '"))
+
+		count, err := n.loadOuis(config.OuiFiles)
+		if err != nil {
+			log.Errorf("failed with error: %v", err.Error())
+		}
+
+		log.Debugf("loaded %d OUI entries", count)
+	}
+
+	if len(config.LocalNetworks) > 0 || len(config.LocalAsns) > 0 {
+		count, err := n.loadLocal(config.LocalNetworks, config.LocalAsns)
+		if err != nil {
+			log.Errorf("failed with error: %v", err.Error())
+		}
+
+		log.Debugf("loaded %d local networks and ASNs", count)
+	}
+
+	if len(config.Threats.Files) > 0 {
+		log.Infof("loading threat data from '%s'", strings.Join(config.Threats.Files, "', '"))
+
+		n.threats = NewThreatList(config.Threats)
+
+		count, err := n.threats.Load()
+		if err != nil {
+			log.Errorf("failed with error: %v", err.Error())
+		}
+
+		log.Debugf("loaded %d threat entries", count)
+	}
+
+	if config.Resolver.Enabled {
+		log.Infof("resolving hostnames with reverse DNS")
+
+		n.resolver = NewResolver(config.Resolver)
+	}
+
+	if config.Kubernetes.Enabled {
+		log.Infof("watching the Kubernetes API for pods, services and nodes")
+
+		watcher, err := NewKubernetesWatcher(config.Kubernetes)
+		if err != nil {
+			log.Errorf("failed with error: %v", err.Error())
+		}
+
+		n.kubernetes = watcher
+	}
+
+	if config.Snmp.Enabled {
+		log.Infof("polling agents with SNMP v%s", config.Snmp.Version)
+
+		n.snmp = NewSnmpPoller(config.Snmp)
+	}
+
 	return n
 }
 
@@ -48,3 +179,23 @@ func (n *NetInfo) AsnPeerMap() map[uint32]string {
 
 	return n.asns
 }
+
+// SnmpPoller returns the agent poller, or nil if SNMP polling is disabled
+func (n *NetInfo) SnmpPoller() *SnmpPoller {
+	return n.snmp
+}
+
+// ThreatList returns the threat lists, or nil if none are configured
+func (n *NetInfo) ThreatList() *ThreatList {
+	return n.threats
+}
+
+// Resolver returns the reverse DNS resolver, or nil if lookups are disabled
+func (n *NetInfo) Resolver() *Resolver {
+	return n.resolver
+}
+
+// KubernetesWatcher returns the Kubernetes API watcher, or nil if disabled
+func (n *NetInfo) KubernetesWatcher() *KubernetesWatcher {
+	return n.kubernetes
+}
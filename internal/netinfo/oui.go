@@ -0,0 +1,108 @@
+package netinfo
+
+import (
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// IEEE assignment lengths in hex digits, longest first (MA-S, MA-M, MA-L)
+var ouiLengths = []int{9, 7, 6}
+
+/******************************************************************************
+ *
+ * Read the IEEE OUI registries into the config
+ *
+ * Expected Format (oui.csv, mam.csv and oui36.csv from IEEE):
+ *   Registry,Assignment,Organization Name,Organization Address
+ ******************************************************************************/
+func (n *NetInfo) loadOuis(filenames []string) (count uint32, err error) {
+	n.ouis = make(map[string]string)
+
+	for _, filename := range filenames {
+		c, err := n.loadOuiFile(filename)
+		count += c
+
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+func (n *NetInfo) loadOuiFile(filename string) (count uint32, err error) {
+	fileh, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer fileh.Close()
+
+	reader := csv.NewReader(fileh)
+	reader.FieldsPerRecord = -1
+
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		if len(line) < 3 || line[0] == "Registry" {
+			continue
+		}
+
+		assignment := strings.ToUpper(strings.TrimSpace(line[1]))
+		if !validOui(assignment) {
+			continue
+		}
+
+		n.ouis[assignment] = strings.TrimSpace(line[2])
+		count++
+	}
+
+	return count, nil
+}
+
+func validOui(assignment string) bool {
+	for _, length := range ouiLengths {
+		if len(assignment) == length {
+			return strings.Trim(assignment, "0123456789ABCDEF") == ""
+		}
+	}
+
+	return false
+}
+
+// Vendor returns the organization the hardware address was assigned to
+func (n *NetInfo) Vendor(mac net.HardwareAddr) (string, bool) {
+	if len(n.ouis) == 0 || len(mac) < 6 {
+		return "", false
+	}
+
+	digits := strings.ToUpper(hex.EncodeToString(mac))
+
+	for _, length := range ouiLengths {
+		if vendor, ok := n.ouis[digits[:length]]; ok {
+			return vendor, true
+		}
+	}
+
+	return "", false
+}
+
+func (n *NetInfo) enrichVendors(rec *flowrecord.FlowRecord) {
+	for name, mac := range rec.HardwareAddresses {
+		if vendor, ok := n.Vendor(mac); ok {
+			rec.Enrich(name+"Vendor", vendor)
+		}
+	}
+}
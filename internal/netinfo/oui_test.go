@@ -0,0 +1,50 @@
+package netinfo
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestOuis(t *testing.T) {
+	filename := writeTestFile(t, `Registry,Assignment,Organization Name,Organization Address
+MA-L,000C29,"VMware, Inc.",3401 Hillview Avenue PALO ALTO CA US 94304
+MA-L,3C2AF4,"Brother Industries, LTD.",NAGOYA JP
+MA-M,70B3D51,Example Medium Block,Somewhere
+MA-S,70B3D5F2A,Example Small Block,Somewhere
+MA-L,XYZ123,Invalid,Nowhere
+`)
+	defer os.Remove(filename)
+
+	n := &NetInfo{}
+	count, err := n.loadOuis([]string{filename})
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(4), count)
+
+	tests := map[string]string{
+		"00:0c:29:aa:bb:cc": "VMware, Inc.",
+		"3c:2a:f4:00:00:01": "Brother Industries, LTD.",
+		"70:b3:d5:1f:00:00": "Example Medium Block",
+		"70:b3:d5:f2:a1:00": "Example Small Block",
+	}
+
+	for address, expected := range tests {
+		mac, _ := net.ParseMAC(address)
+		vendor, ok := n.Vendor(mac)
+		assert.True(t, ok, address)
+		assert.Equal(t, expected, vendor, address)
+	}
+
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	_, ok := n.Vendor(mac)
+	assert.False(t, ok)
+
+	vmware, _ := net.ParseMAC("00:0c:29:aa:bb:cc")
+	rec := &flowrecord.FlowRecord{HardwareAddresses: map[string]net.HardwareAddr{"linkSourceAddress": vmware, "linkDestinationAddress": mac}}
+	n.Enrich(rec)
+	assert.Equal(t, map[string]interface{}{"linkSourceAddressVendor": "VMware, Inc."}, rec.Enrichment)
+}
@@ -0,0 +1,280 @@
+package netinfo
+
+import (
+	"container/list"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yl2chen/cidranger"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+const resolverQueueSize = 4096
+
+const (
+	DefaultResolverTimeout = 2 * time.Second
+	DefaultResolverWorkers = 8
+)
+
+// ResolverConfig controls reverse DNS lookups of flow addresses
+type ResolverConfig struct {
+	Enabled     bool          `envconfig:"RDNS_ENABLED"`
+	Server      string        `envconfig:"RDNS_SERVER"`
+	Networks    []string      `envconfig:"RDNS_NETWORKS"`
+	CacheSize   int           `envconfig:"RDNS_CACHE_SIZE"`
+	TTL         time.Duration `envconfig:"RDNS_TTL"`
+	NegativeTTL time.Duration `envconfig:"RDNS_NEGATIVE_TTL"`
+	Timeout     time.Duration `envconfig:"RDNS_TIMEOUT"`
+	Workers     int           `envconfig:"RDNS_WORKERS"`
+}
+
+type resolverEntry struct {
+	address  string
+	hostname string // empty for a negative answer
+	expires  time.Time
+}
+
+/******************************************************************************
+ *
+ * Resolver answers PTR lookups from a bounded LRU cache, looking up misses in
+ * the background so callers never wait on DNS
+ *
+ ******************************************************************************/
+type Resolver struct {
+	config   ResolverConfig
+	lock     sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	pending  map[string]bool
+	queue    chan string
+	scope    cidranger.Ranger
+	resolver *net.Resolver
+	now      func() time.Time
+}
+
+func NewResolver(config ResolverConfig) *Resolver {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultResolverTimeout
+	}
+
+	if config.Workers <= 0 {
+		config.Workers = DefaultResolverWorkers
+	}
+
+	r := &Resolver{
+		config:   config,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+		pending:  make(map[string]bool),
+		queue:    make(chan string, resolverQueueSize),
+		resolver: net.DefaultResolver,
+		now:      time.Now,
+	}
+
+	if config.Server != "" {
+		server := config.Server
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+
+	if len(config.Networks) > 0 {
+		r.scope = cidranger.NewPCTrieRanger()
+
+		for _, cidr := range config.Networks {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				log.Errorf("failed to parse reverse DNS network with error: %v", err.Error())
+				continue
+			}
+
+			if err = r.scope.Insert(cidranger.NewBasicRangerEntry(*network)); err != nil {
+				log.Errorf("failed to add reverse DNS network with error: %v", err.Error())
+			}
+		}
+	}
+
+	return r
+}
+
+// inScope returns true if the address should be looked up at all
+func (r *Resolver) inScope(ip net.IP) bool {
+	if r.scope == nil {
+		return true
+	}
+
+	ok, err := r.scope.Contains(ip)
+
+	return err == nil && ok
+}
+
+// Hostname returns the cached name of the address. Misses are queued for
+// lookup and will be answered by a later call.
+func (r *Resolver) Hostname(ip net.IP) (string, bool) {
+	if r == nil || ip == nil || !r.inScope(ip) {
+		return "", false
+	}
+
+	address := ip.String()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if elem, ok := r.entries[address]; ok {
+		entry := elem.Value.(*resolverEntry)
+
+		if r.now().Before(entry.expires) {
+			r.lru.MoveToFront(elem)
+			return entry.hostname, entry.hostname != ""
+		}
+	}
+
+	if !r.pending[address] {
+		select {
+		case r.queue <- address:
+			r.pending[address] = true
+		default:
+			// Queue is full, try again on a later flow
+		}
+	}
+
+	return "", false
+}
+
+// store caches an answer, evicting the least recently used entries
+func (r *Resolver) store(address string, hostname string) {
+	ttl := r.config.TTL
+	if hostname == "" {
+		ttl = r.config.NegativeTTL
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.pending, address)
+
+	entry := &resolverEntry{address: address, hostname: hostname, expires: r.now().Add(ttl)}
+
+	if elem, ok := r.entries[address]; ok {
+		elem.Value = entry
+		r.lru.MoveToFront(elem)
+
+		return
+	}
+
+	r.entries[address] = r.lru.PushFront(entry)
+
+	for r.config.CacheSize > 0 && r.lru.Len() > r.config.CacheSize {
+		oldest := r.lru.Back()
+		r.lru.Remove(oldest)
+		delete(r.entries, oldest.Value.(*resolverEntry).address)
+	}
+}
+
+func (r *Resolver) lookup(address string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.config.Timeout)
+	defer cancel()
+
+	hostname := ""
+
+	names, err := r.resolver.LookupAddr(ctx, address)
+	if err != nil {
+		log.Debugf("Resolver: Lookup of '%s' failed: %v", address, err)
+	} else if len(names) > 0 {
+		hostname = strings.TrimSuffix(names[0], ".")
+	}
+
+	r.store(address, hostname)
+}
+
+/******************************************************************************
+ *
+ * Start the lookup workers
+ *
+ ******************************************************************************/
+func (r *Resolver) Start(controlChan chan ControlMessage) error {
+	var wg sync.WaitGroup
+
+	done := make(chan struct{})
+
+	for i := 0; i < r.config.Workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				case address := <-r.queue:
+					r.lookup(address)
+				}
+			}
+		}()
+	}
+
+	log.Infof("Resolver: Started %d lookup workers", r.config.Workers)
+
+	for {
+		//nolint:gosimple
+		select {
+		case msg := <-controlChan:
+			switch msg {
+			case ControlMessageStart:
+				// We're ready!
+				log.Debug("Resolver: Control Message: Start")
+				continue
+			case ControlMessageQuit:
+				log.Debug("Resolver: Control Message: Quit")
+				close(done)
+				wg.Wait()
+
+				controlChan <- ControlMessageDone // Signal exit
+
+				return nil
+			}
+		}
+	}
+}
+
+/******************************************************************************
+ *
+ * Hostnames from the hosts file, then reverse DNS
+ *
+ ******************************************************************************/
+func (n *NetInfo) Hostname(ip net.IP) (string, bool) {
+	if ip == nil {
+		return "", false
+	}
+
+	if hostname, ok := n.hosts[ip.String()]; ok {
+		return hostname, true
+	}
+
+	return n.resolver.Hostname(ip)
+}
+
+func (n *NetInfo) enrichHostnames(rec *flowrecord.FlowRecord) {
+	if hostname, ok := n.Hostname(rec.SourceAddress); ok {
+		rec.Enrich("sourceHostname", hostname)
+	}
+
+	if hostname, ok := n.Hostname(rec.DestinationAddress); ok {
+		rec.Enrich("destinationHostname", hostname)
+	}
+}
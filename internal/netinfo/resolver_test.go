@@ -0,0 +1,90 @@
+package netinfo
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestNewResolver(t *testing.T) {
+	// Settings that would never look anything up fall back to the defaults
+	r := NewResolver(ResolverConfig{Timeout: -time.Second, Workers: -1})
+	assert.Equal(t, DefaultResolverTimeout, r.config.Timeout)
+	assert.Equal(t, DefaultResolverWorkers, r.config.Workers)
+
+	r = NewResolver(ResolverConfig{Timeout: time.Second, Workers: 2})
+	assert.Equal(t, time.Second, r.config.Timeout)
+	assert.Equal(t, 2, r.config.Workers)
+}
+
+func TestResolverCache(t *testing.T) {
+	now := time.Now()
+
+	r := NewResolver(ResolverConfig{
+		Networks:    []string{"10.0.0.0/8", "192.168.0.0/16"},
+		CacheSize:   2,
+		TTL:         time.Hour,
+		NegativeTTL: time.Minute,
+	})
+	r.now = func() time.Time { return now }
+
+	server := net.ParseIP("10.0.0.1")
+
+	// Misses are queued once, and never block
+	_, ok := r.Hostname(server)
+	assert.False(t, ok)
+	_, ok = r.Hostname(server)
+	assert.False(t, ok)
+	assert.Equal(t, 1, len(r.queue))
+	assert.Equal(t, "10.0.0.1", <-r.queue)
+
+	// Out of scope addresses are never looked up
+	_, ok = r.Hostname(net.ParseIP("203.0.113.1"))
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(r.queue))
+
+	r.store("10.0.0.1", "server.example.com")
+
+	hostname, ok := r.Hostname(server)
+	assert.True(t, ok)
+	assert.Equal(t, "server.example.com", hostname)
+
+	// Negative answers are cached for the shorter TTL
+	r.store("10.0.0.2", "")
+
+	_, ok = r.Hostname(net.ParseIP("10.0.0.2"))
+	assert.False(t, ok)
+	assert.Equal(t, 0, len(r.queue))
+
+	now = now.Add(2 * time.Minute)
+
+	_, ok = r.Hostname(net.ParseIP("10.0.0.2"))
+	assert.False(t, ok)
+	assert.Equal(t, 1, len(r.queue))
+
+	// The least recently used entry is evicted
+	_, ok = r.Hostname(server)
+	assert.True(t, ok)
+
+	r.store("10.0.0.3", "other.example.com")
+	assert.Equal(t, 2, r.lru.Len())
+
+	_, ok = r.entries["10.0.0.2"]
+	assert.False(t, ok)
+
+	hostname, ok = r.Hostname(server)
+	assert.True(t, ok)
+	assert.Equal(t, "server.example.com", hostname)
+}
+
+func TestHostnames(t *testing.T) {
+	n := &NetInfo{hosts: map[string]string{"10.0.0.1": "static.example.com"}}
+
+	rec := &flowrecord.FlowRecord{SourceAddress: net.ParseIP("10.0.0.1"), DestinationAddress: net.ParseIP("10.0.0.2")}
+	n.Enrich(rec)
+	assert.Equal(t, map[string]interface{}{"sourceHostname": "static.example.com"}, rec.Enrichment)
+}
@@ -0,0 +1,374 @@
+package netinfo
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// IP protocol numbers we look at directly
+const (
+	ProtocolICMP   uint8 = 1
+	ProtocolTCP    uint8 = 6
+	ProtocolUDP    uint8 = 17
+	ProtocolICMPv6 uint8 = 58
+	ProtocolSCTP   uint8 = 132
+)
+
+// IANA assigned internet protocol numbers
+var protocolNames = map[uint8]string{
+	0:              "hopopt",
+	ProtocolICMP:   "icmp",
+	2:              "igmp",
+	4:              "ipv4",
+	ProtocolTCP:    "tcp",
+	8:              "egp",
+	9:              "igp",
+	ProtocolUDP:    "udp",
+	41:             "ipv6",
+	43:             "ipv6-route",
+	44:             "ipv6-frag",
+	46:             "rsvp",
+	47:             "gre",
+	50:             "esp",
+	51:             "ah",
+	ProtocolICMPv6: "ipv6-icmp",
+	59:             "ipv6-nonxt",
+	60:             "ipv6-opts",
+	88:             "eigrp",
+	89:             "ospf",
+	94:             "ipip",
+	97:             "etherip",
+	103:            "pim",
+	112:            "vrrp",
+	115:            "l2tp",
+	ProtocolSCTP:   "sctp",
+	136:            "udplite",
+	137:            "mpls-in-ip",
+}
+
+// IANA assigned service names for well-known and commonly used registered ports
+var serviceNames = map[uint16]string{
+	20:    "ftp-data",
+	21:    "ftp",
+	22:    "ssh",
+	23:    "telnet",
+	25:    "smtp",
+	49:    "tacacs",
+	53:    "dns",
+	67:    "dhcp",
+	68:    "dhcp",
+	69:    "tftp",
+	80:    "http",
+	88:    "kerberos",
+	110:   "pop3",
+	111:   "sunrpc",
+	119:   "nntp",
+	123:   "ntp",
+	135:   "msrpc",
+	137:   "netbios-ns",
+	138:   "netbios-dgm",
+	139:   "netbios-ssn",
+	143:   "imap",
+	161:   "snmp",
+	162:   "snmptrap",
+	179:   "bgp",
+	389:   "ldap",
+	443:   "https",
+	445:   "microsoft-ds",
+	464:   "kpasswd",
+	465:   "smtps",
+	500:   "isakmp",
+	514:   "syslog",
+	515:   "printer",
+	520:   "rip",
+	546:   "dhcpv6-client",
+	547:   "dhcpv6-server",
+	554:   "rtsp",
+	587:   "submission",
+	623:   "ipmi",
+	636:   "ldaps",
+	646:   "ldp",
+	853:   "dns-over-tls",
+	873:   "rsync",
+	989:   "ftps-data",
+	990:   "ftps",
+	993:   "imaps",
+	995:   "pop3s",
+	1194:  "openvpn",
+	1433:  "ms-sql",
+	1521:  "oracle",
+	1701:  "l2tp",
+	1723:  "pptp",
+	1812:  "radius",
+	1813:  "radius-acct",
+	1883:  "mqtt",
+	2049:  "nfs",
+	2055:  "netflow",
+	2181:  "zookeeper",
+	2379:  "etcd",
+	3268:  "ldap-gc",
+	3306:  "mysql",
+	3389:  "rdp",
+	3478:  "stun",
+	4500:  "ipsec-nat-t",
+	4739:  "ipfix",
+	4789:  "vxlan",
+	5060:  "sip",
+	5061:  "sips",
+	5222:  "xmpp",
+	5353:  "mdns",
+	5432:  "postgresql",
+	5671:  "amqps",
+	5672:  "amqp",
+	5900:  "vnc",
+	5985:  "winrm",
+	5986:  "winrm",
+	6343:  "sflow",
+	6379:  "redis",
+	6443:  "kubernetes-api",
+	6514:  "syslog-tls",
+	8080:  "http-alt",
+	8443:  "https-alt",
+	8883:  "mqtts",
+	9092:  "kafka",
+	9200:  "elasticsearch",
+	11211: "memcached",
+	27017: "mongodb",
+}
+
+// ProtocolName returns the IANA keyword for an IP protocol number
+func ProtocolName(protocol uint8) string {
+	if name, ok := protocolNames[protocol]; ok {
+		return name
+	}
+
+	return strconv.Itoa(int(protocol))
+}
+
+func hasPorts(protocol uint8) bool {
+	return protocol == ProtocolTCP || protocol == ProtocolUDP || protocol == ProtocolSCTP
+}
+
+/******************************************************************************
+ *
+ * User defined application rules
+ *
+ ******************************************************************************/
+type serviceRule struct {
+	application string
+	anyProtocol bool
+	protocol    uint8
+	firstPort   uint16
+	lastPort    uint16     // 0 for any
+	network     *net.IPNet // nil for any
+}
+
+// matches checks the service side of a flow against the rule, port rules
+// only match protocols that have ports
+func (r *serviceRule) matches(protocol uint8, ports bool, port uint16, ip net.IP) bool {
+	if !r.anyProtocol && r.protocol != protocol {
+		return false
+	}
+
+	if r.lastPort != 0 && (!ports || port < r.firstPort || port > r.lastPort) {
+		return false
+	}
+
+	if r.network != nil && (ip == nil || !r.network.Contains(ip)) {
+		return false
+	}
+
+	return true
+}
+
+/******************************************************************************
+ *
+ * Read the application rules into the config
+ *
+ * Expected Format:
+ *   application,protocol,ports,network
+ ******************************************************************************/
+func (n *NetInfo) loadServices(filename string) (count uint32, err error) {
+	n.services = nil
+
+	if filename == "" {
+		return 0, nil
+	}
+
+	fileh, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer fileh.Close()
+
+	reader := csv.NewReader(fileh)
+	reader.FieldsPerRecord = 4
+	reader.TrimLeadingSpace = true
+
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		rule, err := parseServiceRule(line)
+		if err != nil {
+			log.Errorf("failed to parse application rule with error: %v", err.Error())
+			continue
+		}
+
+		n.services = append(n.services, rule)
+		count++
+	}
+
+	return count, nil
+}
+
+func parseServiceRule(line []string) (serviceRule, error) {
+	rule := serviceRule{application: line[0], anyProtocol: line[1] == ""}
+
+	if rule.application == "" {
+		return rule, fmt.Errorf("missing application name")
+	}
+
+	if !rule.anyProtocol {
+		protocol, err := parseProtocol(line[1])
+		if err != nil {
+			return rule, err
+		}
+
+		rule.protocol = protocol
+	}
+
+	if line[2] != "" {
+		first, last := line[2], line[2]
+		if i := strings.Index(line[2], "-"); i >= 0 {
+			first, last = line[2][:i], line[2][i+1:]
+		}
+
+		firstPort, err := strconv.ParseUint(first, 10, 16)
+		if err != nil {
+			return rule, err
+		}
+
+		lastPort, err := strconv.ParseUint(last, 10, 16)
+		if err != nil {
+			return rule, err
+		}
+
+		if lastPort < firstPort || lastPort == 0 {
+			return rule, fmt.Errorf("invalid port range '%s'", line[2])
+		}
+
+		rule.firstPort, rule.lastPort = uint16(firstPort), uint16(lastPort)
+	}
+
+	if line[3] != "" {
+		_, network, err := net.ParseCIDR(line[3])
+		if err != nil {
+			return rule, err
+		}
+
+		rule.network = network
+	}
+
+	return rule, nil
+}
+
+// parseProtocol accepts either an IANA protocol keyword or number
+func parseProtocol(s string) (uint8, error) {
+	s = strings.ToLower(s)
+
+	for number, name := range protocolNames {
+		if name == s {
+			return number, nil
+		}
+	}
+
+	protocol, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("unknown protocol '%s'", s)
+	}
+
+	return uint8(protocol), nil
+}
+
+/******************************************************************************
+ *
+ * Classify the application of a rec.  User rules are tried first against the
+ * destination then the source side, followed by the IANA table for the well
+ * known side of the connection.
+ *
+ ******************************************************************************/
+func (n *NetInfo) Application(rec *flowrecord.FlowRecord) (string, bool) {
+	ports := rec.HasPorts()
+
+	for i := range n.services {
+		rule := &n.services[i]
+
+		if rule.matches(rec.Protocol, ports, rec.DestinationPort, rec.DestinationAddress) {
+			return rule.application, true
+		}
+
+		if rule.matches(rec.Protocol, ports, rec.SourcePort, rec.SourceAddress) {
+			return rule.application, true
+		}
+	}
+
+	if !hasPorts(rec.Protocol) {
+		return "", false
+	}
+
+	port, ok := wellKnownPort(rec.SourcePort, rec.DestinationPort)
+	if !ok {
+		return "", false
+	}
+
+	return serviceNames[port], true
+}
+
+// wellKnownPort picks the side of the connection a service is listening on
+func wellKnownPort(source uint16, destination uint16) (uint16, bool) {
+	_, sourceKnown := serviceNames[source]
+	_, destinationKnown := serviceNames[destination]
+
+	switch {
+	case sourceKnown && destinationKnown:
+		if source < destination {
+			return source, true
+		}
+
+		return destination, true
+	case destinationKnown:
+		return destination, true
+	case sourceKnown:
+		return source, true
+	}
+
+	return 0, false
+}
+
+func (n *NetInfo) enrichApplication(rec *flowrecord.FlowRecord) {
+	if !rec.HasProtocol {
+		return
+	}
+
+	rec.Enrich("protocolName", ProtocolName(rec.Protocol))
+
+	if application, ok := n.Application(rec); ok {
+		rec.Enrich("application", application)
+	}
+}
@@ -0,0 +1,74 @@
+package netinfo
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestProtocolName(t *testing.T) {
+	assert.Equal(t, "tcp", ProtocolName(ProtocolTCP))
+	assert.Equal(t, "ipv6-icmp", ProtocolName(ProtocolICMPv6))
+	assert.Equal(t, "253", ProtocolName(253))
+}
+
+func TestApplication(t *testing.T) {
+	filename := writeTestFile(t, `billing,tcp,8000-8100,10.1.0.0/16
+metrics,udp,8125,
+vpn,50,,
+management,,0-1023,10.9.0.0/16
+hop,0,,
+broken,tcp,9000-8000,
+`)
+	defer os.Remove(filename)
+
+	n := &NetInfo{}
+	count, err := n.loadServices(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(5), count)
+
+	client := net.ParseIP("192.168.1.10")
+	server := net.ParseIP("10.1.2.3")
+	management := net.ParseIP("10.9.0.1")
+
+	tests := []struct {
+		flow     *flowrecord.FlowRecord
+		expected string
+	}{
+		// Well known side of the connection, in either direction
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolTCP, SourcePort: 51515, DestinationPort: 443}, "https"},
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolUDP, SourcePort: 53, DestinationPort: 40000}, "dns"},
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolTCP, SourcePort: 8080, DestinationPort: 22}, "ssh"},
+		// User rules, with and without a network scope
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolTCP, SourceAddress: client, DestinationAddress: server, SourcePort: 40000, DestinationPort: 8042}, "billing"},
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolTCP, SourceAddress: server, DestinationAddress: client, SourcePort: 8042, DestinationPort: 40000}, "billing"},
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolTCP, SourceAddress: client, DestinationAddress: client, SourcePort: 40000, DestinationPort: 8042}, ""},
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolUDP, SourcePort: 40000, DestinationPort: 8125}, "metrics"},
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolTCP, SourcePort: 40000, DestinationPort: 40001}, ""},
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: 50}, "vpn"},
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolICMP}, ""},
+		// Rules for any protocol, port rules only for protocols with ports
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolTCP, DestinationAddress: management, SourcePort: 40000, DestinationPort: 22}, "management"},
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolICMP, DestinationAddress: management}, ""},
+		// Protocol 0 is HOPOPT, not any
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: 0}, "hop"},
+		{&flowrecord.FlowRecord{HasProtocol: true, Protocol: 47}, ""},
+	}
+
+	for _, test := range tests {
+		application, _ := n.Application(test.flow)
+		assert.Equal(t, test.expected, application)
+	}
+
+	rec := &flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolICMP}
+	n.Enrich(rec)
+	assert.Equal(t, map[string]interface{}{"protocolName": "icmp"}, rec.Enrichment)
+
+	rec = &flowrecord.FlowRecord{HasProtocol: true, Protocol: ProtocolTCP, SourcePort: 40000, DestinationPort: 3306}
+	n.Enrich(rec)
+	assert.Equal(t, map[string]interface{}{"protocolName": "tcp", "application": "mysql"}, rec.Enrichment)
+}
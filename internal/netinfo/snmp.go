@@ -0,0 +1,406 @@
+package netinfo
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/soniah/gosnmp"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type ControlMessage int
+
+const (
+	ControlMessageQuit  ControlMessage = iota
+	ControlMessageStart ControlMessage = iota
+	ControlMessageReady ControlMessage = iota
+	ControlMessageDone  ControlMessage = iota
+)
+
+// OIDs walked on every agent
+const (
+	oidSysName     = ".1.3.6.1.2.1.1.5.0"
+	oidIfName      = ".1.3.6.1.2.1.31.1.1.1.1"
+	oidIfHighSpeed = ".1.3.6.1.2.1.31.1.1.1.15"
+	oidIfAlias     = ".1.3.6.1.2.1.31.1.1.1.18"
+)
+
+const snmpQueueSize = 1024
+
+const (
+	DefaultSnmpPollInterval = 15 * time.Minute
+	DefaultSnmpWorkers      = 8
+)
+
+// SnmpConfig controls polling flow agents for their system and interface names
+type SnmpConfig struct {
+	Enabled        bool          `envconfig:"SNMP_ENABLED"`
+	Version        string        `envconfig:"SNMP_VERSION"`
+	Port           uint16        `envconfig:"SNMP_PORT"`
+	Community      string        `envconfig:"SNMP_COMMUNITY"`
+	Username       string        `envconfig:"SNMP_USERNAME"`
+	AuthProtocol   string        `envconfig:"SNMP_AUTH_PROTOCOL"`
+	AuthPassphrase string        `envconfig:"SNMP_AUTH_PASSPHRASE"`
+	PrivProtocol   string        `envconfig:"SNMP_PRIV_PROTOCOL"`
+	PrivPassphrase string        `envconfig:"SNMP_PRIV_PASSPHRASE"`
+	Interval       time.Duration `envconfig:"SNMP_POLL_INTERVAL"`
+	Timeout        time.Duration `envconfig:"SNMP_TIMEOUT"`
+	Retries        int           `envconfig:"SNMP_RETRIES"`
+	Workers        int           `envconfig:"SNMP_WORKERS"`
+}
+
+type snmpAgent struct {
+	sysName    string
+	interfaces map[uint32]Interface
+}
+
+/******************************************************************************
+ *
+ * SnmpPoller walks every agent we have seen flows from and caches the results
+ *
+ ******************************************************************************/
+type SnmpPoller struct {
+	config    SnmpConfig
+	lock      sync.RWMutex
+	agents    map[string]*snmpAgent
+	pollQueue chan string
+}
+
+func NewSnmpPoller(config SnmpConfig) *SnmpPoller {
+	if config.Interval <= 0 {
+		config.Interval = DefaultSnmpPollInterval
+	}
+
+	if config.Workers <= 0 {
+		config.Workers = DefaultSnmpWorkers
+	}
+
+	return &SnmpPoller{
+		config:    config,
+		agents:    make(map[string]*snmpAgent),
+		pollQueue: make(chan string, snmpQueueSize),
+	}
+}
+
+// Observe registers an agent to be polled, without blocking the caller
+func (p *SnmpPoller) Observe(agent string) {
+	if p == nil || agent == "" {
+		return
+	}
+
+	p.lock.RLock()
+	_, ok := p.agents[agent]
+	p.lock.RUnlock()
+
+	if ok {
+		return
+	}
+
+	p.lock.Lock()
+	if _, ok = p.agents[agent]; !ok {
+		p.agents[agent] = &snmpAgent{}
+	}
+	p.lock.Unlock()
+
+	if !ok {
+		p.enqueue(agent)
+	}
+}
+
+// SysName returns the cached sysName of the agent
+func (p *SnmpPoller) SysName(agent string) (string, bool) {
+	if p == nil {
+		return "", false
+	}
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if a, ok := p.agents[agent]; ok && a.sysName != "" {
+		return a.sysName, true
+	}
+
+	return "", false
+}
+
+// Interface returns the cached details of ifIndex index on the agent
+func (p *SnmpPoller) Interface(agent string, index uint32) (Interface, bool) {
+	if p == nil {
+		return Interface{}, false
+	}
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if a, ok := p.agents[agent]; ok {
+		iface, ok := a.interfaces[index]
+		return iface, ok
+	}
+
+	return Interface{}, false
+}
+
+func (p *SnmpPoller) enqueue(agent string) {
+	select {
+	case p.pollQueue <- agent:
+	default:
+		log.Warnf("SnmpPoller: poll queue full, skipping '%s' until next interval", agent)
+	}
+}
+
+/******************************************************************************
+ *
+ * Start the SNMP poller
+ *
+ ******************************************************************************/
+func (p *SnmpPoller) Start(controlChan chan ControlMessage) error {
+	if _, err := p.client(""); err != nil {
+		log.Errorf("SnmpPoller: Invalid configuration: %v", err)
+		return err
+	}
+
+	var wg sync.WaitGroup
+
+	done := make(chan struct{})
+
+	for i := 0; i < p.config.Workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				case agent := <-p.pollQueue:
+					if err := p.poll(agent); err != nil {
+						log.Warnf("SnmpPoller: Failed to poll '%s': %v", agent, err)
+					}
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(p.config.Interval)
+	defer ticker.Stop()
+
+	log.Infof("SnmpPoller: Polling agents every %s", p.config.Interval)
+
+	for {
+		select {
+		case msg := <-controlChan:
+			switch msg {
+			case ControlMessageStart:
+				// We're ready!
+				log.Debug("SnmpPoller: Control Message: Start")
+				continue
+			case ControlMessageQuit:
+				log.Debug("SnmpPoller: Control Message: Quit")
+				close(done)
+				wg.Wait()
+
+				controlChan <- ControlMessageDone // Signal exit
+
+				return nil
+			}
+		case <-ticker.C:
+			p.lock.RLock()
+			for agent := range p.agents {
+				p.enqueue(agent)
+			}
+			p.lock.RUnlock()
+		}
+	}
+}
+
+/******************************************************************************
+ *
+ * Build a client for the agent from the config
+ *
+ ******************************************************************************/
+func (p *SnmpPoller) client(agent string) (*gosnmp.GoSNMP, error) {
+	client := &gosnmp.GoSNMP{
+		Target:  agent,
+		Port:    p.config.Port,
+		Timeout: p.config.Timeout,
+		Retries: p.config.Retries,
+	}
+
+	switch p.config.Version {
+	case "2c", "2":
+		client.Version = gosnmp.Version2c
+		client.Community = p.config.Community
+	case "3":
+		authProtocol, err := snmpAuthProtocol(p.config.AuthProtocol)
+		if err != nil {
+			return nil, err
+		}
+
+		privProtocol, err := snmpPrivProtocol(p.config.PrivProtocol)
+		if err != nil {
+			return nil, err
+		}
+
+		client.Version = gosnmp.Version3
+		client.SecurityModel = gosnmp.UserSecurityModel
+		client.SecurityParameters = &gosnmp.UsmSecurityParameters{
+			UserName:                 p.config.Username,
+			AuthenticationProtocol:   authProtocol,
+			AuthenticationPassphrase: p.config.AuthPassphrase,
+			PrivacyProtocol:          privProtocol,
+			PrivacyPassphrase:        p.config.PrivPassphrase,
+		}
+
+		switch {
+		case privProtocol != gosnmp.NoPriv:
+			client.MsgFlags = gosnmp.AuthPriv
+		case authProtocol != gosnmp.NoAuth:
+			client.MsgFlags = gosnmp.AuthNoPriv
+		default:
+			client.MsgFlags = gosnmp.NoAuthNoPriv
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SNMP version '%s'", p.config.Version)
+	}
+
+	return client, nil
+}
+
+func snmpAuthProtocol(name string) (gosnmp.SnmpV3AuthProtocol, error) {
+	switch strings.ToUpper(name) {
+	case "", "NONE":
+		return gosnmp.NoAuth, nil
+	case "MD5":
+		return gosnmp.MD5, nil
+	case "SHA":
+		return gosnmp.SHA, nil
+	case "SHA224":
+		return gosnmp.SHA224, nil
+	case "SHA256":
+		return gosnmp.SHA256, nil
+	case "SHA384":
+		return gosnmp.SHA384, nil
+	case "SHA512":
+		return gosnmp.SHA512, nil
+	}
+
+	return gosnmp.NoAuth, fmt.Errorf("unsupported SNMP auth protocol '%s'", name)
+}
+
+func snmpPrivProtocol(name string) (gosnmp.SnmpV3PrivProtocol, error) {
+	switch strings.ToUpper(name) {
+	case "", "NONE":
+		return gosnmp.NoPriv, nil
+	case "DES":
+		return gosnmp.DES, nil
+	case "AES":
+		return gosnmp.AES, nil
+	case "AES192":
+		return gosnmp.AES192, nil
+	case "AES256":
+		return gosnmp.AES256, nil
+	case "AES192C":
+		return gosnmp.AES192C, nil
+	case "AES256C":
+		return gosnmp.AES256C, nil
+	}
+
+	return gosnmp.NoPriv, fmt.Errorf("unsupported SNMP privacy protocol '%s'", name)
+}
+
+/******************************************************************************
+ *
+ * Walk a single agent and replace its cached data
+ *
+ ******************************************************************************/
+func (p *SnmpPoller) poll(agent string) error {
+	client, err := p.client(agent)
+	if err != nil {
+		return err
+	}
+
+	if err = client.Connect(); err != nil {
+		return err
+	}
+	defer client.Conn.Close()
+
+	result := &snmpAgent{
+		interfaces: make(map[uint32]Interface),
+	}
+
+	resp, err := client.Get([]string{oidSysName})
+	if err != nil {
+		return err
+	}
+
+	for _, pdu := range resp.Variables {
+		if b, ok := pdu.Value.([]byte); ok {
+			result.sysName = string(b)
+		}
+	}
+
+	// A walk the agent does not answer only loses its own values
+	for _, oid := range []string{oidIfName, oidIfAlias, oidIfHighSpeed} {
+		root := oid
+
+		err = client.BulkWalk(root, func(pdu gosnmp.SnmpPDU) error {
+			result.apply(root, pdu)
+			return nil
+		})
+		if err != nil {
+			log.Warnf("SnmpPoller: Failed to walk %s on '%s': %v", root, agent, err)
+		}
+	}
+
+	p.lock.Lock()
+	p.agents[agent] = result
+	p.lock.Unlock()
+
+	log.Debugf("SnmpPoller: '%s' (%s) has %d interfaces", agent, result.sysName, len(result.interfaces))
+
+	return nil
+}
+
+// apply stores a single walked value on the interface it belongs to
+func (a *snmpAgent) apply(root string, pdu gosnmp.SnmpPDU) {
+	index, ok := snmpIndex(root, pdu.Name)
+	if !ok {
+		return
+	}
+
+	iface := a.interfaces[index]
+
+	switch root {
+	case oidIfName:
+		if b, ok := pdu.Value.([]byte); ok {
+			iface.Name = string(b)
+		}
+	case oidIfAlias:
+		if b, ok := pdu.Value.([]byte); ok {
+			iface.Description = string(b)
+		}
+	case oidIfHighSpeed:
+		iface.Speed = gosnmp.ToBigInt(pdu.Value).Uint64()
+	}
+
+	a.interfaces[index] = iface
+}
+
+// snmpIndex returns the ifIndex suffix of a walked OID
+func snmpIndex(root string, oid string) (uint32, bool) {
+	if !strings.HasPrefix(oid, root+".") {
+		return 0, false
+	}
+
+	index, err := strconv.ParseUint(oid[len(root)+1:], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint32(index), true
+}
@@ -0,0 +1,49 @@
+//go:build integration
+// +build integration
+
+package netinfo
+
+import (
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Run against a local simulator, for example:
+//
+//	snmpsim-command-responder --agent-udpv4-endpoint=127.0.0.1:1161
+//	SNMP_SIMULATOR_ADDRESS=127.0.0.1 SNMP_SIMULATOR_PORT=1161 make test-integration
+func TestSnmpPollSimulator(t *testing.T) {
+	address := os.Getenv("SNMP_SIMULATOR_ADDRESS")
+	if address == "" {
+		t.Skip("SNMP_SIMULATOR_ADDRESS not set")
+	}
+
+	port, err := strconv.ParseUint(os.Getenv("SNMP_SIMULATOR_PORT"), 10, 16)
+	if err != nil {
+		port = 161
+	}
+
+	community := os.Getenv("SNMP_SIMULATOR_COMMUNITY")
+	if community == "" {
+		community = "public"
+	}
+
+	p := NewSnmpPoller(SnmpConfig{
+		Version:   "2c",
+		Port:      uint16(port),
+		Community: community,
+		Timeout:   5 * time.Second,
+		Retries:   1,
+	})
+
+	assert.NoError(t, p.poll(address))
+
+	name, ok := p.SysName(address)
+	assert.True(t, ok)
+	assert.NotEmpty(t, name)
+	assert.NotEmpty(t, p.agents[address].interfaces)
+}
@@ -0,0 +1,75 @@
+package netinfo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/soniah/gosnmp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnmpIndex(t *testing.T) {
+	index, ok := snmpIndex(oidIfName, oidIfName+".42")
+	assert.True(t, ok)
+	assert.Equal(t, uint32(42), index)
+
+	_, ok = snmpIndex(oidIfName, oidIfAlias+".42")
+	assert.False(t, ok)
+
+	_, ok = snmpIndex(oidIfName, oidIfName+".1.2")
+	assert.False(t, ok)
+}
+
+func TestSnmpAgentApply(t *testing.T) {
+	a := &snmpAgent{interfaces: make(map[uint32]Interface)}
+
+	a.apply(oidIfName, gosnmp.SnmpPDU{Name: oidIfName + ".3", Type: gosnmp.OctetString, Value: []byte("Ethernet3")})
+	a.apply(oidIfAlias, gosnmp.SnmpPDU{Name: oidIfAlias + ".3", Type: gosnmp.OctetString, Value: []byte("Uplink")})
+	a.apply(oidIfHighSpeed, gosnmp.SnmpPDU{Name: oidIfHighSpeed + ".3", Type: gosnmp.Gauge32, Value: uint(10000)})
+
+	assert.Equal(t, Interface{Name: "Ethernet3", Description: "Uplink", Speed: 10000}, a.interfaces[3])
+}
+
+func TestNewSnmpPoller(t *testing.T) {
+	// Settings that would never poll fall back to the defaults
+	p := NewSnmpPoller(SnmpConfig{Interval: -time.Second, Workers: -1})
+	assert.Equal(t, DefaultSnmpPollInterval, p.config.Interval)
+	assert.Equal(t, DefaultSnmpWorkers, p.config.Workers)
+
+	p = NewSnmpPoller(SnmpConfig{Interval: time.Minute, Workers: 2})
+	assert.Equal(t, time.Minute, p.config.Interval)
+	assert.Equal(t, 2, p.config.Workers)
+}
+
+func TestSnmpClient(t *testing.T) {
+	p := NewSnmpPoller(SnmpConfig{Version: "3", AuthProtocol: "sha", PrivProtocol: "aes"})
+	client, err := p.client("10.0.0.1")
+	assert.NoError(t, err)
+	assert.Equal(t, gosnmp.AuthPriv, client.MsgFlags)
+
+	p = NewSnmpPoller(SnmpConfig{Version: "3", AuthProtocol: "rot13"})
+	_, err = p.client("10.0.0.1")
+	assert.Error(t, err)
+
+	p = NewSnmpPoller(SnmpConfig{Version: "1"})
+	_, err = p.client("10.0.0.1")
+	assert.Error(t, err)
+}
+
+func TestSnmpObserve(t *testing.T) {
+	p := NewSnmpPoller(SnmpConfig{})
+
+	p.Observe("10.0.0.1")
+	p.Observe("10.0.0.1")
+	assert.Len(t, p.pollQueue, 1)
+
+	_, ok := p.SysName("10.0.0.1")
+	assert.False(t, ok)
+
+	// nil pollers are safe to use
+	var empty *SnmpPoller
+
+	empty.Observe("10.0.0.1")
+	_, ok = empty.Interface("10.0.0.1", 1)
+	assert.False(t, ok)
+}
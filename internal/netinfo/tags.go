@@ -0,0 +1,129 @@
+package netinfo
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+
+	"github.com/yl2chen/cidranger"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// tagNetwork is a RangerEntry carrying the user defined tags of a network
+type tagNetwork struct {
+	ipNet net.IPNet
+	tags  map[string]string
+}
+
+// Minimum required for RangerEntry
+func (t *tagNetwork) Network() net.IPNet {
+	return t.ipNet
+}
+
+func (t *tagNetwork) prefixLength() int {
+	ones, _ := t.ipNet.Mask.Size()
+	return ones
+}
+
+// For debugging
+func (t *tagNetwork) String() string {
+	return fmt.Sprintf("%s, %v", t.ipNet.String(), t.tags)
+}
+
+/******************************************************************************
+ *
+ * Read the CIDR tags into the config
+ *
+ * Expected Format:
+ *   network,key,value
+ ******************************************************************************/
+func (n *NetInfo) loadTags(filename string) (count uint32, err error) {
+	n.tags = cidranger.NewPCTrieRanger()
+
+	if filename == "" {
+		return 0, nil
+	}
+
+	fileh, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer fileh.Close()
+
+	reader := csv.NewReader(fileh)
+	reader.FieldsPerRecord = 3
+	reader.TrimLeadingSpace = true
+
+	// Collect every tag for a network before inserting it
+	networks := make(map[string]*tagNetwork)
+
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return count, err
+		}
+
+		_, network, err := net.ParseCIDR(line[0])
+		if err != nil {
+			log.Errorf("failed to parse network with error: %v", err.Error())
+			continue
+		}
+
+		if line[1] == "" {
+			log.Errorf("missing tag name for network '%s'", line[0])
+			continue
+		}
+
+		entry, ok := networks[network.String()]
+		if !ok {
+			entry = &tagNetwork{ipNet: *network, tags: make(map[string]string)}
+			networks[network.String()] = entry
+		}
+
+		entry.tags[line[1]] = line[2]
+		count++
+	}
+
+	for _, entry := range networks {
+		if err = n.tags.Insert(entry); err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// Tags returns the tags for the address. Each key is taken from the longest
+// matching prefix that defines it.
+func (n *NetInfo) Tags(ip net.IP) map[string]string {
+	if n.tags == nil || ip == nil {
+		return nil
+	}
+
+	entries, err := n.tags.ContainingNetworks(ip)
+	if err != nil || len(entries) == 0 {
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].(*tagNetwork).prefixLength() < entries[j].(*tagNetwork).prefixLength()
+	})
+
+	tags := make(map[string]string)
+
+	for _, entry := range entries {
+		for k, v := range entry.(*tagNetwork).tags {
+			tags[k] = v
+		}
+	}
+
+	return tags
+}
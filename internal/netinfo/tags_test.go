@@ -0,0 +1,49 @@
+package netinfo
+
+import (
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestTags(t *testing.T) {
+	filename := writeTestFile(t, `10.0.0.0/8,environment,production
+10.0.0.0/8,site,unknown
+10.1.0.0/16,site,nyc1
+10.1.4.0/24,team,payments
+2001:db8::/32,site,ams1
+not-a-network,site,nowhere
+`)
+	defer os.Remove(filename)
+
+	n := &NetInfo{}
+	count, err := n.loadTags(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(5), count)
+
+	assert.Equal(t, map[string]string{
+		"environment": "production",
+		"site":        "nyc1",
+		"team":        "payments",
+	}, n.Tags(net.ParseIP("10.1.4.20")))
+
+	assert.Equal(t, map[string]string{
+		"environment": "production",
+		"site":        "unknown",
+	}, n.Tags(net.ParseIP("10.2.0.1")))
+
+	assert.Equal(t, map[string]string{"site": "ams1"}, n.Tags(net.ParseIP("2001:db8::1")))
+	assert.Nil(t, n.Tags(net.ParseIP("192.168.0.1")))
+
+	rec := &flowrecord.FlowRecord{SourceAddress: net.ParseIP("10.1.0.1"), DestinationAddress: net.ParseIP("2001:db8::1")}
+	n.Enrich(rec)
+	assert.Equal(t, map[string]interface{}{
+		"source.environment": "production",
+		"source.site":        "nyc1",
+		"destination.site":   "ams1",
+	}, rec.Enrichment)
+}
@@ -0,0 +1,462 @@
+package netinfo
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yl2chen/cidranger"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// Threat severities, lowest to highest
+const (
+	ThreatSeverityLow      = "low"
+	ThreatSeverityMedium   = "medium"
+	ThreatSeverityHigh     = "high"
+	ThreatSeverityCritical = "critical"
+)
+
+var threatSeverityRank = map[string]int{
+	ThreatSeverityLow:      1,
+	ThreatSeverityMedium:   2,
+	ThreatSeverityHigh:     3,
+	ThreatSeverityCritical: 4,
+}
+
+// STIX patterns we understand, e.g. [ipv4-addr:value = '198.51.100.0/24']
+var stixAddressPattern = regexp.MustCompile(`ipv[46]-addr:value\s*=\s*'([^']+)'`)
+
+// ThreatConfig controls matching flows against threat intelligence lists
+type ThreatConfig struct {
+	Files          []string      `envconfig:"THREAT_FILES"`
+	Severity       string        `envconfig:"THREAT_DEFAULT_SEVERITY"`
+	Events         bool          `envconfig:"THREAT_EVENTS"`
+	EventType      string        `envconfig:"THREAT_EVENT_TYPE"`
+	ReloadInterval time.Duration `envconfig:"THREAT_RELOAD_INTERVAL"`
+}
+
+// Threat is a single indicator from a threat list
+type Threat struct {
+	Indicator string
+	Feed      string
+	Severity  string
+}
+
+// threatNetwork is a RangerEntry for an indicator
+type threatNetwork struct {
+	ipNet  net.IPNet
+	threat Threat
+}
+
+// Minimum required for RangerEntry
+func (t *threatNetwork) Network() net.IPNet {
+	return t.ipNet
+}
+
+/******************************************************************************
+ *
+ * ThreatList holds every indicator from the configured lists, and reloads
+ * them when they change on disk
+ *
+ ******************************************************************************/
+type ThreatList struct {
+	config   ThreatConfig
+	lock     sync.RWMutex
+	networks cidranger.Ranger
+	modTimes map[string]time.Time
+}
+
+func NewThreatList(config ThreatConfig) *ThreatList {
+	if config.Severity == "" {
+		config.Severity = ThreatSeverityMedium
+	}
+
+	if config.EventType == "" {
+		config.EventType = "networkThreat"
+	}
+
+	return &ThreatList{
+		config:   config,
+		networks: cidranger.NewPCTrieRanger(),
+		modTimes: make(map[string]time.Time),
+	}
+}
+
+// Match returns the most severe threat containing the address
+func (t *ThreatList) Match(ip net.IP) (Threat, bool) {
+	if t == nil || ip == nil {
+		return Threat{}, false
+	}
+
+	t.lock.RLock()
+	entries, err := t.networks.ContainingNetworks(ip)
+	t.lock.RUnlock()
+
+	if err != nil || len(entries) == 0 {
+		return Threat{}, false
+	}
+
+	best := entries[0].(*threatNetwork).threat
+
+	for _, entry := range entries[1:] {
+		if threat := entry.(*threatNetwork).threat; moreSevere(threat.Severity, best.Severity) {
+			best = threat
+		}
+	}
+
+	return best, true
+}
+
+func moreSevere(a string, b string) bool {
+	return threatSeverityRank[a] > threatSeverityRank[b]
+}
+
+/******************************************************************************
+ *
+ * Load every list, replacing the current indicators only if all succeed
+ *
+ ******************************************************************************/
+func (t *ThreatList) Load() (count uint32, err error) {
+	indicators := make(map[string]*threatNetwork)
+	modTimes := make(map[string]time.Time)
+
+	for _, filename := range t.config.Files {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return 0, err
+		}
+
+		modTimes[filename] = info.ModTime()
+
+		if err = t.loadFile(filename, indicators); err != nil {
+			return 0, fmt.Errorf("%s: %v", filename, err)
+		}
+	}
+
+	networks := cidranger.NewPCTrieRanger()
+
+	for _, entry := range indicators {
+		if err = networks.Insert(entry); err != nil {
+			return 0, err
+		}
+
+		count++
+	}
+
+	t.lock.Lock()
+	t.networks = networks
+	t.modTimes = modTimes
+	t.lock.Unlock()
+
+	return count, nil
+}
+
+// changed returns true if any list has been modified since it was loaded
+func (t *ThreatList) changed() bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	for _, filename := range t.config.Files {
+		info, err := os.Stat(filename)
+		if err != nil {
+			continue
+		}
+
+		if !info.ModTime().Equal(t.modTimes[filename]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *ThreatList) loadFile(filename string, indicators map[string]*threatNetwork) error {
+	fileh, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer fileh.Close()
+
+	feed := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+
+	add := func(indicator string, threat Threat) {
+		network, err := parseIndicator(indicator)
+		if err != nil {
+			log.Errorf("failed to parse threat indicator with error: %v", err.Error())
+			return
+		}
+
+		if threat.Feed == "" {
+			threat.Feed = feed
+		}
+
+		if _, ok := threatSeverityRank[threat.Severity]; !ok {
+			threat.Severity = t.config.Severity
+		}
+
+		threat.Indicator = network.String()
+
+		// Keep the most severe listing of an indicator across feeds
+		if existing, ok := indicators[threat.Indicator]; ok && !moreSevere(threat.Severity, existing.threat.Severity) {
+			return
+		}
+
+		indicators[threat.Indicator] = &threatNetwork{ipNet: *network, threat: threat}
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return readStixThreats(fileh, add)
+	case ".csv":
+		return readCsvThreats(fileh, add)
+	default:
+		return readTextThreats(fileh, add)
+	}
+}
+
+// parseIndicator accepts either a single address or a network
+func parseIndicator(s string) (*net.IPNet, error) {
+	if strings.Contains(s, "/") {
+		_, network, err := net.ParseCIDR(s)
+		return network, err
+	}
+
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid address '%s'", s)
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+/******************************************************************************
+ *
+ * Plain text lists
+ *
+ * Expected Format:
+ *   one address or network per line, '#' or ';' starts a comment
+ ******************************************************************************/
+func readTextThreats(r io.Reader, add func(string, Threat)) error {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if i := strings.IndexAny(line, "#;"); i >= 0 {
+			line = line[:i]
+		}
+
+		if fields := strings.Fields(line); len(fields) > 0 {
+			add(fields[0], Threat{})
+		}
+	}
+
+	return scanner.Err()
+}
+
+/******************************************************************************
+ *
+ * CSV lists
+ *
+ * Expected Format:
+ *   network,feed,severity
+ ******************************************************************************/
+func readCsvThreats(r io.Reader, add func(string, Threat)) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	for {
+		line, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		threat := Threat{}
+
+		if len(line) > 1 {
+			threat.Feed = line[1]
+		}
+
+		if len(line) > 2 {
+			threat.Severity = strings.ToLower(line[2])
+		}
+
+		add(line[0], threat)
+	}
+}
+
+/******************************************************************************
+ *
+ * STIX-lite JSON lists, a bundle of indicators with address patterns
+ *
+ * Expected Format:
+ *   {"name": "feed", "severity": "high", "objects": [
+ *     {"type": "indicator", "pattern": "[ipv4-addr:value = '198.51.100.1']", "severity": "critical"}
+ *   ]}
+ ******************************************************************************/
+type stixBundle struct {
+	Name     string       `json:"name"`
+	Severity string       `json:"severity"`
+	Objects  []stixObject `json:"objects"`
+}
+
+type stixObject struct {
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Pattern  string `json:"pattern"`
+	Severity string `json:"severity"`
+}
+
+func readStixThreats(r io.Reader, add func(string, Threat)) error {
+	var bundle stixBundle
+
+	if err := json.NewDecoder(r).Decode(&bundle); err != nil {
+		return err
+	}
+
+	for _, object := range bundle.Objects {
+		if object.Type != "indicator" {
+			continue
+		}
+
+		severity := object.Severity
+		if severity == "" {
+			severity = bundle.Severity
+		}
+
+		for _, match := range stixAddressPattern.FindAllStringSubmatch(object.Pattern, -1) {
+			add(match[1], Threat{Feed: bundle.Name, Severity: strings.ToLower(severity)})
+		}
+	}
+
+	return nil
+}
+
+/******************************************************************************
+ *
+ * Start watching the lists for changes
+ *
+ ******************************************************************************/
+func (t *ThreatList) Start(controlChan chan ControlMessage) error {
+	var reload <-chan time.Time
+
+	// A zero interval disables reloading
+	if t.config.ReloadInterval > 0 {
+		ticker := time.NewTicker(t.config.ReloadInterval)
+		defer ticker.Stop()
+
+		reload = ticker.C
+
+		log.Infof("ThreatList: Checking for changes every %s", t.config.ReloadInterval)
+	}
+
+	for {
+		select {
+		case msg := <-controlChan:
+			switch msg {
+			case ControlMessageStart:
+				// We're ready!
+				log.Debug("ThreatList: Control Message: Start")
+				continue
+			case ControlMessageQuit:
+				log.Debug("ThreatList: Control Message: Quit")
+				controlChan <- ControlMessageDone // Signal exit
+
+				return nil
+			}
+		case <-reload:
+			if !t.changed() {
+				continue
+			}
+
+			count, err := t.Load()
+			if err != nil {
+				log.Errorf("ThreatList: Failed to reload, keeping previous lists: %v", err)
+				continue
+			}
+
+			log.Infof("ThreatList: Reloaded %d threat indicators", count)
+		}
+	}
+}
+
+/******************************************************************************
+ *
+ * Threat attributes for the record
+ *
+ ******************************************************************************/
+func (n *NetInfo) enrichThreat(rec *flowrecord.FlowRecord) {
+	if n.threats == nil {
+		return
+	}
+
+	sourceThreat, sourceMatch := n.threats.Match(rec.SourceAddress)
+	destinationThreat, destinationMatch := n.threats.Match(rec.DestinationAddress)
+
+	rec.Enrich("threatMatch", sourceMatch || destinationMatch)
+
+	var (
+		threat Threat
+		side   string
+	)
+
+	switch {
+	case sourceMatch && destinationMatch:
+		threat, side = sourceThreat, "both"
+		if moreSevere(destinationThreat.Severity, sourceThreat.Severity) {
+			threat = destinationThreat
+		}
+	case sourceMatch:
+		threat, side = sourceThreat, "source"
+	case destinationMatch:
+		threat, side = destinationThreat, "destination"
+	default:
+		return
+	}
+
+	rec.Enrich("threatFeed", threat.Feed)
+	rec.Enrich("threatSeverity", threat.Severity)
+	rec.Enrich("threatIndicator", threat.Indicator)
+	rec.Enrich("threatSide", side)
+}
+
+// ThreatEvent returns a separate threat event for a record that matched a
+// threat list, if threat events are enabled
+func (n *NetInfo) ThreatEvent(rec *flowrecord.FlowRecord) (*flowrecord.FlowRecord, bool) {
+	if n == nil || n.threats == nil || !n.threats.config.Events || rec == nil {
+		return nil, false
+	}
+
+	if match, ok := rec.Enrichment["threatMatch"].(bool); !ok || !match {
+		return nil, false
+	}
+
+	event := rec.Clone()
+	event.Type = n.threats.config.EventType
+	event.Enrich("flowEventType", rec.Type)
+
+	return event, true
+}
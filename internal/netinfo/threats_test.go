@@ -0,0 +1,115 @@
+package netinfo
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestThreatList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "threats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := [][2]string{
+		{"blocklist.txt", "# comment\n198.51.100.7\n203.0.113.0/24 ; trailing comment\nnonsense\n"},
+		{"intel.csv", "192.0.2.0/24,intel-feed,High\n198.51.100.7,intel-feed,critical\n"},
+		{"stix.json", `{"name": "stix-feed", "severity": "low", "objects": [
+			{"type": "indicator", "pattern": "[ipv6-addr:value = '2001:db8:bad::/48']"},
+			{"type": "indicator", "pattern": "[ipv4-addr:value = '192.0.2.10']", "severity": "critical"},
+			{"type": "malware", "pattern": "[ipv4-addr:value = '10.0.0.1']"}
+		]}`},
+	}
+
+	config := ThreatConfig{Events: true}
+
+	for _, file := range files {
+		filename := filepath.Join(dir, file[0])
+		assert.NoError(t, ioutil.WriteFile(filename, []byte(file[1]), 0644))
+
+		config.Files = append(config.Files, filename)
+	}
+
+	threats := NewThreatList(config)
+	count, err := threats.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(5), count)
+
+	threat, ok := threats.Match(net.ParseIP("198.51.100.7"))
+	assert.True(t, ok)
+	assert.Equal(t, Threat{Indicator: "198.51.100.7/32", Feed: "intel-feed", Severity: ThreatSeverityCritical}, threat)
+
+	threat, ok = threats.Match(net.ParseIP("203.0.113.99"))
+	assert.True(t, ok)
+	assert.Equal(t, Threat{Indicator: "203.0.113.0/24", Feed: "blocklist", Severity: ThreatSeverityMedium}, threat)
+
+	// The most severe of the containing networks wins
+	threat, ok = threats.Match(net.ParseIP("192.0.2.10"))
+	assert.True(t, ok)
+	assert.Equal(t, Threat{Indicator: "192.0.2.10/32", Feed: "stix-feed", Severity: ThreatSeverityCritical}, threat)
+
+	threat, ok = threats.Match(net.ParseIP("2001:db8:bad::1"))
+	assert.True(t, ok)
+	assert.Equal(t, ThreatSeverityLow, threat.Severity)
+
+	_, ok = threats.Match(net.ParseIP("10.0.0.1"))
+	assert.False(t, ok)
+
+	// Changes on disk are picked up on reload
+	assert.False(t, threats.changed())
+
+	later := time.Now().Add(time.Minute)
+	assert.NoError(t, ioutil.WriteFile(config.Files[0], []byte("10.0.0.1\n"), 0644))
+	assert.NoError(t, os.Chtimes(config.Files[0], later, later))
+	assert.True(t, threats.changed())
+
+	_, err = threats.Load()
+	assert.NoError(t, err)
+
+	_, ok = threats.Match(net.ParseIP("10.0.0.1"))
+	assert.True(t, ok)
+}
+
+func TestThreatEnrich(t *testing.T) {
+	n := &NetInfo{threats: NewThreatList(ThreatConfig{Events: true})}
+
+	network, _ := parseIndicator("198.51.100.7")
+	assert.NoError(t, n.threats.networks.Insert(&threatNetwork{
+		ipNet:  *network,
+		threat: Threat{Indicator: network.String(), Feed: "feed", Severity: ThreatSeverityHigh},
+	}))
+
+	rec := &flowrecord.FlowRecord{Type: "sflow", SourceAddress: net.ParseIP("10.0.0.1"), DestinationAddress: net.ParseIP("198.51.100.7")}
+	n.Enrich(rec)
+
+	assert.Equal(t, map[string]interface{}{
+		"threatMatch":     true,
+		"threatFeed":      "feed",
+		"threatSeverity":  ThreatSeverityHigh,
+		"threatIndicator": "198.51.100.7/32",
+		"threatSide":      "destination",
+	}, rec.Enrichment)
+
+	event, ok := n.ThreatEvent(rec)
+	assert.True(t, ok)
+	assert.Equal(t, "networkThreat", event.EventType())
+	assert.Equal(t, "sflow", event.Enrichment["flowEventType"])
+	assert.Equal(t, "sflow", rec.EventType())
+	assert.NotContains(t, rec.Enrichment, "flowEventType")
+
+	rec = &flowrecord.FlowRecord{Type: "sflow", SourceAddress: net.ParseIP("10.0.0.1"), DestinationAddress: net.ParseIP("10.0.0.2")}
+	n.Enrich(rec)
+	assert.Equal(t, false, rec.Enrichment["threatMatch"])
+
+	_, ok = n.ThreatEvent(rec)
+	assert.False(t, ok)
+}
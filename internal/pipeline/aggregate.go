@@ -0,0 +1,229 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+const (
+	DefaultAggregateEventType = "networkFlowAggregate"
+	DefaultAggregateWindow    = time.Minute
+	DefaultAggregateMaxKeys   = 100000
+)
+
+// Attribute value for every key of flows beyond the limit
+const aggregateOverflow = "other"
+
+var DefaultAggregateKeys = []string{"agent", "srcAS", "dstAS", "protocol", "dstPort", "direction"}
+
+type AggregateConfig struct {
+	Enabled   bool          `envconfig:"AGGREGATE_ENABLED"`
+	Window    time.Duration `envconfig:"AGGREGATE_WINDOW"`
+	Keys      []string      `envconfig:"AGGREGATE_KEYS"`
+	EventType string        `envconfig:"AGGREGATE_EVENT_TYPE"`
+	MaxKeys   int           `envconfig:"AGGREGATE_MAX_KEYS"`
+
+	// Event types rolled up, all the pipeline's by default, and those still
+	// emitted per record as well
+	EventTypes  []string `envconfig:"AGGREGATE_EVENT_TYPES"`
+	Passthrough []string `envconfig:"AGGREGATE_PASSTHROUGH"`
+}
+
+/******************************************************************************
+ *
+ * Create a new Aggregator
+ *
+ ******************************************************************************/
+func NewAggregator(config AggregateConfig) (*Aggregator, error) {
+	if config.Window <= 0 {
+		config.Window = DefaultAggregateWindow
+	}
+
+	if config.Window < tickInterval {
+		return nil, fmt.Errorf("aggregation window %v is shorter than %v", config.Window, tickInterval)
+	}
+
+	if len(config.Keys) == 0 {
+		config.Keys = DefaultAggregateKeys
+	}
+
+	if config.EventType == "" {
+		config.EventType = DefaultAggregateEventType
+	}
+
+	if config.MaxKeys <= 0 {
+		config.MaxKeys = DefaultAggregateMaxKeys
+	}
+
+	if len(config.EventTypes) == 0 {
+		return nil, errors.New("no event types to aggregate")
+	}
+
+	a := &Aggregator{
+		config:      config,
+		eventTypes:  newEventTypes(config.EventTypes),
+		passthrough: newEventTypes(config.Passthrough),
+		buckets:     make(map[string]*aggregateBucket),
+	}
+
+	log.Infof("aggregator: Rolling up %s by %s every %v", strings.Join(config.EventTypes, ", "), strings.Join(config.Keys, ", "), config.Window)
+
+	return a, nil
+}
+
+/******************************************************************************
+ *
+ * Aggregator rolls flow records up by key over a window, and emits a single
+ * event per key when the window closes
+ *
+ ******************************************************************************/
+type Aggregator struct {
+	config      AggregateConfig
+	eventTypes  eventTypes
+	passthrough eventTypes
+
+	windowStart time.Time
+	buckets     map[string]*aggregateBucket
+	overflow    *aggregateBucket
+}
+
+type aggregateBucket struct {
+	key           []interface{}
+	flows         uint64
+	bytes         uint64
+	packets       uint64
+	scaledBytes   uint64
+	scaledPackets uint64
+}
+
+func (a *Aggregator) Name() string {
+	return "aggregate"
+}
+
+func (a *Aggregator) Process(event flowrecord.Event, emit func(flowrecord.Event)) {
+	rec, ok := a.eventTypes.flow(event)
+	if !ok {
+		emit(event)
+		return
+	}
+
+	if !duplicateFlow(rec) {
+		a.add(rec, time.Now())
+	}
+
+	if a.passthrough[rec.EventType()] {
+		emit(event)
+	}
+}
+
+// add counts a record into the bucket for its key
+func (a *Aggregator) add(rec *flowrecord.FlowRecord, now time.Time) {
+	if a.windowStart.IsZero() {
+		a.windowStart = now.Truncate(a.config.Window)
+	}
+
+	key, values := a.key(rec)
+
+	bucket, ok := a.buckets[key]
+	if !ok {
+		if len(a.buckets) < a.config.MaxKeys {
+			bucket = &aggregateBucket{key: values}
+			a.buckets[key] = bucket
+		} else {
+			if a.overflow == nil {
+				log.Warnf("aggregator: More than %d keys in a window, counting the rest as '%s'", a.config.MaxKeys, aggregateOverflow)
+				a.overflow = &aggregateBucket{}
+			}
+
+			bucket = a.overflow
+		}
+	}
+
+	bucket.flows++
+	bucket.bytes += rec.Bytes
+	bucket.packets += rec.Packets
+	bucket.scaledBytes += rec.ScaledBytes()
+	bucket.scaledPackets += rec.ScaledPackets()
+}
+
+// key returns the values of the key attributes, and a string to look them up by
+func (a *Aggregator) key(rec *flowrecord.FlowRecord) (string, []interface{}) {
+	values := make([]interface{}, len(a.config.Keys))
+	parts := make([]string, len(a.config.Keys))
+
+	for i, name := range a.config.Keys {
+		if value, ok := rec.Value(name); ok {
+			values[i] = value
+			parts[i] = fmt.Sprint(value)
+		}
+	}
+
+	return strings.Join(parts, "\x00"), values
+}
+
+/******************************************************************************
+ *
+ * Emit the buckets when the window closes
+ *
+ ******************************************************************************/
+func (a *Aggregator) Tick(now time.Time, emit func(flowrecord.Event)) {
+	if a.windowStart.IsZero() || now.Before(a.windowStart.Add(a.config.Window)) {
+		return
+	}
+
+	a.Flush(now, emit)
+}
+
+func (a *Aggregator) Flush(now time.Time, emit func(flowrecord.Event)) {
+	if a.windowStart.IsZero() {
+		return
+	}
+
+	windowEnd := a.windowStart.Add(a.config.Window)
+	if now.Before(windowEnd) {
+		windowEnd = now
+	}
+
+	for _, bucket := range a.buckets {
+		emit(a.event(bucket, windowEnd))
+	}
+
+	if a.overflow != nil {
+		emit(a.event(a.overflow, windowEnd))
+	}
+
+	a.windowStart = time.Time{}
+	a.buckets = make(map[string]*aggregateBucket, len(a.buckets))
+	a.overflow = nil
+}
+
+func (a *Aggregator) event(bucket *aggregateBucket, windowEnd time.Time) flowrecord.Attributes {
+	event := flowrecord.Attributes{
+		"eventType":     a.config.EventType,
+		"timestamp":     a.windowStart,
+		"windowStart":   a.windowStart.UnixNano() / int64(time.Millisecond),
+		"windowEnd":     windowEnd.UnixNano() / int64(time.Millisecond),
+		"windowSeconds": int64(a.config.Window.Seconds()),
+		"flows":         int64(bucket.flows),
+		"bytes":         int64(bucket.bytes),
+		"packets":       int64(bucket.packets),
+		"scaledBytes":   int64(bucket.scaledBytes),
+		"scaledPackets": int64(bucket.scaledPackets),
+	}
+
+	for i, name := range a.config.Keys {
+		if bucket.key == nil {
+			event[name] = aggregateOverflow
+		} else if bucket.key[i] != nil {
+			event[name] = bucket.key[i]
+		}
+	}
+
+	return event
+}
@@ -0,0 +1,147 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestNewAggregator(t *testing.T) {
+	a, err := NewAggregator(AggregateConfig{EventTypes: []string{"sflow"}})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultAggregateWindow, a.config.Window)
+	assert.Equal(t, DefaultAggregateKeys, a.config.Keys)
+	assert.Equal(t, DefaultAggregateEventType, a.config.EventType)
+
+	_, err = NewAggregator(AggregateConfig{EventTypes: []string{"sflow"}, Window: time.Millisecond})
+	assert.Error(t, err)
+
+	_, err = NewAggregator(AggregateConfig{})
+	assert.Error(t, err)
+}
+
+func TestAggregator(t *testing.T) {
+	a, err := NewAggregator(AggregateConfig{
+		Window:      time.Minute,
+		Keys:        []string{"agent", "dstPort", "direction", "vlanId"},
+		EventTypes:  []string{"sflow", "ipfix"},
+		Passthrough: []string{"ipfix"},
+	})
+	assert.NoError(t, err)
+
+	emitted := []flowrecord.Event{}
+	emit := func(event flowrecord.Event) {
+		emitted = append(emitted, event)
+	}
+
+	for _, rec := range []*flowrecord.FlowRecord{
+		testFlow{dstPort: 443, bytes: 1500, samplingRate: 100}.record(),
+		testFlow{dstPort: 443, bytes: 500, samplingRate: 100}.record(),
+		testFlow{eventType: "ipfix", dstPort: 53, samplingRate: 100}.record(),
+	} {
+		rec.Enrich("direction", "outbound")
+		a.Process(rec, emit)
+	}
+
+	a.Process(flowrecord.Attributes{"eventType": "networkThreat"}, emit)
+
+	// Only passthrough event types and events that are not flows continue
+	assert.Equal(t, 2, len(emitted))
+	assert.Equal(t, "ipfix", emitted[0].EventType())
+	assert.Equal(t, "networkThreat", emitted[1].EventType())
+
+	// Nothing until the window closes
+	emitted = emitted[:0]
+	a.Tick(a.windowStart.Add(30*time.Second), emit)
+	assert.Empty(t, emitted)
+
+	windowStart := a.windowStart
+	a.Tick(windowStart.Add(time.Minute), emit)
+	assert.Equal(t, 2, len(emitted))
+
+	var https flowrecord.Attributes
+	for _, event := range emitted {
+		if event.Attributes()["dstPort"] == int32(443) {
+			https = event.(flowrecord.Attributes)
+		}
+	}
+
+	assert.Equal(t, flowrecord.Attributes{
+		"eventType":     DefaultAggregateEventType,
+		"timestamp":     windowStart,
+		"windowStart":   windowStart.UnixNano() / int64(time.Millisecond),
+		"windowEnd":     windowStart.Add(time.Minute).UnixNano() / int64(time.Millisecond),
+		"windowSeconds": int64(60),
+		"agent":         "192.0.2.1",
+		"dstPort":       int32(443),
+		"direction":     "outbound",
+		"flows":         int64(2),
+		"bytes":         int64(2000),
+		"packets":       int64(2),
+		"scaledBytes":   int64(200000),
+		"scaledPackets": int64(200),
+	}, https)
+
+	// The next window starts empty
+	emitted = emitted[:0]
+	a.Flush(time.Now(), emit)
+	assert.Empty(t, emitted)
+}
+
+func TestAggregatorMaxKeys(t *testing.T) {
+	a, err := NewAggregator(AggregateConfig{
+		Keys:       []string{"dstPort"},
+		EventTypes: []string{"sflow"},
+		MaxKeys:    2,
+	})
+	assert.NoError(t, err)
+
+	emit := func(event flowrecord.Event) {}
+	for port := uint16(1); port <= 5; port++ {
+		a.Process(testFlow{dstPort: port}.record(), emit)
+	}
+
+	assert.Equal(t, 2, len(a.buckets))
+	assert.Equal(t, uint64(3), a.overflow.flows)
+
+	emitted := []flowrecord.Event{}
+	a.Flush(time.Now(), func(event flowrecord.Event) {
+		emitted = append(emitted, event)
+	})
+
+	assert.Equal(t, 3, len(emitted))
+	assert.Equal(t, aggregateOverflow, emitted[2].Attributes()["dstPort"])
+}
+
+func TestAggregatorDuplicates(t *testing.T) {
+	d, err := NewDeduplicator(DedupConfig{}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	a, err := NewAggregator(AggregateConfig{
+		Keys:        []string{"dstPort"},
+		EventTypes:  []string{"sflow"},
+		Passthrough: []string{"sflow"},
+	})
+	assert.NoError(t, err)
+
+	var emitted []flowrecord.Event
+	emit := func(event flowrecord.Event) { emitted = append(emitted, event) }
+
+	// The same flow from two exporters is counted once, and the marked
+	// duplicate still passes through
+	for _, agent := range []string{"192.0.2.1", "192.0.2.2"} {
+		d.Process(testFlow{agent: agent, bytes: 1500}.record(), func(event flowrecord.Event) { a.Process(event, emit) })
+	}
+
+	assert.Equal(t, 2, len(emitted))
+	assert.Equal(t, true, emitted[1].Attributes()["duplicate"])
+
+	emitted = emitted[:0]
+	a.Flush(time.Now(), emit)
+	assert.Equal(t, 1, len(emitted))
+	assert.Equal(t, int64(1), emitted[0].Attributes()["flows"])
+	assert.Equal(t, int64(1500), emitted[0].Attributes()["bytes"])
+}
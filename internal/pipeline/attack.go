@@ -0,0 +1,516 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+const (
+	DefaultAttackEventType       = "networkAttack"
+	DefaultAttackInterval        = 10 * time.Second
+	DefaultAttackPPS             = 100000
+	DefaultAttackBPS             = 1000000000
+	DefaultAttackLearnFactor     = 10
+	DefaultAttackMinPPS          = 10000
+	DefaultAttackVectorRatio     = 0.5
+	DefaultAttackCooldown        = time.Minute
+	DefaultAttackMaxDestinations = 10000
+	DefaultAttackTopSources      = 5
+
+	// Weight of each interval in the learned baseline, and the intervals
+	// seen before it is trusted
+	attackBaselineWeight = 0.1
+	attackBaselineWarmup = 10
+
+	// Intervals a baseline is kept without being learned from, so the
+	// destinations that stopped sending make room for new ones
+	attackBaselineExpiry = 360
+
+	// Sources kept per destination, and per attack
+	attackSourceCapacity = 20
+)
+
+// Status of an attack event
+const (
+	AttackStatusStart  = "start"
+	AttackStatusUpdate = "update"
+	AttackStatusEnd    = "end"
+)
+
+// Attack vectors, by the traffic that makes up most of an attack
+const (
+	AttackVectorVolumetric    = "volumetric"
+	AttackVectorSYNFlood      = "synFlood"
+	AttackVectorUDPFlood      = "udpFlood"
+	AttackVectorICMPFlood     = "icmpFlood"
+	AttackVectorAmplification = "udpAmplification"
+)
+
+// IP protocols counted separately
+const (
+	protocolICMP   uint8 = 1
+	protocolUDP    uint8 = 17
+	protocolICMPv6 uint8 = 58
+)
+
+// Source ports of UDP services abused for reflection
+var DefaultAttackAmplificationPorts = []uint16{17, 19, 53, 111, 123, 137, 161, 389, 1900, 3702, 5353, 11211}
+
+type AttackConfig struct {
+	Enabled   bool          `envconfig:"ATTACK_ENABLED"`
+	EventType string        `envconfig:"ATTACK_EVENT_TYPE"`
+	Interval  time.Duration `envconfig:"ATTACK_INTERVAL"`
+
+	// Fixed thresholds, 0 to disable either
+	PPS uint64 `envconfig:"ATTACK_PPS"`
+	BPS uint64 `envconfig:"ATTACK_BPS"`
+
+	// Learned thresholds, a factor over each destination's usual rate that
+	// also has to reach MinPPS
+	Learn       bool    `envconfig:"ATTACK_LEARN"`
+	LearnFactor float64 `envconfig:"ATTACK_LEARN_FACTOR"`
+	MinPPS      uint64  `envconfig:"ATTACK_MIN_PPS"`
+
+	VectorRatio        float64       `envconfig:"ATTACK_VECTOR_RATIO"`
+	AmplificationPorts []uint16      `envconfig:"ATTACK_AMPLIFICATION_PORTS"`
+	Cooldown           time.Duration `envconfig:"ATTACK_COOLDOWN"`
+	MaxDestinations    int           `envconfig:"ATTACK_MAX_DESTINATIONS"`
+	TopSources         int           `envconfig:"ATTACK_TOP_SOURCES"`
+}
+
+/******************************************************************************
+ *
+ * Create a new AttackDetector
+ *
+ ******************************************************************************/
+func NewAttackDetector(config AttackConfig, flowTypes []string) (*AttackDetector, error) {
+	if config.EventType == "" {
+		config.EventType = DefaultAttackEventType
+	}
+
+	if config.Interval <= 0 {
+		config.Interval = DefaultAttackInterval
+	}
+
+	if config.Interval < tickInterval {
+		return nil, fmt.Errorf("attack interval %v is shorter than %v", config.Interval, tickInterval)
+	}
+
+	if config.PPS == 0 && config.BPS == 0 && !config.Learn {
+		return nil, fmt.Errorf("attack detection needs a pps or bps threshold, or learning")
+	}
+
+	if config.LearnFactor <= 1 {
+		config.LearnFactor = DefaultAttackLearnFactor
+	}
+
+	if config.VectorRatio <= 0 || config.VectorRatio > 1 {
+		config.VectorRatio = DefaultAttackVectorRatio
+	}
+
+	if config.AmplificationPorts == nil {
+		config.AmplificationPorts = DefaultAttackAmplificationPorts
+	}
+
+	if config.Cooldown <= 0 {
+		config.Cooldown = DefaultAttackCooldown
+	}
+
+	if config.MaxDestinations <= 0 {
+		config.MaxDestinations = DefaultAttackMaxDestinations
+	}
+
+	if config.TopSources <= 0 {
+		config.TopSources = DefaultAttackTopSources
+	}
+
+	d := &AttackDetector{
+		config:             config,
+		flowTypes:          newEventTypes(flowTypes),
+		amplificationPorts: make(map[uint16]bool, len(config.AmplificationPorts)),
+		destinations:       make(map[string]*attackStats),
+		heaviest:           newSpaceSaving(config.MaxDestinations),
+		baselines:          make(map[string]*attackBaseline),
+		attacks:            make(map[string]*attack),
+	}
+
+	for _, port := range config.AmplificationPorts {
+		d.amplificationPorts[port] = true
+	}
+
+	log.Infof("attack: Checking destinations every %v, pps %d, bps %d, learning %t", config.Interval, config.PPS, config.BPS, config.Learn)
+
+	return d, nil
+}
+
+/******************************************************************************
+ *
+ * AttackDetector watches the traffic to every destination, interval by
+ * interval, for rates over the fixed thresholds or far over what it has
+ * learned is usual.  An attack is reported when it starts, every interval
+ * while it lasts, and once it has stayed under the thresholds for the
+ * cooldown.
+ *
+ ******************************************************************************/
+type AttackDetector struct {
+	config             AttackConfig
+	flowTypes          eventTypes
+	amplificationPorts map[uint16]bool
+
+	intervalStart time.Time
+	destinations  map[string]*attackStats
+	heaviest      *spaceSaving // Destinations by packets, to keep the heaviest
+	baselines     map[string]*attackBaseline
+	attacks       map[string]*attack
+}
+
+// attackStats is the traffic to a destination in an interval
+type attackStats struct {
+	packets              uint64
+	bytes                uint64
+	synPackets           uint64
+	udpPackets           uint64
+	icmpPackets          uint64
+	amplificationPackets uint64
+	sources              *spaceSaving
+}
+
+// attackBaseline is the usual rate to a destination
+type attackBaseline struct {
+	pps       float64
+	intervals int
+	updated   time.Time
+}
+
+type attack struct {
+	id       string
+	start    time.Time
+	lastSeen time.Time
+	vector   string
+	peakPPS  float64
+	peakBPS  float64
+	sources  *spaceSaving
+	last     attackRates
+}
+
+// attackRates is what an interval's traffic to a destination adds up to
+type attackRates struct {
+	pps                float64
+	bps                float64
+	synRatio           float64
+	udpRatio           float64
+	icmpRatio          float64
+	amplificationRatio float64
+}
+
+func (d *AttackDetector) Name() string {
+	return "attack"
+}
+
+func (d *AttackDetector) Process(event flowrecord.Event, emit func(flowrecord.Event)) {
+	if rec, ok := d.flowTypes.counted(event); ok {
+		d.add(rec, time.Now())
+	}
+
+	emit(event)
+}
+
+// add counts a flow against its destination
+func (d *AttackDetector) add(rec *flowrecord.FlowRecord, now time.Time) {
+	if rec.DestinationAddress == nil {
+		return
+	}
+
+	if d.intervalStart.IsZero() {
+		d.intervalStart = now.Truncate(d.config.Interval)
+	}
+
+	dst := rec.DestinationAddress.String()
+	packets := rec.ScaledPackets()
+
+	// Past the limit the lightest destination makes room, so one with more
+	// than its share of the packets is always counted.  The sketch orders by
+	// its first count, which is given the packets.
+	if replaced := d.heaviest.add(dst, packets, packets); replaced != "" {
+		delete(d.destinations, replaced)
+	}
+
+	stats, ok := d.destinations[dst]
+	if !ok {
+		stats = &attackStats{sources: newSpaceSaving(attackSourceCapacity)}
+		d.destinations[dst] = stats
+	}
+
+	stats.packets += packets
+	stats.bytes += rec.ScaledBytes()
+
+	switch {
+	case !rec.HasProtocol:
+	case rec.Protocol == protocolUDP:
+		stats.udpPackets += packets
+
+		if d.amplificationPorts[rec.SourcePort] {
+			stats.amplificationPackets += packets
+		}
+	case rec.Protocol == protocolICMP || rec.Protocol == protocolICMPv6:
+		stats.icmpPackets += packets
+	case isTCPFlag(rec, "tcpFlagSYN") && !isTCPFlag(rec, "tcpFlagACK"):
+		stats.synPackets += packets
+	}
+
+	if rec.SourceAddress != nil {
+		stats.sources.add(rec.SourceAddress.String(), rec.ScaledBytes(), packets)
+	}
+}
+
+// isTCPFlag returns true if the flow has a TCP control bit set
+func isTCPFlag(rec *flowrecord.FlowRecord, name string) bool {
+	value, _ := rec.Get(name)
+	set, _ := value.(bool)
+
+	return set
+}
+
+/******************************************************************************
+ *
+ * Check every destination when the interval closes
+ *
+ ******************************************************************************/
+func (d *AttackDetector) Tick(now time.Time, emit func(flowrecord.Event)) {
+	if d.intervalStart.IsZero() {
+		// Nothing was seen, attacks still have to end
+		if len(d.attacks) > 0 {
+			d.check(now, emit)
+		}
+
+		return
+	}
+
+	if now.Before(d.intervalStart.Add(d.config.Interval)) {
+		return
+	}
+
+	d.check(now, emit)
+}
+
+// Flush ends the attacks in progress, the detector will not see them again
+func (d *AttackDetector) Flush(now time.Time, emit func(flowrecord.Event)) {
+	for dst, a := range d.attacks {
+		emit(d.event(AttackStatusEnd, dst, a, now))
+		delete(d.attacks, dst)
+	}
+
+	d.intervalStart = time.Time{}
+	d.destinations = make(map[string]*attackStats)
+	d.heaviest = newSpaceSaving(d.config.MaxDestinations)
+}
+
+func (d *AttackDetector) check(now time.Time, emit func(flowrecord.Event)) {
+	seconds := d.config.Interval.Seconds()
+
+	for dst, stats := range d.destinations {
+		rates := stats.rates(seconds)
+
+		a, attacked := d.attacks[dst]
+		if !d.exceeds(dst, rates) {
+			if !attacked {
+				d.learn(dst, rates, now)
+			}
+
+			continue
+		}
+
+		status := AttackStatusUpdate
+		if !attacked {
+			status = AttackStatusStart
+			a = &attack{
+				id:      fmt.Sprintf("%s-%d", dst, d.intervalStart.Unix()),
+				start:   d.intervalStart,
+				sources: newSpaceSaving(attackSourceCapacity),
+			}
+			d.attacks[dst] = a
+
+			log.Warnf("attack: Attack on %s at %.0f pps, %.0f bps", dst, rates.pps, rates.bps)
+		}
+
+		a.lastSeen = now
+		a.last = rates
+		a.vector = d.vector(rates)
+
+		if rates.pps > a.peakPPS {
+			a.peakPPS = rates.pps
+		}
+
+		if rates.bps > a.peakBPS {
+			a.peakBPS = rates.bps
+		}
+
+		for key, counter := range stats.sources.counters {
+			a.sources.add(key, counter.Bytes, counter.Packets)
+		}
+
+		emit(d.event(status, dst, a, now))
+	}
+
+	// Attacks under the thresholds for long enough are over
+	for dst, a := range d.attacks {
+		if now.Sub(a.lastSeen) >= d.config.Cooldown {
+			log.Infof("attack: Attack on %s ended", dst)
+
+			emit(d.event(AttackStatusEnd, dst, a, now))
+			delete(d.attacks, dst)
+		}
+	}
+
+	d.expire(now)
+
+	d.intervalStart = time.Time{}
+	d.destinations = make(map[string]*attackStats, len(d.destinations))
+	d.heaviest = newSpaceSaving(d.config.MaxDestinations)
+}
+
+func (s *attackStats) rates(seconds float64) attackRates {
+	rates := attackRates{
+		pps: float64(s.packets) / seconds,
+		bps: float64(s.bytes) * 8 / seconds,
+	}
+
+	if s.packets > 0 {
+		packets := float64(s.packets)
+		rates.synRatio = float64(s.synPackets) / packets
+		rates.udpRatio = float64(s.udpPackets) / packets
+		rates.icmpRatio = float64(s.icmpPackets) / packets
+		rates.amplificationRatio = float64(s.amplificationPackets) / packets
+	}
+
+	return rates
+}
+
+// exceeds returns true if the rates to a destination are over a threshold
+func (d *AttackDetector) exceeds(dst string, rates attackRates) bool {
+	if d.config.PPS > 0 && rates.pps >= float64(d.config.PPS) {
+		return true
+	}
+
+	if d.config.BPS > 0 && rates.bps >= float64(d.config.BPS) {
+		return true
+	}
+
+	if !d.config.Learn || rates.pps < float64(d.config.MinPPS) {
+		return false
+	}
+
+	baseline, ok := d.baselines[dst]
+	if !ok || baseline.intervals < attackBaselineWarmup {
+		return false
+	}
+
+	return rates.pps >= baseline.pps*d.config.LearnFactor
+}
+
+// learn folds an interval without an attack into the destination's baseline
+func (d *AttackDetector) learn(dst string, rates attackRates, now time.Time) {
+	if !d.config.Learn {
+		return
+	}
+
+	baseline, ok := d.baselines[dst]
+	if !ok {
+		if len(d.baselines) >= d.config.MaxDestinations {
+			return
+		}
+
+		d.baselines[dst] = &attackBaseline{pps: rates.pps, intervals: 1, updated: now}
+
+		return
+	}
+
+	baseline.pps += attackBaselineWeight * (rates.pps - baseline.pps)
+	baseline.intervals++
+	baseline.updated = now
+}
+
+// expire forgets the baselines of destinations that have not been learned
+// from for a while, unless they are under attack
+func (d *AttackDetector) expire(now time.Time) {
+	expiry := attackBaselineExpiry * d.config.Interval
+
+	for dst, baseline := range d.baselines {
+		if now.Sub(baseline.updated) > expiry && d.attacks[dst] == nil {
+			delete(d.baselines, dst)
+		}
+	}
+}
+
+// vector names the kind of traffic that makes up most of an attack
+func (d *AttackDetector) vector(rates attackRates) string {
+	ratio := d.config.VectorRatio
+
+	switch {
+	case rates.amplificationRatio >= ratio:
+		return AttackVectorAmplification
+	case rates.synRatio >= ratio:
+		return AttackVectorSYNFlood
+	case rates.udpRatio >= ratio:
+		return AttackVectorUDPFlood
+	case rates.icmpRatio >= ratio:
+		return AttackVectorICMPFlood
+	}
+
+	return AttackVectorVolumetric
+}
+
+func (d *AttackDetector) event(status string, dst string, a *attack, now time.Time) flowrecord.Attributes {
+	return flowrecord.Attributes{
+		"eventType":          d.config.EventType,
+		"timestamp":          now,
+		"attackId":           a.id,
+		"status":             status,
+		"destination":        dst,
+		"vector":             a.vector,
+		"startTime":          a.start.UnixNano() / int64(time.Millisecond),
+		"durationSeconds":    int64(now.Sub(a.start).Seconds()),
+		"pps":                int64(a.last.pps),
+		"bps":                int64(a.last.bps),
+		"peakPps":            int64(a.peakPPS),
+		"peakBps":            int64(a.peakBPS),
+		"synRatio":           a.last.synRatio,
+		"udpRatio":           a.last.udpRatio,
+		"icmpRatio":          a.last.icmpRatio,
+		"amplificationRatio": a.last.amplificationRatio,
+		"topSources":         strings.Join(a.topSources(d.config.TopSources), ","),
+	}
+}
+
+// topSources are the sources that sent the most bytes during the attack
+func (a *attack) topSources(limit int) []string {
+	entries := make([]*TopNEntry, 0, len(a.sources.counters))
+	for _, entry := range a.sources.counters {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Bytes == entries[j].Bytes {
+			return entries[i].Key < entries[j].Key
+		}
+
+		return entries[i].Bytes > entries[j].Bytes
+	})
+
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+
+	return keys
+}
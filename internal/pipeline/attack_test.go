@@ -0,0 +1,198 @@
+package pipeline
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestNewAttackDetector(t *testing.T) {
+	d, err := NewAttackDetector(AttackConfig{PPS: 1000}, []string{"sflow"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultAttackEventType, d.config.EventType)
+	assert.Equal(t, DefaultAttackInterval, d.config.Interval)
+	assert.Equal(t, DefaultAttackCooldown, d.config.Cooldown)
+	assert.True(t, d.amplificationPorts[53])
+
+	_, err = NewAttackDetector(AttackConfig{}, []string{"sflow"})
+	assert.Error(t, err)
+
+	_, err = NewAttackDetector(AttackConfig{PPS: 1000, Interval: time.Millisecond}, []string{"sflow"})
+	assert.Error(t, err)
+}
+
+func TestAttackDetector(t *testing.T) {
+	d, err := NewAttackDetector(AttackConfig{PPS: 1000, Cooldown: 20 * time.Second, TopSources: 2}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	var events []flowrecord.Attributes
+	emit := func(event flowrecord.Event) { events = append(events, event.(flowrecord.Attributes)) }
+
+	now := time.Unix(1600000000, 0)
+
+	// A SYN flood, 20000 pps from three sources, and a quiet neighbour
+	flood := func(now time.Time) {
+		for i, packets := range []uint64{10000, 6000, 4000} {
+			d.add(testFlow{src: fmt.Sprintf("10.0.0.%d", i+1), packets: packets, samplingRate: 10, flags: []string{"tcpFlagSYN"}}.record(), now)
+		}
+
+		d.add(testFlow{dst: "192.0.2.81", packets: 10, samplingRate: 10}.record(), now)
+	}
+
+	flood(now)
+	d.Tick(now.Add(5*time.Second), emit)
+	assert.Empty(t, events)
+
+	d.Tick(now.Add(10*time.Second), emit)
+	assert.Equal(t, 1, len(events))
+
+	event := events[0]
+	assert.Equal(t, DefaultAttackEventType, event["eventType"])
+	assert.Equal(t, AttackStatusStart, event["status"])
+	assert.Equal(t, "192.0.2.80", event["destination"])
+	assert.Equal(t, AttackVectorSYNFlood, event["vector"])
+	assert.Equal(t, int64(20000), event["pps"])
+	assert.Equal(t, int64(16000000), event["bps"])
+	assert.Equal(t, 1.0, event["synRatio"])
+	assert.Equal(t, "10.0.0.1,10.0.0.2", event["topSources"])
+
+	// Bigger the next interval
+	flood(now.Add(10 * time.Second))
+	flood(now.Add(10 * time.Second))
+	d.Tick(now.Add(20*time.Second), emit)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, AttackStatusUpdate, events[1]["status"])
+	assert.Equal(t, event["attackId"], events[1]["attackId"])
+	assert.Equal(t, int64(40000), events[1]["peakPps"])
+
+	// Over once it has been quiet for the cooldown
+	d.Tick(now.Add(30*time.Second), emit)
+	assert.Equal(t, 2, len(events))
+
+	d.Tick(now.Add(40*time.Second), emit)
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, AttackStatusEnd, events[2]["status"])
+	assert.Equal(t, int64(40000), events[2]["peakPps"])
+	assert.Empty(t, d.attacks)
+}
+
+func TestAttackDetectorMaxDestinations(t *testing.T) {
+	d, err := NewAttackDetector(AttackConfig{PPS: 1000, MaxDestinations: 10}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	var events []flowrecord.Attributes
+	emit := func(event flowrecord.Event) { events = append(events, event.(flowrecord.Attributes)) }
+
+	now := time.Unix(1600000000, 0)
+
+	// Many quiet destinations fill the limit before the attack starts
+	for i := 0; i < 100; i++ {
+		d.add(testFlow{dst: fmt.Sprintf("198.51.100.%d", i)}.record(), now)
+	}
+
+	for i := 0; i < 20; i++ {
+		d.add(testFlow{packets: 100, samplingRate: 10}.record(), now)
+		d.add(testFlow{dst: fmt.Sprintf("203.0.113.%d", i)}.record(), now)
+	}
+
+	assert.Equal(t, 10, len(d.destinations))
+
+	d.Tick(now.Add(10*time.Second), emit)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "192.0.2.80", events[0]["destination"])
+	assert.Equal(t, int64(2000), events[0]["pps"])
+}
+
+func TestAttackVector(t *testing.T) {
+	d, err := NewAttackDetector(AttackConfig{PPS: 1000}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		protocol uint8
+		srcPort  uint16
+		expected string
+	}{
+		{17, 53, AttackVectorAmplification},
+		{17, 40000, AttackVectorUDPFlood},
+		{1, 0, AttackVectorICMPFlood},
+		{6, 40000, AttackVectorVolumetric},
+	}
+
+	for _, test := range tests {
+		stats := &attackStats{sources: newSpaceSaving(attackSourceCapacity)}
+		d.destinations["192.0.2.80"] = stats
+		d.add(testFlow{protocol: test.protocol, srcPort: test.srcPort, packets: 100, samplingRate: 10}.record(), time.Now())
+
+		assert.Equal(t, test.expected, d.vector(stats.rates(10)))
+	}
+}
+
+func TestAttackLearn(t *testing.T) {
+	d, err := NewAttackDetector(AttackConfig{Learn: true, LearnFactor: 10, MinPPS: 100}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	var events []flowrecord.Event
+	emit := func(event flowrecord.Event) { events = append(events, event) }
+
+	now := time.Unix(1600000000, 0).Truncate(DefaultAttackInterval)
+
+	// 100 pps is usual for this destination
+	for i := 0; i < attackBaselineWarmup; i++ {
+		start := now.Add(time.Duration(i) * DefaultAttackInterval)
+		d.add(testFlow{protocol: 17, packets: 100, samplingRate: 10}.record(), start)
+		d.Tick(start.Add(DefaultAttackInterval), emit)
+	}
+
+	assert.Empty(t, events)
+	assert.InDelta(t, 100, d.baselines["192.0.2.80"].pps, 0.01)
+
+	// Five times is not enough, and is learned, twenty times is
+	start := now.Add(attackBaselineWarmup * DefaultAttackInterval)
+	d.add(testFlow{protocol: 17, packets: 500, samplingRate: 10}.record(), start)
+	d.Tick(start.Add(DefaultAttackInterval), emit)
+	assert.Empty(t, events)
+
+	start = start.Add(DefaultAttackInterval)
+	d.add(testFlow{protocol: 17, packets: 2000, samplingRate: 10}.record(), start)
+	d.Tick(start.Add(DefaultAttackInterval), emit)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, AttackVectorUDPFlood, events[0].Attributes()["vector"])
+
+	// Attacks in progress end when the pipeline stops
+	d.Flush(start.Add(DefaultAttackInterval), emit)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, AttackStatusEnd, events[1].Attributes()["status"])
+}
+
+func TestAttackBaselineExpiry(t *testing.T) {
+	d, err := NewAttackDetector(AttackConfig{Learn: true, MaxDestinations: 1}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	emit := func(flowrecord.Event) {}
+	now := time.Unix(1600000000, 0).Truncate(DefaultAttackInterval)
+
+	d.add(testFlow{protocol: 17, packets: 100, samplingRate: 10}.record(), now)
+	d.Tick(now.Add(DefaultAttackInterval), emit)
+	assert.Contains(t, d.baselines, "192.0.2.80")
+
+	// Only one destination fits while the first is still sending
+	now = now.Add(DefaultAttackInterval)
+	d.add(testFlow{dst: "192.0.2.81", protocol: 17, packets: 100, samplingRate: 10}.record(), now)
+	d.Tick(now.Add(DefaultAttackInterval), emit)
+	assert.NotContains(t, d.baselines, "192.0.2.81")
+
+	// Once the first has been quiet long enough, it makes room
+	now = now.Add(attackBaselineExpiry * DefaultAttackInterval)
+	d.add(testFlow{dst: "192.0.2.81", protocol: 17, packets: 100, samplingRate: 10}.record(), now)
+	d.Tick(now.Add(DefaultAttackInterval), emit)
+	assert.NotContains(t, d.baselines, "192.0.2.80")
+
+	now = now.Add(DefaultAttackInterval)
+	d.add(testFlow{dst: "192.0.2.81", protocol: 17, packets: 100, samplingRate: 10}.record(), now)
+	d.Tick(now.Add(DefaultAttackInterval), emit)
+	assert.Contains(t, d.baselines, "192.0.2.81")
+}
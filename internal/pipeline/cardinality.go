@@ -0,0 +1,319 @@
+package pipeline
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+const (
+	DefaultCardinalityEventType = "networkCardinality"
+	DefaultCardinalityInterval  = time.Minute
+	DefaultCardinalityMaxKeys   = 5000
+	DefaultCardinalityMinimum   = 10
+)
+
+// Dimensions counted, the distinct values seen per key
+const (
+	CardinalitySourcesPerDestination = "sourcesPerDestination"
+	CardinalityDestinationsPerSource = "destinationsPerSource"
+	CardinalityPortsPerSource        = "portsPerSource"
+)
+
+// Key for the flows of keys beyond the limit
+const cardinalityOverflow = "other"
+
+var CardinalityDimensions = []string{CardinalitySourcesPerDestination, CardinalityDestinationsPerSource, CardinalityPortsPerSource}
+
+type CardinalityConfig struct {
+	Enabled   bool          `envconfig:"CARDINALITY_ENABLED"`
+	Interval  time.Duration `envconfig:"CARDINALITY_INTERVAL"`
+	EventType string        `envconfig:"CARDINALITY_EVENT_TYPE"`
+	MaxKeys   int           `envconfig:"CARDINALITY_MAX_KEYS"`
+	Minimum   uint64        `envconfig:"CARDINALITY_MINIMUM"`
+}
+
+// CardinalityEntry is a key with the estimated number of distinct values seen with it
+type CardinalityEntry struct {
+	Key      string `json:"key"`
+	Estimate uint64 `json:"estimate"`
+	Flows    uint64 `json:"flows"`
+}
+
+// CardinalityResult is the keys of a dimension, highest estimate first
+type CardinalityResult struct {
+	Dimension     string             `json:"dimension"`
+	IntervalStart int64              `json:"intervalStart"`
+	IntervalEnd   int64              `json:"intervalEnd"`
+	Entries       []CardinalityEntry `json:"entries"`
+}
+
+/******************************************************************************
+ *
+ * Create a new Cardinality stage
+ *
+ ******************************************************************************/
+func NewCardinality(config CardinalityConfig, flowTypes []string) (*Cardinality, error) {
+	if config.Interval <= 0 {
+		config.Interval = DefaultCardinalityInterval
+	}
+
+	if config.Interval < tickInterval {
+		return nil, fmt.Errorf("cardinality interval %v is shorter than %v", config.Interval, tickInterval)
+	}
+
+	if config.EventType == "" {
+		config.EventType = DefaultCardinalityEventType
+	}
+
+	if config.MaxKeys <= 0 {
+		config.MaxKeys = DefaultCardinalityMaxKeys
+	}
+
+	log.Infof("cardinality: Counting up to %d keys per dimension every %v", config.MaxKeys, config.Interval)
+
+	c := &Cardinality{
+		config:    config,
+		flowTypes: newEventTypes(flowTypes),
+	}
+	c.reset()
+
+	return c, nil
+}
+
+/******************************************************************************
+ *
+ * Cardinality estimates how many distinct sources reach each destination, and
+ * how many destinations and ports each source reaches.  Every key costs a
+ * fixed size sketch, and there are at most MaxKeys per dimension: the flows
+ * of any more are counted together under "other".
+ *
+ ******************************************************************************/
+type Cardinality struct {
+	config    CardinalityConfig
+	flowTypes eventTypes
+
+	lock          sync.RWMutex
+	intervalStart time.Time
+	keys          map[string]map[string]*cardinalityKey
+	last          map[string]CardinalityResult
+}
+
+type cardinalityKey struct {
+	sketch hyperLogLog
+	flows  uint64
+}
+
+func (c *Cardinality) Name() string {
+	return "cardinality"
+}
+
+func (c *Cardinality) Process(event flowrecord.Event, emit func(flowrecord.Event)) {
+	if rec, ok := c.flowTypes.counted(event); ok {
+		c.add(rec, time.Now())
+	}
+
+	emit(event)
+}
+
+// add counts the values of a flow against its keys
+func (c *Cardinality) add(rec *flowrecord.FlowRecord, now time.Time) {
+	if rec.SourceAddress == nil || rec.DestinationAddress == nil {
+		return
+	}
+
+	src := rec.SourceAddress.String()
+	dst := rec.DestinationAddress.String()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.intervalStart.IsZero() {
+		c.intervalStart = now.Truncate(c.config.Interval)
+	}
+
+	c.count(CardinalitySourcesPerDestination, dst, src)
+	c.count(CardinalityDestinationsPerSource, src, dst)
+
+	if rec.HasPorts() {
+		c.count(CardinalityPortsPerSource, src, fmt.Sprintf("%d/%d", rec.Protocol, rec.DestinationPort))
+	}
+}
+
+func (c *Cardinality) count(dimension string, key string, value string) {
+	keys := c.keys[dimension]
+
+	k, ok := keys[key]
+	if !ok {
+		if len(keys) >= c.config.MaxKeys {
+			key = cardinalityOverflow
+		}
+
+		if k, ok = keys[key]; !ok {
+			k = &cardinalityKey{}
+			keys[key] = k
+		}
+	}
+
+	k.sketch.add(value)
+	k.flows++
+}
+
+/******************************************************************************
+ *
+ * At the end of every interval emit the keys over the minimum, keep the
+ * estimates for queries, and start counting again
+ *
+ ******************************************************************************/
+func (c *Cardinality) Tick(now time.Time, emit func(flowrecord.Event)) {
+	c.lock.RLock()
+	closed := !c.intervalStart.IsZero() && !now.Before(c.intervalStart.Add(c.config.Interval))
+	c.lock.RUnlock()
+
+	if closed {
+		c.Flush(now, emit)
+	}
+}
+
+func (c *Cardinality) Flush(now time.Time, emit func(flowrecord.Event)) {
+	c.lock.Lock()
+
+	if c.intervalStart.IsZero() {
+		c.lock.Unlock()
+		return
+	}
+
+	intervalEnd := c.intervalStart.Add(c.config.Interval)
+	if now.Before(intervalEnd) {
+		intervalEnd = now
+	}
+
+	last := make(map[string]CardinalityResult, len(c.keys))
+	for _, dimension := range CardinalityDimensions {
+		last[dimension] = c.result(dimension, intervalEnd)
+	}
+
+	intervalStart := c.intervalStart
+	c.last = last
+	c.reset()
+	c.lock.Unlock()
+
+	for _, dimension := range CardinalityDimensions {
+		for _, entry := range last[dimension].Entries {
+			if entry.Estimate < c.config.Minimum {
+				break
+			}
+
+			emit(flowrecord.Attributes{
+				"eventType":       c.config.EventType,
+				"timestamp":       intervalStart,
+				"intervalStart":   intervalStart.UnixNano() / int64(time.Millisecond),
+				"intervalEnd":     intervalEnd.UnixNano() / int64(time.Millisecond),
+				"intervalSeconds": int64(c.config.Interval.Seconds()),
+				"dimension":       dimension,
+				"key":             entry.Key,
+				"estimate":        int64(entry.Estimate),
+				"flows":           int64(entry.Flows),
+			})
+		}
+	}
+}
+
+func (c *Cardinality) reset() {
+	c.intervalStart = time.Time{}
+	c.keys = make(map[string]map[string]*cardinalityKey, len(CardinalityDimensions))
+
+	for _, dimension := range CardinalityDimensions {
+		c.keys[dimension] = make(map[string]*cardinalityKey)
+	}
+}
+
+// result estimates every key of a dimension in the current interval
+func (c *Cardinality) result(dimension string, intervalEnd time.Time) CardinalityResult {
+	keys := c.keys[dimension]
+	entries := make([]CardinalityEntry, 0, len(keys))
+
+	for key, k := range keys {
+		entries = append(entries, CardinalityEntry{Key: key, Estimate: k.sketch.estimate(), Flows: k.flows})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Estimate == entries[j].Estimate {
+			return entries[i].Key < entries[j].Key
+		}
+
+		return entries[i].Estimate > entries[j].Estimate
+	})
+
+	result := CardinalityResult{
+		Dimension: dimension,
+		Entries:   entries,
+	}
+
+	// Nothing has been counted yet
+	if !c.intervalStart.IsZero() {
+		result.IntervalStart = c.intervalStart.UnixNano() / int64(time.Millisecond)
+		result.IntervalEnd = intervalEnd.UnixNano() / int64(time.Millisecond)
+	}
+
+	return result
+}
+
+/******************************************************************************
+ *
+ * Query the last complete interval, or the one in progress.  A key returns
+ * just that key, otherwise up to limit keys of at least minimum.
+ *
+ ******************************************************************************/
+func (c *Cardinality) Query(dimension string, current bool, key string, minimum uint64, limit int) (CardinalityResult, error) {
+	if !isCardinalityDimension(dimension) {
+		return CardinalityResult{}, fmt.Errorf("unknown dimension '%s'", dimension)
+	}
+
+	var result CardinalityResult
+
+	if current {
+		c.lock.RLock()
+		result = c.result(dimension, time.Now())
+		c.lock.RUnlock()
+	} else {
+		c.lock.RLock()
+		result = c.last[dimension]
+		c.lock.RUnlock()
+
+		result.Dimension = dimension
+	}
+
+	entries := make([]CardinalityEntry, 0, len(result.Entries))
+
+	for _, entry := range result.Entries {
+		if (key != "" && entry.Key != key) || entry.Estimate < minimum {
+			continue
+		}
+
+		entries = append(entries, entry)
+
+		if limit > 0 && len(entries) == limit {
+			break
+		}
+	}
+
+	result.Entries = entries
+
+	return result, nil
+}
+
+func isCardinalityDimension(dimension string) bool {
+	for _, d := range CardinalityDimensions {
+		if d == dimension {
+			return true
+		}
+	}
+
+	return false
+}
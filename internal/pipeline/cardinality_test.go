@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestNewCardinality(t *testing.T) {
+	c, err := NewCardinality(CardinalityConfig{}, []string{"sflow"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultCardinalityInterval, c.config.Interval)
+	assert.Equal(t, DefaultCardinalityEventType, c.config.EventType)
+	assert.Equal(t, DefaultCardinalityMaxKeys, c.config.MaxKeys)
+
+	_, err = NewCardinality(CardinalityConfig{Interval: time.Millisecond}, []string{"sflow"})
+	assert.Error(t, err)
+}
+
+func TestCardinality(t *testing.T) {
+	c, err := NewCardinality(CardinalityConfig{Interval: time.Minute, MaxKeys: 2, Minimum: 5}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	now := time.Unix(1600000000, 0).Truncate(time.Minute)
+
+	// A fan-in to one destination, and a scan from one source
+	for i := 0; i < 50; i++ {
+		c.add(testFlow{src: fmt.Sprintf("10.1.0.%d", i), dst: "10.0.0.1", dstPort: 443}.record(), now)
+		c.add(testFlow{src: "10.2.0.1", dst: "10.0.0.2", dstPort: uint16(1000 + i)}.record(), now)
+	}
+
+	// Keys over the limit are counted as one
+	c.add(testFlow{src: "10.3.0.1", dst: "10.0.0.3", dstPort: 22}.record(), now)
+	c.add(testFlow{src: "10.3.0.2", dst: "10.0.0.4", dstPort: 22}.record(), now)
+
+	result, err := c.Query(CardinalitySourcesPerDestination, true, "", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(result.Entries))
+	assert.Equal(t, "10.0.0.1", result.Entries[0].Key)
+	assert.InDelta(t, 50, float64(result.Entries[0].Estimate), 2)
+	assert.Equal(t, uint64(50), result.Entries[0].Flows)
+	assert.Equal(t, CardinalityEntry{Key: cardinalityOverflow, Estimate: 2, Flows: 2}, result.Entries[1])
+
+	result, err = c.Query(CardinalityPortsPerSource, true, "10.2.0.1", 0, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result.Entries))
+	assert.InDelta(t, 50, float64(result.Entries[0].Estimate), 2)
+
+	// Nothing has closed yet
+	result, err = c.Query(CardinalityDestinationsPerSource, false, "", 0, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Entries)
+
+	var events []flowrecord.Event
+	emit := func(event flowrecord.Event) { events = append(events, event) }
+
+	c.Tick(now.Add(30*time.Second), emit)
+	assert.Empty(t, events)
+
+	c.Tick(now.Add(time.Minute), emit)
+
+	// Only the keys at or over the minimum
+	assert.Equal(t, 2, len(events))
+
+	event := events[0].Attributes()
+	assert.Equal(t, DefaultCardinalityEventType, event["eventType"])
+	assert.Equal(t, CardinalitySourcesPerDestination, event["dimension"])
+	assert.Equal(t, "10.0.0.1", event["key"])
+	assert.InDelta(t, 50, event["estimate"], 2)
+	assert.Equal(t, int64(60), event["intervalSeconds"])
+	assert.Equal(t, now, event["timestamp"])
+	assert.Equal(t, CardinalityPortsPerSource, events[1].Attributes()["dimension"])
+
+	// The closed interval is kept for queries, and counting starts over
+	result, err = c.Query(CardinalityPortsPerSource, false, "", 10, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(result.Entries))
+	assert.Equal(t, "10.2.0.1", result.Entries[0].Key)
+	assert.Equal(t, now.Add(time.Minute).UnixNano()/int64(time.Millisecond), result.IntervalEnd)
+
+	result, err = c.Query(CardinalityDestinationsPerSource, true, "", 0, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Entries)
+	assert.Equal(t, int64(0), result.IntervalStart)
+
+	_, err = c.Query("bogus", false, "", 0, 0)
+	assert.Error(t, err)
+}
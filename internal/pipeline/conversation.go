@@ -0,0 +1,328 @@
+package pipeline
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/util"
+)
+
+const (
+	DefaultConversationEventType = "networkConversation"
+	DefaultConversationWindow    = time.Minute
+	DefaultConversationMax       = 100000
+)
+
+// How the client of a conversation was told from the server
+const (
+	conversationRoleSYN  = "tcpSyn"
+	conversationRolePort = "port"
+)
+
+type ConversationConfig struct {
+	Enabled   bool          `envconfig:"CONVERSATION_ENABLED"`
+	EventType string        `envconfig:"CONVERSATION_EVENT_TYPE"`
+	Window    time.Duration `envconfig:"CONVERSATION_WINDOW"`
+	Max       int           `envconfig:"CONVERSATION_MAX"`
+}
+
+/******************************************************************************
+ *
+ * Create a new ConversationStitcher
+ *
+ ******************************************************************************/
+func NewConversationStitcher(config ConversationConfig, flowTypes []string) (*ConversationStitcher, error) {
+	if config.EventType == "" {
+		config.EventType = DefaultConversationEventType
+	}
+
+	if config.Window <= 0 {
+		config.Window = DefaultConversationWindow
+	}
+
+	if config.Window < tickInterval {
+		return nil, fmt.Errorf("conversation window %v is shorter than %v", config.Window, tickInterval)
+	}
+
+	if config.Max <= 0 {
+		config.Max = DefaultConversationMax
+	}
+
+	log.Infof("conversation: Stitching up to %d conversations every %v", config.Max, config.Window)
+
+	return &ConversationStitcher{
+		config:        config,
+		flowTypes:     newEventTypes(flowTypes),
+		conversations: make(map[string]*conversation),
+	}, nil
+}
+
+/******************************************************************************
+ *
+ * ConversationStitcher puts both directions of a conversation back together
+ * over a window, by the direction-agnostic combinedHash of its endpoints.
+ * When the window closes every conversation is emitted with its client and
+ * server, what each sent, and whether the return traffic was missing or took
+ * another path.
+ *
+ ******************************************************************************/
+type ConversationStitcher struct {
+	config    ConversationConfig
+	flowTypes eventTypes
+
+	windowStart   time.Time
+	conversations map[string]*conversation
+	overflow      bool
+}
+
+// conversation is a pair of endpoints, a is the source of the first flow seen
+type conversation struct {
+	hash     uint64
+	protocol uint8
+	hasPorts bool
+	a        conversationEndpoint
+	b        conversationEndpoint
+}
+
+// conversationEndpoint is one side of a conversation and what it sent
+type conversationEndpoint struct {
+	address net.IP
+	port    uint16
+	bytes   uint64
+	packets uint64
+	flows   uint64
+	syn     bool
+
+	// Exporters and interfaces the traffic from this side was seen on
+	paths map[conversationPath]bool
+}
+
+type conversationPath struct {
+	agent string
+	in    uint32
+	out   uint32
+}
+
+func (s *ConversationStitcher) Name() string {
+	return "conversation"
+}
+
+func (s *ConversationStitcher) Process(event flowrecord.Event, emit func(flowrecord.Event)) {
+	if rec, ok := s.flowTypes.counted(event); ok {
+		s.add(rec, time.Now())
+	}
+
+	emit(event)
+}
+
+// add counts a flow against the direction of its conversation it belongs to
+func (s *ConversationStitcher) add(rec *flowrecord.FlowRecord, now time.Time) {
+	if rec.SourceAddress == nil || rec.DestinationAddress == nil {
+		return
+	}
+
+	if s.windowStart.IsZero() {
+		s.windowStart = now.Truncate(s.config.Window)
+	}
+
+	hash := conversationHash(rec)
+	key := fmt.Sprintf("%d/%d", rec.Protocol, hash)
+
+	c, ok := s.conversations[key]
+	if !ok {
+		if len(s.conversations) >= s.config.Max {
+			if !s.overflow {
+				log.Warnf("conversation: More than %d conversations in a window, ignoring the rest", s.config.Max)
+				s.overflow = true
+			}
+
+			return
+		}
+
+		c = &conversation{
+			hash:     hash,
+			protocol: rec.Protocol,
+			hasPorts: rec.HasPorts(),
+			a:        conversationEndpoint{address: rec.SourceAddress, port: rec.SourcePort},
+			b:        conversationEndpoint{address: rec.DestinationAddress, port: rec.DestinationPort},
+		}
+		s.conversations[key] = c
+	}
+
+	var from *conversationEndpoint
+
+	switch {
+	case c.a.is(rec.SourceAddress, rec.SourcePort) && c.b.is(rec.DestinationAddress, rec.DestinationPort):
+		from = &c.a
+	case c.b.is(rec.SourceAddress, rec.SourcePort) && c.a.is(rec.DestinationAddress, rec.DestinationPort):
+		from = &c.b
+	default:
+		// Another pair of endpoints with the same hash
+		return
+	}
+
+	from.bytes += rec.ScaledBytes()
+	from.packets += rec.ScaledPackets()
+	from.flows++
+
+	if isTCPFlag(rec, "tcpFlagSYN") && !isTCPFlag(rec, "tcpFlagACK") {
+		from.syn = true
+	}
+
+	if from.paths == nil {
+		from.paths = make(map[conversationPath]bool)
+	}
+
+	from.paths[conversationPath{agent: rec.Agent, in: rec.InputInterface, out: rec.OutputInterface}] = true
+}
+
+// conversationHash is the combinedHash sFlow records carry, or the same hash
+// made from the record's endpoints
+func conversationHash(rec *flowrecord.FlowRecord) uint64 {
+	if value, ok := rec.Get("combinedHash"); ok {
+		if hash, err := strconv.ParseUint(fmt.Sprint(value), 10, 64); err == nil {
+			return hash
+		}
+	}
+
+	return util.EndpointsHash(rec.SourceAddress, rec.SourcePort, rec.DestinationAddress, rec.DestinationPort)
+}
+
+func (e *conversationEndpoint) is(address net.IP, port uint16) bool {
+	return e.port == port && e.address.Equal(address)
+}
+
+/******************************************************************************
+ *
+ * Emit the conversations when the window closes
+ *
+ ******************************************************************************/
+func (s *ConversationStitcher) Tick(now time.Time, emit func(flowrecord.Event)) {
+	if s.windowStart.IsZero() || now.Before(s.windowStart.Add(s.config.Window)) {
+		return
+	}
+
+	s.Flush(now, emit)
+}
+
+func (s *ConversationStitcher) Flush(now time.Time, emit func(flowrecord.Event)) {
+	if s.windowStart.IsZero() {
+		return
+	}
+
+	windowEnd := s.windowStart.Add(s.config.Window)
+	if now.Before(windowEnd) {
+		windowEnd = now
+	}
+
+	for _, c := range s.conversations {
+		emit(s.event(c, windowEnd))
+	}
+
+	s.windowStart = time.Time{}
+	s.conversations = make(map[string]*conversation, len(s.conversations))
+	s.overflow = false
+}
+
+func (s *ConversationStitcher) event(c *conversation, windowEnd time.Time) flowrecord.Attributes {
+	client, server, role := c.roles()
+
+	event := flowrecord.Attributes{
+		"eventType":      s.config.EventType,
+		"timestamp":      s.windowStart,
+		"windowStart":    s.windowStart.UnixNano() / int64(time.Millisecond),
+		"windowEnd":      windowEnd.UnixNano() / int64(time.Millisecond),
+		"windowSeconds":  int64(s.config.Window.Seconds()),
+		"combinedHash":   util.Uint64ToS(c.hash),
+		"protocol":       int64(c.protocol),
+		"clientAddress":  client.address.String(),
+		"serverAddress":  server.address.String(),
+		"roleFrom":       role,
+		"clientBytes":    int64(client.bytes),
+		"serverBytes":    int64(server.bytes),
+		"clientPackets":  int64(client.packets),
+		"serverPackets":  int64(server.packets),
+		"flows":          int64(client.flows + server.flows),
+		"oneWay":         client.flows == 0 || server.flows == 0,
+		"asymmetricPath": c.asymmetric(),
+		"exporters":      strings.Join(c.agents(), ","),
+	}
+
+	if c.hasPorts {
+		event["clientPort"] = int64(client.port)
+		event["serverPort"] = int64(server.port)
+	}
+
+	return event
+}
+
+// roles tells the client from the server: the side that sent a SYN without
+// an ACK opened the conversation, otherwise the server has the lower port
+func (c *conversation) roles() (client *conversationEndpoint, server *conversationEndpoint, role string) {
+	switch {
+	case c.a.syn != c.b.syn:
+		if c.b.syn {
+			return &c.b, &c.a, conversationRoleSYN
+		}
+
+		return &c.a, &c.b, conversationRoleSYN
+	case c.hasPorts && c.a.port < c.b.port:
+		return &c.b, &c.a, conversationRolePort
+	}
+
+	return &c.a, &c.b, conversationRolePort
+}
+
+// asymmetric returns true if the two directions were seen on different
+// exporters, or on the same exporter but not through the same interfaces
+func (c *conversation) asymmetric() bool {
+	if c.a.flows == 0 || c.b.flows == 0 {
+		return false
+	}
+
+	for forward := range c.a.paths {
+		for reverse := range c.b.paths {
+			if forward.agent != reverse.agent {
+				continue
+			}
+
+			// Interfaces the exporter does not know match anything
+			if forward.in == 0 || forward.out == 0 || reverse.in == 0 || reverse.out == 0 {
+				return false
+			}
+
+			if forward.in == reverse.out && forward.out == reverse.in {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// agents are the exporters that saw the conversation
+func (c *conversation) agents() []string {
+	set := make(map[string]bool)
+
+	for _, paths := range []map[conversationPath]bool{c.a.paths, c.b.paths} {
+		for path := range paths {
+			set[path.agent] = true
+		}
+	}
+
+	agents := make([]string, 0, len(set))
+	for agent := range set {
+		agents = append(agents, agent)
+	}
+
+	sort.Strings(agents)
+
+	return agents
+}
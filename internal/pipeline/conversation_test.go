@@ -0,0 +1,113 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/util"
+)
+
+func TestNewConversationStitcher(t *testing.T) {
+	s, err := NewConversationStitcher(ConversationConfig{}, []string{"sflow"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultConversationEventType, s.config.EventType)
+	assert.Equal(t, DefaultConversationWindow, s.config.Window)
+	assert.Equal(t, DefaultConversationMax, s.config.Max)
+
+	_, err = NewConversationStitcher(ConversationConfig{Window: time.Millisecond}, []string{"sflow"})
+	assert.Error(t, err)
+}
+
+func TestConversationStitcher(t *testing.T) {
+	s, err := NewConversationStitcher(ConversationConfig{}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	now := time.Unix(1600000000, 0).Truncate(time.Minute)
+
+	// The server's reply is seen first, both directions through the same interfaces
+	reply := testFlow{agent: "192.0.2.1", src: "10.0.0.2", srcPort: 443, dst: "10.0.0.1", dstPort: 40000, in: 2, out: 1, samplingRate: 10}.record()
+	reply.Bytes = 1000
+	s.add(reply, now)
+
+	request := testFlow{agent: "192.0.2.1", src: "10.0.0.1", srcPort: 40000, dst: "10.0.0.2", dstPort: 443, in: 1, out: 2, samplingRate: 10}.record()
+	request.Set("combinedHash", util.Uint64ToS(util.EndpointsHash(request.SourceAddress, 40000, request.DestinationAddress, 443)))
+	s.add(request, now)
+
+	// Return traffic via another exporter
+	s.add(testFlow{agent: "192.0.2.1", src: "10.0.0.3", srcPort: 40001, dst: "10.0.0.4", dstPort: 22, in: 1, out: 2, samplingRate: 10}.record(), now)
+	s.add(testFlow{agent: "192.0.2.2", src: "10.0.0.4", srcPort: 22, dst: "10.0.0.3", dstPort: 40001, in: 5, out: 6, samplingRate: 10}.record(), now)
+
+	// Only one direction, the client opened it
+	syn := testFlow{agent: "192.0.2.1", src: "10.0.0.5", srcPort: 53000, dst: "10.0.0.6", dstPort: 50000, in: 1, out: 2, samplingRate: 10}.record()
+	syn.Set("tcpFlagSYN", true)
+	syn.Set("tcpFlagACK", false)
+	s.add(syn, now)
+
+	assert.Equal(t, 3, len(s.conversations))
+
+	var events []flowrecord.Attributes
+	emit := func(event flowrecord.Event) { events = append(events, event.(flowrecord.Attributes)) }
+
+	s.Tick(now.Add(30*time.Second), emit)
+	assert.Empty(t, events)
+
+	s.Tick(now.Add(time.Minute), emit)
+	assert.Equal(t, 3, len(events))
+
+	conversations := make(map[string]flowrecord.Attributes)
+	for _, event := range events {
+		conversations[event["clientAddress"].(string)] = event
+	}
+
+	https := conversations["10.0.0.1"]
+	assert.Equal(t, DefaultConversationEventType, https["eventType"])
+	assert.Equal(t, "10.0.0.2", https["serverAddress"])
+	assert.Equal(t, int64(40000), https["clientPort"])
+	assert.Equal(t, int64(443), https["serverPort"])
+	assert.Equal(t, conversationRolePort, https["roleFrom"])
+	assert.Equal(t, int64(1000), https["clientBytes"])
+	assert.Equal(t, int64(10000), https["serverBytes"])
+	assert.Equal(t, int64(2), https["flows"])
+	assert.Equal(t, false, https["oneWay"])
+	assert.Equal(t, false, https["asymmetricPath"])
+	assert.Equal(t, "192.0.2.1", https["exporters"])
+	assert.Equal(t, now, https["timestamp"])
+
+	ssh := conversations["10.0.0.3"]
+	assert.Equal(t, true, ssh["asymmetricPath"])
+	assert.Equal(t, "192.0.2.1,192.0.2.2", ssh["exporters"])
+
+	// The SYN decides the roles, whatever the ports
+	oneWay := conversations["10.0.0.5"]
+	assert.Equal(t, conversationRoleSYN, oneWay["roleFrom"])
+	assert.Equal(t, true, oneWay["oneWay"])
+	assert.Equal(t, false, oneWay["asymmetricPath"])
+	assert.Equal(t, int64(0), oneWay["serverBytes"])
+
+	assert.Empty(t, s.conversations)
+}
+
+func TestConversationAsymmetric(t *testing.T) {
+	tests := []struct {
+		forward  conversationPath
+		reverse  conversationPath
+		expected bool
+	}{
+		{conversationPath{"a", 1, 2}, conversationPath{"a", 2, 1}, false},
+		{conversationPath{"a", 1, 2}, conversationPath{"a", 3, 1}, true},
+		{conversationPath{"a", 1, 2}, conversationPath{"b", 2, 1}, true},
+		{conversationPath{"a", 0, 0}, conversationPath{"a", 3, 4}, false},
+	}
+
+	for _, test := range tests {
+		c := conversation{
+			a: conversationEndpoint{flows: 1, paths: map[conversationPath]bool{test.forward: true}},
+			b: conversationEndpoint{flows: 1, paths: map[conversationPath]bool{test.reverse: true}},
+		}
+
+		assert.Equal(t, test.expected, c.asymmetric(), "%v %v", test.forward, test.reverse)
+	}
+}
@@ -0,0 +1,321 @@
+package pipeline
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+const (
+	DefaultDedupEventType      = "networkDedup"
+	DefaultDedupMode           = DedupModeWindow
+	DefaultDedupAction         = DedupActionMark
+	DefaultDedupWindow         = 30 * time.Second
+	DefaultDedupMaxKeys        = 500000
+	DefaultDedupReportInterval = time.Minute
+)
+
+// How duplicates are found
+const (
+	DedupModeWindow     = "window"     // The same 5-tuple from another exporter within the window
+	DedupModeAccounting = "accounting" // Anything not entering an accounting point
+)
+
+// What is done with duplicates
+const (
+	DedupActionMark = "mark"
+	DedupActionDrop = "drop"
+)
+
+// Interface of an accounting point that matches them all
+const dedupAnyInterface = "*"
+
+type DedupConfig struct {
+	Enabled   bool   `envconfig:"DEDUP_ENABLED"`
+	EventType string `envconfig:"DEDUP_EVENT_TYPE"`
+	Mode      string `envconfig:"DEDUP_MODE"`
+	Action    string `envconfig:"DEDUP_ACTION"`
+
+	// Exporter and input interface pairs, "agent:ifIndex" or "agent:*"
+	AccountingPoints []string `envconfig:"DEDUP_ACCOUNTING_POINTS"`
+
+	Window         time.Duration `envconfig:"DEDUP_WINDOW"`
+	MaxKeys        int           `envconfig:"DEDUP_MAX_KEYS"`
+	ReportInterval time.Duration `envconfig:"DEDUP_REPORT_INTERVAL"`
+}
+
+/******************************************************************************
+ *
+ * Create a new Deduplicator
+ *
+ ******************************************************************************/
+func NewDeduplicator(config DedupConfig, flowTypes []string) (*Deduplicator, error) {
+	if config.EventType == "" {
+		config.EventType = DefaultDedupEventType
+	}
+
+	if config.Mode == "" {
+		config.Mode = DefaultDedupMode
+	}
+
+	if config.Action == "" {
+		config.Action = DefaultDedupAction
+	}
+
+	if config.Window <= 0 {
+		config.Window = DefaultDedupWindow
+	}
+
+	if config.MaxKeys <= 0 {
+		config.MaxKeys = DefaultDedupMaxKeys
+	}
+
+	if config.ReportInterval <= 0 {
+		config.ReportInterval = DefaultDedupReportInterval
+	}
+
+	if config.ReportInterval < tickInterval {
+		return nil, fmt.Errorf("dedup report interval %v is shorter than %v", config.ReportInterval, tickInterval)
+	}
+
+	if config.Action != DedupActionMark && config.Action != DedupActionDrop {
+		return nil, fmt.Errorf("unknown dedup action '%s'", config.Action)
+	}
+
+	d := &Deduplicator{
+		config:    config,
+		flowTypes: newEventTypes(flowTypes),
+		counts:    make(map[string]*dedupCounts),
+	}
+
+	switch config.Mode {
+	case DedupModeWindow:
+		d.current = make(map[string]dedupOwner)
+		d.previous = make(map[string]dedupOwner)
+	case DedupModeAccounting:
+		points, err := parseAccountingPoints(config.AccountingPoints)
+		if err != nil {
+			return nil, err
+		}
+
+		d.points = points
+	default:
+		return nil, fmt.Errorf("unknown dedup mode '%s'", config.Mode)
+	}
+
+	log.Infof("dedup: Finding duplicates by %s, and will %s them", config.Mode, config.Action)
+
+	return d, nil
+}
+
+// parseAccountingPoints returns the input interfaces of each exporter, with
+// dedupAnyInterface for all of them
+func parseAccountingPoints(values []string) (map[string]map[string]bool, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("no dedup accounting points")
+	}
+
+	points := make(map[string]map[string]bool)
+
+	for _, value := range values {
+		i := strings.LastIndex(value, ":")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid dedup accounting point '%s', expected agent:ifIndex", value)
+		}
+
+		agent, ifIndex := value[:i], value[i+1:]
+		if ifIndex != dedupAnyInterface {
+			if _, err := strconv.ParseUint(ifIndex, 10, 32); err != nil {
+				return nil, fmt.Errorf("invalid dedup accounting point '%s', expected agent:ifIndex", value)
+			}
+		}
+
+		if points[agent] == nil {
+			points[agent] = make(map[string]bool)
+		}
+
+		points[agent][ifIndex] = true
+	}
+
+	return points, nil
+}
+
+/******************************************************************************
+ *
+ * Deduplicator finds flows that were already reported by another exporter,
+ * and marks them with a duplicate attribute or drops them.  How many flows
+ * and duplicates each exporter sent is emitted every report interval.
+ *
+ ******************************************************************************/
+type Deduplicator struct {
+	config    DedupConfig
+	flowTypes eventTypes
+
+	// Accounting points, by exporter then input interface
+	points map[string]map[string]bool
+
+	// Exporter that owns each 5-tuple, in this window and the last
+	windowStart time.Time
+	current     map[string]dedupOwner
+	previous    map[string]dedupOwner
+	overflow    bool
+
+	reportStart time.Time
+	counts      map[string]*dedupCounts
+}
+
+type dedupOwner struct {
+	agent    string
+	reported time.Time // When the owner last reported the 5-tuple
+}
+
+type dedupCounts struct {
+	flows      uint64
+	duplicates uint64
+}
+
+func (d *Deduplicator) Name() string {
+	return "dedup"
+}
+
+func (d *Deduplicator) Process(event flowrecord.Event, emit func(flowrecord.Event)) {
+	rec, ok := d.flowTypes.flow(event)
+	if !ok {
+		emit(event)
+		return
+	}
+
+	if !d.duplicate(rec, time.Now()) {
+		emit(event)
+		return
+	}
+
+	// The flow handler may still be reading the record, so the mark goes on
+	// a copy of it
+	if d.config.Action == DedupActionMark {
+		marked := rec.Clone()
+		marked.Enrich("duplicate", true)
+		emit(marked)
+	}
+}
+
+// duplicate returns true if the flow was already reported, and counts it
+func (d *Deduplicator) duplicate(rec *flowrecord.FlowRecord, now time.Time) bool {
+	if d.reportStart.IsZero() {
+		d.reportStart = now.Truncate(d.config.ReportInterval)
+	}
+
+	counts, ok := d.counts[rec.Agent]
+	if !ok {
+		counts = &dedupCounts{}
+		d.counts[rec.Agent] = counts
+	}
+
+	counts.flows++
+
+	var duplicate bool
+	if d.config.Mode == DedupModeAccounting {
+		duplicate = !d.accounted(rec)
+	} else {
+		duplicate = d.seen(rec, now)
+	}
+
+	if duplicate {
+		counts.duplicates++
+	}
+
+	return duplicate
+}
+
+// accounted returns true if the flow entered the network at an accounting point
+func (d *Deduplicator) accounted(rec *flowrecord.FlowRecord) bool {
+	interfaces := d.points[rec.Agent]
+
+	return interfaces[dedupAnyInterface] || interfaces[strconv.FormatUint(uint64(rec.InputInterface), 10)]
+}
+
+// seen returns true if another exporter owns the flow's 5-tuple.  The first
+// exporter to report it owns it until it has not reported it for a window,
+// then the next exporter that does takes it over.
+func (d *Deduplicator) seen(rec *flowrecord.FlowRecord, now time.Time) bool {
+	if d.windowStart.IsZero() {
+		d.windowStart = now
+	} else if now.Sub(d.windowStart) >= d.config.Window {
+		d.previous = d.current
+		d.current = make(map[string]dedupOwner, len(d.previous))
+		d.windowStart = now
+		d.overflow = false
+	}
+
+	key := fmt.Sprintf("%d/%s/%d/%s/%d", rec.Protocol, rec.SourceAddress, rec.SourcePort, rec.DestinationAddress, rec.DestinationPort)
+
+	owner, ok := d.current[key]
+	if !ok {
+		owner, ok = d.previous[key]
+	}
+
+	// Other exporters do not keep the owner alive
+	if ok && owner.agent != rec.Agent && now.Sub(owner.reported) <= d.config.Window {
+		return true
+	}
+
+	if _, ok := d.current[key]; !ok && len(d.current) >= d.config.MaxKeys {
+		if !d.overflow {
+			log.Warnf("dedup: More than %d flows in a window, not checking the rest", d.config.MaxKeys)
+			d.overflow = true
+		}
+
+		return false
+	}
+
+	d.current[key] = dedupOwner{agent: rec.Agent, reported: now}
+
+	return false
+}
+
+/******************************************************************************
+ *
+ * Report the counts of every exporter
+ *
+ ******************************************************************************/
+func (d *Deduplicator) Tick(now time.Time, emit func(flowrecord.Event)) {
+	if d.reportStart.IsZero() || now.Before(d.reportStart.Add(d.config.ReportInterval)) {
+		return
+	}
+
+	d.Flush(now, emit)
+}
+
+func (d *Deduplicator) Flush(now time.Time, emit func(flowrecord.Event)) {
+	if d.reportStart.IsZero() {
+		return
+	}
+
+	reportEnd := d.reportStart.Add(d.config.ReportInterval)
+	if now.Before(reportEnd) {
+		reportEnd = now
+	}
+
+	for agent, counts := range d.counts {
+		emit(flowrecord.Attributes{
+			"eventType":     d.config.EventType,
+			"timestamp":     d.reportStart,
+			"windowStart":   d.reportStart.UnixNano() / int64(time.Millisecond),
+			"windowEnd":     reportEnd.UnixNano() / int64(time.Millisecond),
+			"windowSeconds": int64(d.config.ReportInterval.Seconds()),
+			"agent":         agent,
+			"mode":          d.config.Mode,
+			"action":        d.config.Action,
+			"flows":         int64(counts.flows),
+			"duplicates":    int64(counts.duplicates),
+		})
+	}
+
+	d.reportStart = time.Time{}
+	d.counts = make(map[string]*dedupCounts, len(d.counts))
+}
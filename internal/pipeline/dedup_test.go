@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestNewDeduplicator(t *testing.T) {
+	d, err := NewDeduplicator(DedupConfig{}, []string{"sflow"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultDedupMode, d.config.Mode)
+	assert.Equal(t, DefaultDedupAction, d.config.Action)
+	assert.Equal(t, DefaultDedupWindow, d.config.Window)
+
+	d, err = NewDeduplicator(DedupConfig{Mode: DedupModeAccounting, AccountingPoints: []string{"192.0.2.1:3", "192.0.2.2:*", "2001:db8::1:7"}}, []string{"sflow"})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]map[string]bool{
+		"192.0.2.1":   {"3": true},
+		"192.0.2.2":   {"*": true},
+		"2001:db8::1": {"7": true},
+	}, d.points)
+
+	for _, config := range []DedupConfig{
+		{Mode: "bogus"},
+		{Action: "bogus"},
+		{Mode: DedupModeAccounting},
+		{Mode: DedupModeAccounting, AccountingPoints: []string{"192.0.2.1"}},
+		{Mode: DedupModeAccounting, AccountingPoints: []string{"192.0.2.1:x"}},
+		{ReportInterval: time.Millisecond},
+	} {
+		_, err = NewDeduplicator(config, []string{"sflow"})
+		assert.Error(t, err, "%v", config)
+	}
+}
+
+func TestDedupWindow(t *testing.T) {
+	d, err := NewDeduplicator(DedupConfig{Window: 10 * time.Second}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	now := time.Unix(1600000000, 0).Truncate(time.Minute)
+
+	// The first exporter is counted, the same exporter again is not a duplicate
+	assert.False(t, d.duplicate(testFlow{agent: "192.0.2.1", in: 1}.record(), now))
+	assert.False(t, d.duplicate(testFlow{agent: "192.0.2.1", in: 1}.record(), now))
+	assert.True(t, d.duplicate(testFlow{agent: "192.0.2.2", in: 1}.record(), now))
+	assert.False(t, d.duplicate(testFlow{agent: "192.0.2.2", in: 1, srcPort: 40001}.record(), now))
+
+	// Owned while the owner reports it, forgotten two windows after it stops
+	assert.False(t, d.duplicate(testFlow{agent: "192.0.2.1", in: 1}.record(), now.Add(10*time.Second)))
+	assert.True(t, d.duplicate(testFlow{agent: "192.0.2.2", in: 1}.record(), now.Add(15*time.Second)))
+	assert.False(t, d.duplicate(testFlow{agent: "192.0.2.1", in: 1}.record(), now.Add(25*time.Second)))
+	assert.True(t, d.duplicate(testFlow{agent: "192.0.2.2", in: 1}.record(), now.Add(30*time.Second)))
+	assert.False(t, d.duplicate(testFlow{agent: "192.0.2.2", in: 1, srcPort: 40002}.record(), now.Add(45*time.Second)))
+	assert.False(t, d.duplicate(testFlow{agent: "192.0.2.3", in: 1}.record(), now.Add(59*time.Second)))
+
+	var events []flowrecord.Attributes
+	emit := func(event flowrecord.Event) { events = append(events, event.(flowrecord.Attributes)) }
+
+	d.Tick(now.Add(30*time.Second), emit)
+	assert.Empty(t, events)
+
+	d.Tick(now.Add(time.Minute), emit)
+	assert.Equal(t, 3, len(events))
+
+	counts := make(map[string]flowrecord.Attributes)
+	for _, event := range events {
+		counts[event["agent"].(string)] = event
+	}
+
+	assert.Equal(t, DefaultDedupEventType, counts["192.0.2.2"]["eventType"])
+	assert.Equal(t, DedupModeWindow, counts["192.0.2.2"]["mode"])
+	assert.Equal(t, int64(5), counts["192.0.2.2"]["flows"])
+	assert.Equal(t, int64(3), counts["192.0.2.2"]["duplicates"])
+	assert.Equal(t, int64(0), counts["192.0.2.1"]["duplicates"])
+	assert.Empty(t, d.counts)
+}
+
+func TestDedupOwnerMoves(t *testing.T) {
+	d, err := NewDeduplicator(DedupConfig{Window: 10 * time.Second}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	now := time.Unix(1600000000, 0)
+
+	assert.False(t, d.duplicate(testFlow{agent: "192.0.2.1"}.record(), now))
+
+	// The owner stops reporting, such as after a route change, and the other
+	// exporter takes over once it has been silent for a window
+	for _, seconds := range []int{0, 4, 8} {
+		assert.True(t, d.duplicate(testFlow{agent: "192.0.2.2"}.record(), now.Add(time.Duration(seconds)*time.Second)), seconds)
+	}
+
+	assert.False(t, d.duplicate(testFlow{agent: "192.0.2.2"}.record(), now.Add(12*time.Second)))
+	assert.False(t, d.duplicate(testFlow{agent: "192.0.2.2"}.record(), now.Add(16*time.Second)))
+	assert.True(t, d.duplicate(testFlow{agent: "192.0.2.1"}.record(), now.Add(17*time.Second)))
+}
+
+func TestDedupAccounting(t *testing.T) {
+	d, err := NewDeduplicator(DedupConfig{Mode: DedupModeAccounting, Action: DedupActionDrop, AccountingPoints: []string{"192.0.2.1:3", "192.0.2.2:*"}}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	var events []flowrecord.Event
+	emit := func(event flowrecord.Event) { events = append(events, event) }
+
+	d.Process(testFlow{agent: "192.0.2.1", in: 3}.record(), emit)
+	d.Process(testFlow{agent: "192.0.2.1", in: 4}.record(), emit)
+	d.Process(testFlow{agent: "192.0.2.2", in: 9}.record(), emit)
+	d.Process(testFlow{agent: "192.0.2.3", in: 3}.record(), emit)
+	d.Process(flowrecord.Attributes{"eventType": "other"}, emit)
+
+	assert.Equal(t, 3, len(events))
+	assert.Equal(t, "192.0.2.1", events[0].(*flowrecord.FlowRecord).Agent)
+	assert.Equal(t, "192.0.2.2", events[1].(*flowrecord.FlowRecord).Agent)
+	assert.Equal(t, uint64(1), d.counts["192.0.2.1"].duplicates)
+}
+
+func TestDedupMark(t *testing.T) {
+	d, err := NewDeduplicator(DedupConfig{}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	var events []flowrecord.Event
+	emit := func(event flowrecord.Event) { events = append(events, event) }
+
+	d.Process(testFlow{agent: "192.0.2.1", in: 1}.record(), emit)
+	d.Process(testFlow{agent: "192.0.2.2", in: 1}.record(), emit)
+
+	assert.Equal(t, 2, len(events))
+	assert.NotContains(t, events[0].Attributes(), "duplicate")
+	assert.Equal(t, true, events[1].Attributes()["duplicate"])
+}
+
+// Run with -race, the flow handler reads records after they are queued
+func TestDedupMarkRace(t *testing.T) {
+	d, err := NewDeduplicator(DedupConfig{}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	d.Process(testFlow{agent: "192.0.2.1", in: 1}.record(), func(flowrecord.Event) {})
+
+	rec := testFlow{agent: "192.0.2.2", in: 1}.record()
+	rec.Enrich("threatMatch", true)
+
+	done := make(chan bool)
+
+	go func() {
+		for i := 0; i < 100; i++ {
+			rec.Clone()
+		}
+
+		done <- true
+	}()
+
+	var marked flowrecord.Event
+	d.Process(rec, func(event flowrecord.Event) { marked = event })
+
+	<-done
+
+	assert.Equal(t, true, marked.Attributes()["duplicate"])
+	assert.NotContains(t, rec.Enrichment, "duplicate")
+}
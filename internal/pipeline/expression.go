@@ -0,0 +1,560 @@
+package pipeline
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+/******************************************************************************
+ *
+ * Filter expressions compare attributes of an event with literals:
+ *
+ *   protocolName == "icmp" && scaledByteCount < 1000
+ *   !(dstAddr in "10.0.0.0/8") || vlan in [100, 200]
+ *
+ * Comparisons are ==, !=, <, <=, > and >=, against a number, a quoted string,
+ * true or false.  "in" matches an address against a network, or a value
+ * against a list.  A bare attribute is true when it is the boolean true.
+ * Comparisons combine with &&, ||, ! and parentheses.
+ *
+ * An attribute the event does not have is different from every literal, and
+ * in nothing.
+ *
+ ******************************************************************************/
+type expression interface {
+	eval(lookup func(string) (interface{}, bool)) bool
+}
+
+// parseExpression compiles an expression, or says where it is wrong
+func parseExpression(source string) (expression, error) {
+	tokens, err := lexExpression(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &expressionParser{tokens: tokens}
+
+	expr, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEnd {
+		return nil, fmt.Errorf("unexpected '%s' at %d", t.text, t.pos)
+	}
+
+	return expr, nil
+}
+
+/******************************************************************************
+ *
+ * Lexer
+ *
+ ******************************************************************************/
+type tokenKind int
+
+const (
+	tokenEnd tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// Operators, longest first so "<=" is not read as "<"
+var expressionOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func lexExpression(source string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(source); {
+		c := rune(source[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(source) && source[end] != '"' {
+				if source[end] == '\\' {
+					end++
+				}
+				end++
+			}
+
+			if end >= len(source) {
+				return nil, fmt.Errorf("unterminated string at %d", i)
+			}
+
+			text, err := strconv.Unquote(source[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %v", i, err)
+			}
+
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: i})
+			i = end + 1
+		case unicode.IsDigit(c) || (c == '-' && i+1 < len(source) && unicode.IsDigit(rune(source[i+1]))):
+			end := i + 1
+			for end < len(source) && (unicode.IsDigit(rune(source[end])) || source[end] == '.') {
+				end++
+			}
+
+			tokens = append(tokens, token{kind: tokenNumber, text: source[i:end], pos: i})
+			i = end
+		case unicode.IsLetter(c) || c == '_':
+			end := i + 1
+			for end < len(source) && (unicode.IsLetter(rune(source[end])) || unicode.IsDigit(rune(source[end])) || source[end] == '_' || source[end] == '.') {
+				end++
+			}
+
+			tokens = append(tokens, token{kind: tokenIdent, text: source[i:end], pos: i})
+			i = end
+		default:
+			var op string
+			for _, candidate := range expressionOperators {
+				if strings.HasPrefix(source[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+
+			if op == "" {
+				return nil, fmt.Errorf("unexpected '%c' at %d", c, i)
+			}
+
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEnd, text: "end of expression", pos: len(source)}), nil
+}
+
+/******************************************************************************
+ *
+ * Parser
+ *
+ ******************************************************************************/
+type expressionParser struct {
+	tokens []token
+	next   int
+}
+
+func (p *expressionParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *expressionParser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEnd {
+		p.next++
+	}
+
+	return t
+}
+
+// accept takes the next token if it is the operator
+func (p *expressionParser) accept(op string) bool {
+	if t := p.peek(); t.kind == tokenOperator && t.text == op {
+		p.next++
+		return true
+	}
+
+	return false
+}
+
+func (p *expressionParser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return fmt.Errorf("expected '%s' at %d, found '%s'", op, t.pos, t.text)
+	}
+
+	return nil
+}
+
+func (p *expressionParser) or() (expression, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("||") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+
+		left = orExpression{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) and() (expression, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.accept("&&") {
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		left = andExpression{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *expressionParser) unary() (expression, error) {
+	if p.accept("!") {
+		expr, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notExpression{expr}, nil
+	}
+
+	if p.accept("(") {
+		expr, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		return expr, p.expect(")")
+	}
+
+	return p.comparison()
+}
+
+func (p *expressionParser) comparison() (expression, error) {
+	t := p.take()
+	if t.kind != tokenIdent || isExpressionKeyword(t.text) {
+		return nil, fmt.Errorf("expected an attribute at %d, found '%s'", t.pos, t.text)
+	}
+
+	name := t.text
+	next := p.peek()
+
+	switch {
+	case next.kind == tokenIdent && next.text == "in":
+		p.take()
+		return p.in(name)
+	case next.kind == tokenOperator && isComparison(next.text):
+		p.take()
+
+		value, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+
+		if value.kind == literalBool && next.text != "==" && next.text != "!=" {
+			return nil, fmt.Errorf("'%s' cannot compare with %s at %d", next.text, value, next.pos)
+		}
+
+		return comparisonExpression{name: name, op: next.text, value: value}, nil
+	}
+
+	return truthExpression{name}, nil
+}
+
+// in is a network, or a list of literals
+func (p *expressionParser) in(name string) (expression, error) {
+	t := p.peek()
+
+	if t.kind == tokenString {
+		p.take()
+
+		_, network, err := net.ParseCIDR(t.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network '%s' at %d", t.text, t.pos)
+		}
+
+		return networkExpression{name: name, network: network}, nil
+	}
+
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+
+	var values []literal
+
+	for {
+		value, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+
+		values = append(values, value)
+
+		if p.accept("]") {
+			return listExpression{name: name, values: values}, nil
+		}
+
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *expressionParser) literal() (literal, error) {
+	t := p.take()
+
+	switch {
+	case t.kind == tokenString:
+		return literal{kind: literalString, text: t.text}, nil
+	case t.kind == tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return literal{}, fmt.Errorf("invalid number '%s' at %d", t.text, t.pos)
+		}
+
+		return literal{kind: literalNumber, text: t.text, number: n}, nil
+	case t.kind == tokenIdent && (t.text == "true" || t.text == "false"):
+		return literal{kind: literalBool, text: t.text, boolean: t.text == "true"}, nil
+	}
+
+	return literal{}, fmt.Errorf("expected a value at %d, found '%s'", t.pos, t.text)
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+
+	return false
+}
+
+func isExpressionKeyword(word string) bool {
+	return word == "in" || word == "true" || word == "false"
+}
+
+/******************************************************************************
+ *
+ * Evaluation
+ *
+ ******************************************************************************/
+type literalKind int
+
+const (
+	literalString literalKind = iota
+	literalNumber
+	literalBool
+)
+
+type literal struct {
+	kind    literalKind
+	text    string
+	number  float64
+	boolean bool
+}
+
+func (l literal) String() string {
+	if l.kind == literalString {
+		return strconv.Quote(l.text)
+	}
+
+	return l.text
+}
+
+// equals returns true if an attribute value is the literal
+func (l literal) equals(value interface{}) bool {
+	switch l.kind {
+	case literalNumber:
+		n, ok := expressionNumber(value)
+		return ok && n == l.number
+	case literalBool:
+		b, ok := value.(bool)
+		return ok && b == l.boolean
+	}
+
+	return fmt.Sprint(value) == l.text
+}
+
+// compare orders an attribute value against the literal, -1, 0 or 1
+func (l literal) compare(value interface{}) (int, bool) {
+	var diff float64
+
+	switch l.kind {
+	case literalNumber:
+		n, ok := expressionNumber(value)
+		if !ok {
+			return 0, false
+		}
+
+		diff = n - l.number
+	case literalString:
+		diff = float64(strings.Compare(fmt.Sprint(value), l.text))
+	default:
+		return 0, false
+	}
+
+	switch {
+	case diff < 0:
+		return -1, true
+	case diff > 0:
+		return 1, true
+	}
+
+	return 0, true
+}
+
+// expressionNumber is a numeric value, or a string holding one
+func expressionNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(v, 64)
+		return n, err == nil
+	}
+
+	return 0, false
+}
+
+type orExpression struct {
+	left, right expression
+}
+
+func (e orExpression) eval(lookup func(string) (interface{}, bool)) bool {
+	return e.left.eval(lookup) || e.right.eval(lookup)
+}
+
+type andExpression struct {
+	left, right expression
+}
+
+func (e andExpression) eval(lookup func(string) (interface{}, bool)) bool {
+	return e.left.eval(lookup) && e.right.eval(lookup)
+}
+
+type notExpression struct {
+	expr expression
+}
+
+func (e notExpression) eval(lookup func(string) (interface{}, bool)) bool {
+	return !e.expr.eval(lookup)
+}
+
+type truthExpression struct {
+	name string
+}
+
+func (e truthExpression) eval(lookup func(string) (interface{}, bool)) bool {
+	value, _ := lookup(e.name)
+	b, _ := value.(bool)
+
+	return b
+}
+
+type comparisonExpression struct {
+	name  string
+	op    string
+	value literal
+}
+
+func (e comparisonExpression) eval(lookup func(string) (interface{}, bool)) bool {
+	value, ok := lookup(e.name)
+	if !ok {
+		return e.op == "!="
+	}
+
+	switch e.op {
+	case "==":
+		return e.value.equals(value)
+	case "!=":
+		return !e.value.equals(value)
+	}
+
+	order, ok := e.value.compare(value)
+	if !ok {
+		return false
+	}
+
+	switch e.op {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	case ">=":
+		return order >= 0
+	}
+
+	return false
+}
+
+type networkExpression struct {
+	name    string
+	network *net.IPNet
+}
+
+func (e networkExpression) eval(lookup func(string) (interface{}, bool)) bool {
+	value, ok := lookup(e.name)
+	if !ok {
+		return false
+	}
+
+	ip, ok := value.(net.IP)
+	if !ok {
+		ip = net.ParseIP(fmt.Sprint(value))
+	}
+
+	return ip != nil && e.network.Contains(ip)
+}
+
+type listExpression struct {
+	name   string
+	values []literal
+}
+
+func (e listExpression) eval(lookup func(string) (interface{}, bool)) bool {
+	value, ok := lookup(e.name)
+	if !ok {
+		return false
+	}
+
+	for _, l := range e.values {
+		if l.equals(value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpression(t *testing.T) {
+	attrs := map[string]interface{}{
+		"protocolName":             "icmp",
+		"scaledByteCount":          int64(800),
+		"vlan":                     uint16(200),
+		"srcAddr":                  "10.1.2.3",
+		"agentAddress":             net.ParseIP("192.0.2.1"),
+		"transportDestinationPort": "443",
+		"tcpFlagSYN":               true,
+		"ratio":                    0.25,
+		"name":                     `say "hi"`,
+	}
+
+	lookup := func(name string) (interface{}, bool) {
+		value, ok := attrs[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		source   string
+		expected bool
+	}{
+		{`protocolName == "icmp" && scaledByteCount < 1000`, true},
+		{`protocolName == "icmp" && scaledByteCount >= 1000`, false},
+		{`protocolName != "icmp" || vlan == 200`, true},
+		{`vlan in [100, 200]`, true},
+		{`vlan in [100, 300]`, false},
+		{`protocolName in ["tcp", "icmp"]`, true},
+		{`srcAddr in "10.0.0.0/8"`, true},
+		{`agentAddress in "192.0.2.0/24"`, true},
+		{`!(srcAddr in "10.0.0.0/8")`, false},
+		{`transportDestinationPort == 443`, true},
+		{`transportDestinationPort > 100`, true},
+		{`tcpFlagSYN`, true},
+		{`tcpFlagSYN == false`, false},
+		{`!tcpFlagSYN`, false},
+		{`ratio <= 0.25 && ratio > -1`, true},
+		{`protocolName < "tcp"`, true},
+		{`name == "say \"hi\""`, true},
+		{`a || b && c`, false},
+		{`(vlan == 100 || vlan == 200) && scaledByteCount != 0`, true},
+
+		// Attributes that are missing
+		{`missing == 1`, false},
+		{`missing != 1`, true},
+		{`missing < 1`, false},
+		{`missing in [1]`, false},
+		{`missing in "10.0.0.0/8"`, false},
+		{`missing`, false},
+	}
+
+	for _, test := range tests {
+		expr, err := parseExpression(test.source)
+		if assert.NoError(t, err, test.source) {
+			assert.Equal(t, test.expected, expr.eval(lookup), test.source)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	for _, source := range []string{
+		``,
+		`vlan ==`,
+		`vlan == 100 &&`,
+		`(vlan == 100`,
+		`vlan == 100)`,
+		`vlan = 100`,
+		`vlan == "open`,
+		`vlan in "not a network"`,
+		`vlan in [1, 2`,
+		`vlan in 1`,
+		`tcpFlagSYN < true`,
+		`== 1`,
+		`true == 1`,
+		`vlan == 1.2.3`,
+		`vlan # 1`,
+	} {
+		_, err := parseExpression(source)
+		assert.Error(t, err, source)
+	}
+}
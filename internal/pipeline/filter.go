@@ -0,0 +1,224 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// What a filter does with the events it matches
+const (
+	FilterActionInclude = "include"
+	FilterActionExclude = "exclude"
+)
+
+type FilterConfig struct {
+	File string `envconfig:"FILTER_FILE"`
+}
+
+// FilterStatus is a filter and how often it matched
+type FilterStatus struct {
+	Name       string `json:"name"`
+	EventType  string `json:"eventType"`
+	Action     string `json:"action"`
+	Expression string `json:"expression"`
+	Evaluated  uint64 `json:"evaluated"`
+	Matched    uint64 `json:"matched"`
+}
+
+type filterRule struct {
+	// Updated atomically, first to be 64-bit aligned
+	evaluated uint64
+	matched   uint64
+
+	Name       string `json:"name"`
+	EventType  string `json:"eventType"`
+	Action     string `json:"action"`
+	Expression string `json:"expression"`
+
+	expr expression
+}
+
+/******************************************************************************
+ *
+ * Create a Filter from a filter file, every expression has to compile
+ *
+ * Expected Format:
+ *   {"filters": [{"name": "smallIcmp", "eventType": "sflow",
+ *     "action": "exclude", "expression": "protocolName == \"icmp\""}]}
+ ******************************************************************************/
+func NewFilter(config FilterConfig) (*Filter, error) {
+	fileh, err := os.Open(config.File)
+	if err != nil {
+		return nil, err
+	}
+	defer fileh.Close()
+
+	var file struct {
+		Filters []*filterRule `json:"filters"`
+	}
+
+	decoder := json.NewDecoder(fileh)
+	decoder.DisallowUnknownFields()
+
+	if err = decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to read filters '%s': %v", config.File, err)
+	}
+
+	f := &Filter{
+		rules:    file.Filters,
+		excludes: make(map[string][]*filterRule),
+		includes: make(map[string][]*filterRule),
+	}
+
+	names := make(map[string]bool, len(file.Filters))
+
+	for i, rule := range file.Filters {
+		if err = rule.compile(); err != nil {
+			return nil, fmt.Errorf("filter %d in '%s': %v", i+1, config.File, err)
+		}
+
+		if names[rule.Name] {
+			return nil, fmt.Errorf("filter %d in '%s': duplicate name '%s'", i+1, config.File, rule.Name)
+		}
+
+		names[rule.Name] = true
+
+		if rule.Action == FilterActionInclude {
+			f.includes[rule.EventType] = append(f.includes[rule.EventType], rule)
+		} else {
+			f.excludes[rule.EventType] = append(f.excludes[rule.EventType], rule)
+		}
+	}
+
+	log.Infof("filter: Loaded %d filters from '%s'", len(file.Filters), config.File)
+
+	return f, nil
+}
+
+func (r *filterRule) compile() error {
+	if r.Name == "" {
+		return fmt.Errorf("no name")
+	}
+
+	if r.EventType == "" {
+		return fmt.Errorf("no eventType")
+	}
+
+	if r.Action != FilterActionInclude && r.Action != FilterActionExclude {
+		return fmt.Errorf("unknown action '%s'", r.Action)
+	}
+
+	expr, err := parseExpression(r.Expression)
+	if err != nil {
+		return fmt.Errorf("invalid expression '%s': %v", r.Expression, err)
+	}
+
+	r.expr = expr
+
+	return nil
+}
+
+// matches evaluates the rule, and counts it
+func (r *filterRule) matches(lookup func(string) (interface{}, bool)) bool {
+	atomic.AddUint64(&r.evaluated, 1)
+
+	if !r.expr.eval(lookup) {
+		return false
+	}
+
+	atomic.AddUint64(&r.matched, 1)
+
+	return true
+}
+
+/******************************************************************************
+ *
+ * Filter drops events by expression.  An event any exclude filter of its
+ * type matches is dropped.  When its type has include filters, an event
+ * none of them match is dropped too.  Events of other types pass.
+ *
+ ******************************************************************************/
+type Filter struct {
+	rules    []*filterRule
+	excludes map[string][]*filterRule
+	includes map[string][]*filterRule
+}
+
+func (f *Filter) Name() string {
+	return "filter"
+}
+
+func (f *Filter) Process(event flowrecord.Event, emit func(flowrecord.Event)) {
+	if f.keep(event) {
+		emit(event)
+	}
+}
+
+func (f *Filter) keep(event flowrecord.Event) bool {
+	eventType := event.EventType()
+	lookup := eventLookup(event)
+
+	for _, rule := range f.excludes[eventType] {
+		if rule.matches(lookup) {
+			return false
+		}
+	}
+
+	includes := f.includes[eventType]
+	if len(includes) == 0 {
+		return true
+	}
+
+	for _, rule := range includes {
+		if rule.matches(lookup) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// eventLookup finds attributes of an event by name, flow records by the
+// names of either schema
+func eventLookup(event flowrecord.Event) func(string) (interface{}, bool) {
+	if rec, ok := event.(*flowrecord.FlowRecord); ok {
+		return func(name string) (interface{}, bool) {
+			if name == "eventType" {
+				return rec.EventType(), true
+			}
+
+			return rec.Value(name)
+		}
+	}
+
+	attrs := event.Attributes()
+
+	return func(name string) (interface{}, bool) {
+		value, ok := attrs[name]
+		return value, ok
+	}
+}
+
+// Status returns every filter with its counts, in the order of the file
+func (f *Filter) Status() []FilterStatus {
+	status := make([]FilterStatus, len(f.rules))
+
+	for i, rule := range f.rules {
+		status[i] = FilterStatus{
+			Name:       rule.Name,
+			EventType:  rule.EventType,
+			Action:     rule.Action,
+			Expression: rule.Expression,
+			Evaluated:  atomic.LoadUint64(&rule.evaluated),
+			Matched:    atomic.LoadUint64(&rule.matched),
+		}
+	}
+
+	return status
+}
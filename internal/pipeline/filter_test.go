@@ -0,0 +1,83 @@
+package pipeline
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestNewFilter(t *testing.T) {
+	_, err := NewFilter(FilterConfig{File: "/nonexistent"})
+	assert.Error(t, err)
+
+	for _, data := range []string{
+		`{"filters": [`,
+		`{"filters": [{"name": "a", "eventType": "sflow", "action": "exclude", "expression": "vlan == 1", "bogus": 1}]}`,
+		`{"filters": [{"eventType": "sflow", "action": "exclude", "expression": "vlan == 1"}]}`,
+		`{"filters": [{"name": "a", "action": "exclude", "expression": "vlan == 1"}]}`,
+		`{"filters": [{"name": "a", "eventType": "sflow", "action": "drop", "expression": "vlan == 1"}]}`,
+		`{"filters": [{"name": "a", "eventType": "sflow", "action": "exclude", "expression": "vlan =="}]}`,
+		`{"filters": [{"name": "a", "eventType": "sflow", "action": "exclude", "expression": "vlan == 1"},
+			{"name": "a", "eventType": "ipfix", "action": "exclude", "expression": "vlan == 1"}]}`,
+	} {
+		filename := writeTestFile(t, data)
+
+		_, err = NewFilter(FilterConfig{File: filename})
+		assert.Error(t, err, data)
+
+		os.Remove(filename)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	filename := writeTestFile(t, `{"filters": [
+	{"name": "smallIcmp", "eventType": "sflow", "action": "exclude", "expression": "protocolName == \"icmp\" && scaledByteCount < 1000"},
+	{"name": "telemetry", "eventType": "sflow", "action": "exclude", "expression": "dstAddr == \"192.0.2.10\" && dstPort == 6343"},
+	{"name": "internal", "eventType": "flow", "action": "include", "expression": "srcAddr in \"10.0.0.0/8\""},
+	{"name": "aggregates", "eventType": "networkFlowAggregate", "action": "exclude", "expression": "flows < 2"}
+]}`)
+	defer os.Remove(filename)
+
+	f, err := NewFilter(FilterConfig{File: filename})
+	assert.NoError(t, err)
+
+	record := func(eventType string, src string, dst string, dstPort uint16, protocolName string, scaledByteCount int64) *flowrecord.FlowRecord {
+		rec := testFlow{eventType: eventType, src: src, dst: dst, protocol: 17, dstPort: dstPort}.record()
+		rec.Set("scaledByteCount", scaledByteCount)
+		rec.Enrich("protocolName", protocolName)
+
+		return rec
+	}
+
+	tests := []struct {
+		event    flowrecord.Event
+		expected bool
+	}{
+		{record("sflow", "10.0.0.1", "192.0.2.20", 0, "icmp", 500), false},
+		{record("sflow", "10.0.0.1", "192.0.2.20", 0, "icmp", 5000), true},
+		{record("sflow", "10.0.0.1", "192.0.2.10", 6343, "udp", 500), false},
+		{record("sflow", "10.0.0.1", "192.0.2.10", 53, "udp", 500), true},
+		{record("flow", "10.0.0.1", "192.0.2.10", 53, "udp", 500).Normalized("flow"), true},
+		{record("flow", "172.16.0.1", "192.0.2.10", 53, "udp", 500).Normalized("flow"), false},
+		{record("ipfix", "172.16.0.1", "192.0.2.10", 6343, "icmp", 1), true},
+		{flowrecord.Attributes{"eventType": "networkFlowAggregate", "flows": int64(1)}, false},
+		{flowrecord.Attributes{"eventType": "networkFlowAggregate", "flows": int64(2)}, true},
+	}
+
+	for i, test := range tests {
+		var events []flowrecord.Event
+		f.Process(test.event, func(event flowrecord.Event) { events = append(events, event) })
+
+		assert.Equal(t, test.expected, len(events) == 1, "event %d", i)
+	}
+
+	assert.Equal(t, []FilterStatus{
+		{Name: "smallIcmp", EventType: "sflow", Action: FilterActionExclude, Expression: `protocolName == "icmp" && scaledByteCount < 1000`, Evaluated: 4, Matched: 1},
+		{Name: "telemetry", EventType: "sflow", Action: FilterActionExclude, Expression: `dstAddr == "192.0.2.10" && dstPort == 6343`, Evaluated: 3, Matched: 1},
+		{Name: "internal", EventType: "flow", Action: FilterActionInclude, Expression: `srcAddr in "10.0.0.0/8"`, Evaluated: 2, Matched: 1},
+		{Name: "aggregates", EventType: "networkFlowAggregate", Action: FilterActionExclude, Expression: "flows < 2", Evaluated: 2, Matched: 1},
+	}, f.Status())
+}
@@ -0,0 +1,75 @@
+package pipeline
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// 2^10 registers, a standard error of about 3%
+const (
+	hllPrecision = 10
+	hllRegisters = 1 << hllPrecision
+)
+
+/******************************************************************************
+ *
+ * A HyperLogLog estimates the number of distinct values added to it in a
+ * fixed kilobyte, whatever the number of values
+ *
+ ******************************************************************************/
+type hyperLogLog struct {
+	registers [hllRegisters]uint8
+}
+
+func (h *hyperLogLog) add(value string) {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(value))
+
+	h.addHash(mix64(hash.Sum64()))
+}
+
+func (h *hyperLogLog) addHash(x uint64) {
+	index := x >> (64 - hllPrecision)
+	rank := uint8(bits.LeadingZeros64(x<<hllPrecision|1<<(hllPrecision-1)) + 1)
+
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+	}
+}
+
+// estimate returns the approximate number of distinct values
+func (h *hyperLogLog) estimate() uint64 {
+	sum := 0.0
+	zeros := 0
+
+	for _, r := range h.registers {
+		sum += 1.0 / float64(uint64(1)<<r)
+
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	m := float64(hllRegisters)
+	alpha := 0.7213 / (1 + 1.079/m)
+	estimate := alpha * m * m / sum
+
+	// Small cardinalities are better estimated by linear counting
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return uint64(estimate + 0.5)
+}
+
+// mix64 spreads FNV's output over every bit, FNV alone is weak in the high bits
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+
+	return x
+}
@@ -0,0 +1,36 @@
+package pipeline
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	var h hyperLogLog
+	assert.Equal(t, uint64(0), h.estimate())
+
+	// Repeats are not counted again
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 20; j++ {
+			h.add(fmt.Sprintf("10.0.0.%d", j))
+		}
+	}
+
+	assert.InDelta(t, 20, float64(h.estimate()), 1)
+
+	for _, n := range []int{1000, 10000, 100000} {
+		var h hyperLogLog
+		for i := 0; i < n; i++ {
+			h.add(fmt.Sprintf("value-%d", i))
+		}
+
+		assert.InEpsilon(t, n, float64(h.estimate()), 0.1, "%d values", n)
+	}
+}
+
+func TestMix64(t *testing.T) {
+	assert.Equal(t, uint64(0), mix64(0))
+	assert.NotEqual(t, mix64(1), mix64(2))
+}
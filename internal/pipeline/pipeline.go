@@ -0,0 +1,348 @@
+package pipeline
+
+import (
+	"time"
+
+	newrelic "github.com/newrelic/go-agent"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+	"github.com/newrelic/nri-network-telemetry/internal/util"
+)
+
+type ControlMessage int
+
+const (
+	ControlMessageQuit  ControlMessage = iota
+	ControlMessageStart ControlMessage = iota
+	ControlMessageReady ControlMessage = iota
+	ControlMessageDone  ControlMessage = iota
+)
+
+const (
+	InputChannelBufferSize = 15000
+	tickInterval           = time.Second
+)
+
+/******************************************************************************
+ *
+ * A Stage sees every event on its way from the flow handlers to the emitter.
+ * Process passes on the events that should continue, which may be none, the
+ * same event, or new ones.  Stages are only ever called from the pipeline's
+ * goroutine.
+ *
+ ******************************************************************************/
+type Stage interface {
+	Name() string
+	Process(event flowrecord.Event, emit func(flowrecord.Event))
+}
+
+// A Ticker is a Stage that also emits on its own schedule, and when the
+// pipeline is stopped
+type Ticker interface {
+	Tick(now time.Time, emit func(flowrecord.Event))
+	Flush(now time.Time, emit func(flowrecord.Event))
+}
+
+type Config struct {
+	// Flow event types the stages work on, the others are passed along
+	EventTypes []string `envconfig:"PIPELINE_EVENT_TYPES"`
+
+	Aggregate    AggregateConfig
+	TopN         TopNConfig
+	Cardinality  CardinalityConfig
+	Attack       AttackConfig
+	Scan         ScanConfig
+	Conversation ConversationConfig
+	Dedup        DedupConfig
+	Rules        RulesConfig
+	Filter       FilterConfig
+}
+
+// eventTypes is a set of event types
+type eventTypes map[string]bool
+
+func newEventTypes(names []string) eventTypes {
+	set := make(eventTypes, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+
+	return set
+}
+
+// flow returns the event as a flow record, if it is one of the set
+func (t eventTypes) flow(event flowrecord.Event) (*flowrecord.FlowRecord, bool) {
+	rec, ok := event.(*flowrecord.FlowRecord)
+	if !ok || !t[rec.EventType()] {
+		return nil, false
+	}
+
+	return rec, true
+}
+
+// counted returns the event as a flow record, if it is one of the set and not
+// a duplicate marked by the dedup stage
+func (t eventTypes) counted(event flowrecord.Event) (*flowrecord.FlowRecord, bool) {
+	rec, ok := t.flow(event)
+	if !ok || duplicateFlow(rec) {
+		return nil, false
+	}
+
+	return rec, true
+}
+
+// duplicateFlow returns true if the dedup stage marked the flow as a duplicate
+func duplicateFlow(rec *flowrecord.FlowRecord) bool {
+	duplicate, _ := rec.Enrichment["duplicate"].(bool)
+
+	return duplicate
+}
+
+/******************************************************************************
+ *
+ * Create the pipeline for the configured stages, nil when there are none
+ *
+ ******************************************************************************/
+func New(config Config, emitChan chan flowrecord.Event, nr newrelic.Application) (*Pipeline, error) {
+	p := &Pipeline{
+		inputChan: make(chan flowrecord.Event, InputChannelBufferSize),
+		emitChan:  emitChan,
+		nr:        nr,
+	}
+
+	// Unwanted flows are dropped before anything counts them
+	if config.Filter.File != "" {
+		filter, err := NewFilter(config.Filter)
+		if err != nil {
+			return nil, err
+		}
+
+		p.filter = filter
+		p.stages = append(p.stages, filter)
+	}
+
+	// Duplicates go next, the stages after leave marked ones out of their counts
+	if config.Dedup.Enabled {
+		deduplicator, err := NewDeduplicator(config.Dedup, config.EventTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		p.stages = append(p.stages, deduplicator)
+	}
+
+	if config.TopN.Enabled {
+		p.topTalkers = NewTopTalkers(config.TopN, config.EventTypes)
+		p.stages = append(p.stages, p.topTalkers)
+	}
+
+	if config.Cardinality.Enabled {
+		cardinality, err := NewCardinality(config.Cardinality, config.EventTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		p.cardinality = cardinality
+		p.stages = append(p.stages, cardinality)
+	}
+
+	if config.Attack.Enabled {
+		detector, err := NewAttackDetector(config.Attack, config.EventTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		p.stages = append(p.stages, detector)
+	}
+
+	if config.Scan.Enabled {
+		detector, err := NewScanDetector(config.Scan, config.EventTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		p.stages = append(p.stages, detector)
+	}
+
+	if config.Conversation.Enabled {
+		stitcher, err := NewConversationStitcher(config.Conversation, config.EventTypes)
+		if err != nil {
+			return nil, err
+		}
+
+		p.stages = append(p.stages, stitcher)
+	}
+
+	// Aggregation replaces flows, the stages that need every flow go before it
+	if config.Aggregate.Enabled {
+		if len(config.Aggregate.EventTypes) == 0 {
+			config.Aggregate.EventTypes = config.EventTypes
+		}
+
+		aggregator, err := NewAggregator(config.Aggregate)
+		if err != nil {
+			return nil, err
+		}
+
+		p.aggregator = aggregator
+		p.stages = append(p.stages, aggregator)
+	}
+
+	// Rules shape the events as they are emitted, whichever stage made them
+	if config.Rules.File != "" {
+		rules, err := NewAttributeRules(config.Rules)
+		if err != nil {
+			return nil, err
+		}
+
+		p.stages = append(p.stages, rules)
+	}
+
+	if len(p.stages) == 0 {
+		return nil, nil
+	}
+
+	return p, nil
+}
+
+/******************************************************************************
+ *
+ * Pipeline object
+ *
+ ******************************************************************************/
+type Pipeline struct {
+	inputChan chan flowrecord.Event
+	emitChan  chan flowrecord.Event
+	stages    []Stage
+	nr        newrelic.Application
+
+	aggregator  *Aggregator
+	topTalkers  *TopTalkers
+	cardinality *Cardinality
+	filter      *Filter
+}
+
+// InputChan is where the flow handlers send their events
+func (p *Pipeline) InputChan() chan flowrecord.Event {
+	return p.inputChan
+}
+
+// Aggregator returns the aggregation stage, nil if it is not enabled
+func (p *Pipeline) Aggregator() *Aggregator {
+	if p == nil {
+		return nil
+	}
+
+	return p.aggregator
+}
+
+// TopTalkers returns the top-N stage, nil if it is not enabled
+func (p *Pipeline) TopTalkers() *TopTalkers {
+	if p == nil {
+		return nil
+	}
+
+	return p.topTalkers
+}
+
+// Cardinality returns the cardinality stage, nil if it is not enabled
+func (p *Pipeline) Cardinality() *Cardinality {
+	if p == nil {
+		return nil
+	}
+
+	return p.cardinality
+}
+
+// Filter returns the filter stage, nil if there is no filter file
+func (p *Pipeline) Filter() *Filter {
+	if p == nil {
+		return nil
+	}
+
+	return p.filter
+}
+
+/******************************************************************************
+ *
+ * Run events through the stages until told to quit
+ *
+ ******************************************************************************/
+func (p *Pipeline) Start(controlChan chan ControlMessage) error {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case msg := <-controlChan:
+			switch msg {
+			case ControlMessageStart:
+				log.Debug("pipeline: Control Message: Start")
+				continue
+			case ControlMessageQuit:
+				log.Debug("pipeline: Control Message: Quit")
+
+				p.drain()
+				p.tick(time.Now(), true)
+
+				controlChan <- ControlMessageDone // Signal exit
+
+				return nil
+			}
+		case event := <-p.inputChan:
+			p.process(0, event)
+		case now := <-ticker.C:
+			if p.nr != nil {
+				util.LogIfErr(p.nr.RecordCustomMetric("pipelineChanLength", float64(len(p.inputChan))))
+			}
+
+			p.tick(now, false)
+		}
+	}
+}
+
+// drain processes whatever the flow handlers queued before they stopped
+func (p *Pipeline) drain() {
+	for {
+		select {
+		case event := <-p.inputChan:
+			p.process(0, event)
+		default:
+			return
+		}
+	}
+}
+
+// process runs an event through the stages from the given one on
+func (p *Pipeline) process(stage int, event flowrecord.Event) {
+	if stage >= len(p.stages) {
+		p.emitChan <- event
+		return
+	}
+
+	p.stages[stage].Process(event, func(next flowrecord.Event) {
+		p.process(stage+1, next)
+	})
+}
+
+// tick lets every Ticker emit, into the stages that follow it
+func (p *Pipeline) tick(now time.Time, flush bool) {
+	for i, stage := range p.stages {
+		ticker, ok := stage.(Ticker)
+		if !ok {
+			continue
+		}
+
+		next := i + 1
+		emit := func(event flowrecord.Event) {
+			p.process(next, event)
+		}
+
+		if flush {
+			ticker.Flush(now, emit)
+		} else {
+			ticker.Tick(now, emit)
+		}
+	}
+}
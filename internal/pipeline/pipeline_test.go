@@ -0,0 +1,148 @@
+package pipeline
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// testFlow describes a flow for the stage tests, anything left out is a
+// single 100 byte TCP packet from 10.0.0.1:40000 to 192.0.2.80:443, sent
+// unsampled as sflow by 192.0.2.1
+type testFlow struct {
+	eventType    string
+	agent        string
+	src          string
+	dst          string
+	protocol     uint8
+	srcPort      uint16
+	dstPort      uint16
+	in           uint32
+	out          uint32
+	srcAS        uint32
+	bytes        uint64
+	packets      uint64
+	samplingRate uint32
+	flags        []string // TCP flags that are set, such as tcpFlagSYN
+}
+
+func (f testFlow) record() *flowrecord.FlowRecord {
+	or := func(value string, otherwise string) string {
+		if value == "" {
+			return otherwise
+		}
+
+		return value
+	}
+
+	rec := flowrecord.New(or(f.eventType, "sflow"), flowrecord.FlowTypeSflow, or(f.agent, "192.0.2.1"), time.Now())
+	rec.SourceAddress = net.ParseIP(or(f.src, "10.0.0.1"))
+	rec.DestinationAddress = net.ParseIP(or(f.dst, "192.0.2.80"))
+	rec.HasProtocol = true
+	rec.Protocol = f.protocol
+	rec.SourcePort = f.srcPort
+	rec.DestinationPort = f.dstPort
+	rec.InputInterface = f.in
+	rec.OutputInterface = f.out
+	rec.SourceAS = f.srcAS
+	rec.Packets = f.packets
+	rec.Bytes = f.bytes
+	rec.SamplingRate = f.samplingRate
+
+	if rec.Protocol == 0 {
+		rec.Protocol = protocolTCP
+	}
+
+	if rec.SourcePort == 0 {
+		rec.SourcePort = 40000
+	}
+
+	if rec.DestinationPort == 0 {
+		rec.DestinationPort = 443
+	}
+
+	if rec.Packets == 0 {
+		rec.Packets = 1
+	}
+
+	if rec.Bytes == 0 {
+		rec.Bytes = rec.Packets * 100
+	}
+
+	for _, flag := range f.flags {
+		rec.Set(flag, true)
+	}
+
+	return rec
+}
+
+// countStage drops every other event and emits a count when ticked
+type countStage struct {
+	seen int
+}
+
+func (s *countStage) Name() string {
+	return "count"
+}
+
+func (s *countStage) Process(event flowrecord.Event, emit func(flowrecord.Event)) {
+	s.seen++
+
+	if s.seen%2 == 1 {
+		emit(event)
+	}
+}
+
+func (s *countStage) Tick(now time.Time, emit func(flowrecord.Event)) {}
+
+func (s *countStage) Flush(now time.Time, emit func(flowrecord.Event)) {
+	emit(flowrecord.Attributes{"eventType": "count", "seen": s.seen})
+}
+
+func TestNewPipeline(t *testing.T) {
+	p, err := New(Config{}, make(chan flowrecord.Event), nil)
+	assert.NoError(t, err)
+	assert.Nil(t, p)
+	assert.Nil(t, p.Aggregator())
+
+	_, err = New(Config{Aggregate: AggregateConfig{Enabled: true}}, make(chan flowrecord.Event), nil)
+	assert.Error(t, err)
+
+	p, err = New(Config{Aggregate: AggregateConfig{Enabled: true, EventTypes: []string{"sflow"}}}, make(chan flowrecord.Event), nil)
+	assert.NoError(t, err)
+	assert.NotNil(t, p.Aggregator())
+}
+
+func TestPipeline(t *testing.T) {
+	emitChan := make(chan flowrecord.Event, 10)
+	p := &Pipeline{
+		inputChan: make(chan flowrecord.Event, 10),
+		emitChan:  emitChan,
+		stages:    []Stage{&countStage{}},
+	}
+
+	controlChan := make(chan ControlMessage)
+
+	go func() {
+		assert.NoError(t, p.Start(controlChan))
+	}()
+
+	controlChan <- ControlMessageStart
+
+	for i := 0; i < 3; i++ {
+		p.InputChan() <- flowrecord.Attributes{"eventType": "test", "i": i}
+	}
+
+	// Queued events are processed before the final flush
+	controlChan <- ControlMessageQuit
+	assert.Equal(t, ControlMessageDone, <-controlChan)
+
+	assert.Equal(t, 3, len(emitChan))
+	assert.Equal(t, 0, (<-emitChan).Attributes()["i"])
+	assert.Equal(t, 2, (<-emitChan).Attributes()["i"])
+	assert.Equal(t, 3, (<-emitChan).Attributes()["seen"])
+}
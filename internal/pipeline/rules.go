@@ -0,0 +1,241 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+// Types attributes can be cast to
+const (
+	castString = "string"
+	castInt    = "int"
+	castFloat  = "float"
+	castBool   = "bool"
+)
+
+// Attributes every event keeps
+var protectedAttributes = map[string]bool{"eventType": true, "timestamp": true}
+
+type RulesConfig struct {
+	File string `envconfig:"ATTRIBUTE_RULES_FILE"`
+}
+
+// AttributeRule shapes the events of a type, from any exporter or just one.
+// It keeps only the Allow attributes if any are given, removes the Deny ones,
+// then renames, casts and sets, in that order.
+type AttributeRule struct {
+	EventType string                 `json:"eventType"`
+	Agent     string                 `json:"agent"`
+	Allow     []string               `json:"allow"`
+	Deny      []string               `json:"deny"`
+	Rename    map[string]string      `json:"rename"`
+	Cast      map[string]string      `json:"cast"`
+	Set       map[string]interface{} `json:"set"`
+
+	allow map[string]bool
+}
+
+/******************************************************************************
+ *
+ * Create AttributeRules from a rule file
+ *
+ * Expected Format:
+ *   {"rules": [{"eventType": "ipfix", "agent": "192.0.2.1", "deny": [...],
+ *     "rename": {...}, "cast": {...}, "set": {...}}]}
+ ******************************************************************************/
+func NewAttributeRules(config RulesConfig) (*AttributeRules, error) {
+	fileh, err := os.Open(config.File)
+	if err != nil {
+		return nil, err
+	}
+	defer fileh.Close()
+
+	var file struct {
+		Rules []*AttributeRule `json:"rules"`
+	}
+
+	decoder := json.NewDecoder(fileh)
+	decoder.DisallowUnknownFields()
+
+	if err = decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to read attribute rules '%s': %v", config.File, err)
+	}
+
+	for i, rule := range file.Rules {
+		if err = rule.validate(); err != nil {
+			return nil, fmt.Errorf("attribute rule %d in '%s': %v", i+1, config.File, err)
+		}
+	}
+
+	log.Infof("rules: Loaded %d attribute rules from '%s'", len(file.Rules), config.File)
+
+	return &AttributeRules{rules: file.Rules}, nil
+}
+
+// validate checks a rule can be applied, and prepares it
+func (r *AttributeRule) validate() error {
+	if r.EventType == "" {
+		return fmt.Errorf("no eventType")
+	}
+
+	for name, to := range r.Rename {
+		if protectedAttributes[name] || protectedAttributes[to] {
+			return fmt.Errorf("cannot rename '%s' to '%s'", name, to)
+		}
+	}
+
+	for _, name := range r.Deny {
+		if protectedAttributes[name] {
+			return fmt.Errorf("cannot deny '%s'", name)
+		}
+	}
+
+	for name := range r.Set {
+		if protectedAttributes[name] {
+			return fmt.Errorf("cannot set '%s'", name)
+		}
+	}
+
+	for name, to := range r.Cast {
+		switch to {
+		case castString, castInt, castFloat, castBool:
+		default:
+			return fmt.Errorf("cannot cast '%s' to unknown type '%s'", name, to)
+		}
+	}
+
+	if len(r.Allow) > 0 {
+		r.allow = make(map[string]bool, len(r.Allow))
+		for _, name := range r.Allow {
+			r.allow[name] = true
+		}
+	}
+
+	return nil
+}
+
+/******************************************************************************
+ *
+ * AttributeRules shapes events just before they are emitted, so vendor
+ * attributes can be dropped or renamed without changing the flow handlers.
+ * Events no rule matches pass untouched.
+ *
+ ******************************************************************************/
+type AttributeRules struct {
+	rules []*AttributeRule
+}
+
+func (a *AttributeRules) Name() string {
+	return "rules"
+}
+
+func (a *AttributeRules) Process(event flowrecord.Event, emit func(flowrecord.Event)) {
+	var attrs map[string]interface{}
+
+	eventType := event.EventType()
+	agent := eventAgent(event)
+
+	for _, rule := range a.rules {
+		if rule.EventType != eventType || (rule.Agent != "" && rule.Agent != agent) {
+			continue
+		}
+
+		if attrs == nil {
+			attrs = event.Attributes()
+		}
+
+		rule.apply(attrs)
+	}
+
+	if attrs == nil {
+		emit(event)
+		return
+	}
+
+	emit(flowrecord.Attributes(attrs))
+}
+
+// eventAgent is the exporter of an event, if it has one
+func eventAgent(event flowrecord.Event) string {
+	if rec, ok := event.(*flowrecord.FlowRecord); ok {
+		return rec.Agent
+	}
+
+	agent, _ := event.Attributes()["agent"].(string)
+
+	return agent
+}
+
+func (r *AttributeRule) apply(attrs map[string]interface{}) {
+	if r.allow != nil {
+		for name := range attrs {
+			if !r.allow[name] && !protectedAttributes[name] {
+				delete(attrs, name)
+			}
+		}
+	}
+
+	for _, name := range r.Deny {
+		delete(attrs, name)
+	}
+
+	for name, to := range r.Rename {
+		if value, ok := attrs[name]; ok {
+			delete(attrs, name)
+			attrs[to] = value
+		}
+	}
+
+	for name, to := range r.Cast {
+		value, ok := attrs[name]
+		if !ok {
+			continue
+		}
+
+		cast, err := castValue(value, to)
+		if err != nil {
+			log.Debugf("rules: Leaving '%s' as it is: %v", name, err)
+			continue
+		}
+
+		attrs[name] = cast
+	}
+
+	for name, value := range r.Set {
+		attrs[name] = value
+	}
+}
+
+// castValue converts a value through its string form
+func castValue(value interface{}, to string) (interface{}, error) {
+	s := fmt.Sprint(value)
+
+	switch to {
+	case castString:
+		return s, nil
+	case castInt:
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i, nil
+		}
+
+		// Fractions are truncated
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		return int64(f), nil
+	case castFloat:
+		return strconv.ParseFloat(s, 64)
+	case castBool:
+		return strconv.ParseBool(s)
+	}
+
+	return nil, fmt.Errorf("unknown type '%s'", to)
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func writeTestFile(t *testing.T, data string) string {
+	fileh, err := ioutil.TempFile("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fileh.Close()
+
+	if _, err := fileh.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+
+	return fileh.Name()
+}
+
+func TestNewAttributeRules(t *testing.T) {
+	_, err := NewAttributeRules(RulesConfig{File: "/nonexistent"})
+	assert.Error(t, err)
+
+	for _, data := range []string{
+		`{"rules": [{"eventType": "ipfix"`,
+		`{"rules": [{"eventType": "ipfix", "bogus": true}]}`,
+		`{"rules": [{"deny": ["a"]}]}`,
+		`{"rules": [{"eventType": "ipfix", "deny": ["eventType"]}]}`,
+		`{"rules": [{"eventType": "ipfix", "rename": {"a": "timestamp"}}]}`,
+		`{"rules": [{"eventType": "ipfix", "set": {"eventType": "x"}}]}`,
+		`{"rules": [{"eventType": "ipfix", "cast": {"a": "uint8"}}]}`,
+	} {
+		filename := writeTestFile(t, data)
+
+		_, err = NewAttributeRules(RulesConfig{File: filename})
+		assert.Error(t, err, data)
+
+		os.Remove(filename)
+	}
+}
+
+func TestAttributeRules(t *testing.T) {
+	filename := writeTestFile(t, `{"rules": [
+	{"eventType": "ipfix", "deny": ["paddingOctets"], "rename": {"sourceIPv4Address": "srcAddr"}, "cast": {"vlanId": "string", "ratio": "int"}},
+	{"eventType": "ipfix", "agent": "192.0.2.1", "set": {"site": "nyc"}},
+	{"eventType": "networkFlowAggregate", "allow": ["flows", "bytes"]}
+]}`)
+	defer os.Remove(filename)
+
+	rules, err := NewAttributeRules(RulesConfig{File: filename})
+	assert.NoError(t, err)
+
+	var events []flowrecord.Event
+	emit := func(event flowrecord.Event) { events = append(events, event) }
+
+	now := time.Now()
+
+	for _, agent := range []string{"192.0.2.1", "192.0.2.2"} {
+		rec := flowrecord.New("ipfix", flowrecord.FlowTypeIpfix, agent, now)
+		rec.Set("sourceIPv4Address", net.ParseIP("10.0.0.1").String())
+		rec.Set("paddingOctets", uint8(0))
+		rec.Set("vlanId", uint16(42))
+		rec.Set("ratio", 2.5)
+		rec.Set("octetDeltaCount", uint64(1000))
+
+		rules.Process(rec, emit)
+	}
+
+	rules.Process(flowrecord.Attributes{"eventType": "networkFlowAggregate", "timestamp": now, "flows": int64(1), "bytes": int64(2), "agent": "192.0.2.1"}, emit)
+
+	// No rule for the type, passed as it is
+	sflow := flowrecord.New("sflow", flowrecord.FlowTypeSflow, "192.0.2.1", now)
+	rules.Process(sflow, emit)
+
+	assert.Equal(t, 4, len(events))
+
+	assert.Equal(t, map[string]interface{}{
+		"eventType":       "ipfix",
+		"timestamp":       now,
+		"srcAddr":         "10.0.0.1",
+		"vlanId":          "42",
+		"ratio":           int64(2),
+		"octetDeltaCount": uint64(1000),
+		"site":            "nyc",
+	}, events[0].Attributes())
+
+	assert.NotContains(t, events[1].Attributes(), "site")
+	assert.Equal(t, "ipfix", events[1].EventType())
+
+	assert.Equal(t, map[string]interface{}{"eventType": "networkFlowAggregate", "timestamp": now, "flows": int64(1), "bytes": int64(2)}, events[2].Attributes())
+
+	assert.Equal(t, sflow, events[3])
+}
+
+func TestCastValue(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		to       string
+		expected interface{}
+	}{
+		{uint16(42), castString, "42"},
+		{"42", castInt, int64(42)},
+		{float32(1.5), castInt, int64(1)},
+		{"1.5", castFloat, 1.5},
+		{int64(3), castFloat, 3.0},
+		{"true", castBool, true},
+	}
+
+	for _, test := range tests {
+		value, err := castValue(test.value, test.to)
+		assert.NoError(t, err)
+		assert.Equal(t, test.expected, value)
+	}
+
+	_, err := castValue("x", castInt)
+	assert.Error(t, err)
+
+	_, err = castValue("x", castBool)
+	assert.Error(t, err)
+}
@@ -0,0 +1,297 @@
+package pipeline
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+const (
+	DefaultScanEventType   = "networkScan"
+	DefaultScanWindow      = time.Minute
+	DefaultScanHosts       = 50
+	DefaultScanPorts       = 50
+	DefaultScanFailedRatio = 0.5
+	DefaultScanMaxSources  = 5000
+	DefaultScanTargets     = 10
+)
+
+// Scan types
+const (
+	ScanTypeHorizontal = "horizontal" // A port across many hosts
+	ScanTypeVertical   = "vertical"   // Many ports on few hosts
+)
+
+const protocolTCP uint8 = 6
+
+type ScanConfig struct {
+	Enabled   bool          `envconfig:"SCAN_ENABLED"`
+	EventType string        `envconfig:"SCAN_EVENT_TYPE"`
+	Window    time.Duration `envconfig:"SCAN_WINDOW"`
+
+	// Distinct destinations a source reaches in a window that make a scan
+	Hosts int `envconfig:"SCAN_HOSTS"`
+	Ports int `envconfig:"SCAN_PORTS"`
+
+	// The same for sources without TCP flows, which show no failed
+	// exchanges, 0 to never report them
+	NonTCPHosts int `envconfig:"SCAN_NON_TCP_HOSTS"`
+	NonTCPPorts int `envconfig:"SCAN_NON_TCP_PORTS"`
+
+	// Share of a source's TCP flows that have to look failed, 0 to ignore
+	FailedRatio float64 `envconfig:"SCAN_FAILED_RATIO"`
+
+	MaxSources int `envconfig:"SCAN_MAX_SOURCES"`
+	Targets    int `envconfig:"SCAN_TARGETS"`
+}
+
+/******************************************************************************
+ *
+ * Create a new ScanDetector
+ *
+ ******************************************************************************/
+func NewScanDetector(config ScanConfig, flowTypes []string) (*ScanDetector, error) {
+	if config.EventType == "" {
+		config.EventType = DefaultScanEventType
+	}
+
+	if config.Window <= 0 {
+		config.Window = DefaultScanWindow
+	}
+
+	if config.Window < tickInterval {
+		return nil, fmt.Errorf("scan window %v is shorter than %v", config.Window, tickInterval)
+	}
+
+	if config.Hosts <= 0 {
+		config.Hosts = DefaultScanHosts
+	}
+
+	if config.Ports <= 0 {
+		config.Ports = DefaultScanPorts
+	}
+
+	if config.FailedRatio < 0 || config.FailedRatio > 1 {
+		return nil, fmt.Errorf("scan failed ratio %v is not between 0 and 1", config.FailedRatio)
+	}
+
+	if config.MaxSources <= 0 {
+		config.MaxSources = DefaultScanMaxSources
+	}
+
+	if config.Targets <= 0 {
+		config.Targets = DefaultScanTargets
+	}
+
+	log.Infof("scan: Looking for sources reaching %d hosts or %d ports every %v", config.Hosts, config.Ports, config.Window)
+
+	return &ScanDetector{
+		config:    config,
+		flowTypes: newEventTypes(flowTypes),
+		sources:   make(map[string]*scanSource),
+		busiest:   newSpaceSaving(config.MaxSources),
+	}, nil
+}
+
+/******************************************************************************
+ *
+ * ScanDetector counts the distinct hosts and ports every source reaches in a
+ * window, and how many of its TCP exchanges look failed: a SYN without an
+ * ACK from the source, or an RST sent back to it.  Sources over either
+ * threshold are reported when the window closes.  Sources without TCP flows
+ * have their own thresholds, as busy DNS and NTP clients reach many hosts
+ * without anything failing.
+ *
+ ******************************************************************************/
+type ScanDetector struct {
+	config    ScanConfig
+	flowTypes eventTypes
+
+	windowStart time.Time
+	sources     map[string]*scanSource
+	busiest     *spaceSaving // Sources by flows, to keep the busiest
+	overflow    bool
+}
+
+type scanSource struct {
+	hosts    hyperLogLog
+	ports    hyperLogLog
+	flows    uint64
+	tcpFlows uint64
+	failed   uint64
+	resets   uint64
+	targets  []string
+}
+
+func (d *ScanDetector) Name() string {
+	return "scan"
+}
+
+func (d *ScanDetector) Process(event flowrecord.Event, emit func(flowrecord.Event)) {
+	if rec, ok := d.flowTypes.counted(event); ok {
+		d.add(rec, time.Now())
+	}
+
+	emit(event)
+}
+
+// add counts a flow against its source, and an RST against the destination
+func (d *ScanDetector) add(rec *flowrecord.FlowRecord, now time.Time) {
+	if rec.SourceAddress == nil || rec.DestinationAddress == nil {
+		return
+	}
+
+	if d.windowStart.IsZero() {
+		d.windowStart = now.Truncate(d.config.Window)
+	}
+
+	tcp := rec.HasProtocol && rec.Protocol == protocolTCP
+
+	// A reset answers whoever the destination is, if it is a source at all
+	if tcp && isTCPFlag(rec, "tcpFlagRST") {
+		if target, ok := d.sources[rec.DestinationAddress.String()]; ok {
+			target.resets++
+		}
+	}
+
+	source := d.source(rec.SourceAddress.String())
+
+	host := rec.DestinationAddress.String()
+	source.hosts.add(host)
+	source.flows++
+
+	target := host
+	if rec.HasPorts() {
+		port := strconv.Itoa(int(rec.DestinationPort))
+		source.ports.add(strconv.Itoa(int(rec.Protocol)) + "/" + port)
+		target = net.JoinHostPort(host, port)
+	}
+
+	if tcp {
+		source.tcpFlows++
+
+		if isTCPFlag(rec, "tcpFlagSYN") && !isTCPFlag(rec, "tcpFlagACK") {
+			source.failed++
+		}
+	}
+
+	if len(source.targets) < d.config.Targets && !containsString(source.targets, target) {
+		source.targets = append(source.targets, target)
+	}
+}
+
+// source returns the counts of an address.  Past the limit the source with
+// the fewest flows makes room, so a busy scanner is always counted.
+func (d *ScanDetector) source(address string) *scanSource {
+	if replaced := d.busiest.add(address, 1, 1); replaced != "" {
+		delete(d.sources, replaced)
+
+		if !d.overflow {
+			log.Warnf("scan: More than %d sources in a window, keeping the busiest", d.config.MaxSources)
+			d.overflow = true
+		}
+	}
+
+	source, ok := d.sources[address]
+	if !ok {
+		source = &scanSource{}
+		d.sources[address] = source
+	}
+
+	return source
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+/******************************************************************************
+ *
+ * Report the scanners when the window closes
+ *
+ ******************************************************************************/
+func (d *ScanDetector) Tick(now time.Time, emit func(flowrecord.Event)) {
+	if d.windowStart.IsZero() || now.Before(d.windowStart.Add(d.config.Window)) {
+		return
+	}
+
+	d.Flush(now, emit)
+}
+
+func (d *ScanDetector) Flush(now time.Time, emit func(flowrecord.Event)) {
+	if d.windowStart.IsZero() {
+		return
+	}
+
+	windowEnd := d.windowStart.Add(d.config.Window)
+	if now.Before(windowEnd) {
+		windowEnd = now
+	}
+
+	for address, source := range d.sources {
+		hosts := source.hosts.estimate()
+		ports := source.ports.estimate()
+
+		hostsThreshold, portsThreshold := d.config.Hosts, d.config.Ports
+		if source.tcpFlows == 0 {
+			hostsThreshold, portsThreshold = d.config.NonTCPHosts, d.config.NonTCPPorts
+		}
+
+		var scanType string
+
+		switch {
+		case hostsThreshold > 0 && hosts >= uint64(hostsThreshold):
+			scanType = ScanTypeHorizontal
+		case portsThreshold > 0 && ports >= uint64(portsThreshold):
+			scanType = ScanTypeVertical
+		default:
+			continue
+		}
+
+		failedRatio := 0.0
+		if source.tcpFlows > 0 {
+			failedRatio = float64(source.failed+source.resets) / float64(source.tcpFlows)
+			if failedRatio > 1 {
+				failedRatio = 1
+			}
+
+			if failedRatio < d.config.FailedRatio {
+				continue
+			}
+		}
+
+		emit(flowrecord.Attributes{
+			"eventType":     d.config.EventType,
+			"timestamp":     d.windowStart,
+			"windowStart":   d.windowStart.UnixNano() / int64(time.Millisecond),
+			"windowEnd":     windowEnd.UnixNano() / int64(time.Millisecond),
+			"windowSeconds": int64(d.config.Window.Seconds()),
+			"scanner":       address,
+			"scanType":      scanType,
+			"hosts":         int64(hosts),
+			"ports":         int64(ports),
+			"flows":         int64(source.flows),
+			"failedFlows":   int64(source.failed),
+			"resets":        int64(source.resets),
+			"failedRatio":   failedRatio,
+			"targets":       strings.Join(source.targets, ","),
+		})
+	}
+
+	d.windowStart = time.Time{}
+	d.sources = make(map[string]*scanSource, len(d.sources))
+	d.busiest = newSpaceSaving(d.config.MaxSources)
+	d.overflow = false
+}
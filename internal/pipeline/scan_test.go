@@ -0,0 +1,160 @@
+package pipeline
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestNewScanDetector(t *testing.T) {
+	d, err := NewScanDetector(ScanConfig{}, []string{"sflow"})
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultScanEventType, d.config.EventType)
+	assert.Equal(t, DefaultScanWindow, d.config.Window)
+	assert.Equal(t, DefaultScanHosts, d.config.Hosts)
+	assert.Equal(t, DefaultScanPorts, d.config.Ports)
+
+	_, err = NewScanDetector(ScanConfig{Window: time.Millisecond}, []string{"sflow"})
+	assert.Error(t, err)
+
+	_, err = NewScanDetector(ScanConfig{FailedRatio: 2}, []string{"sflow"})
+	assert.Error(t, err)
+}
+
+func TestScanDetector(t *testing.T) {
+	d, err := NewScanDetector(ScanConfig{Hosts: 20, Ports: 20, FailedRatio: 0.5, Targets: 3}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	now := time.Unix(1600000000, 0).Truncate(time.Minute)
+
+	for i := 0; i < 30; i++ {
+		// A horizontal scan of port 22, answered with resets
+		d.add(testFlow{dst: fmt.Sprintf("192.0.2.%d", i), dstPort: 22, flags: []string{"tcpFlagSYN"}}.record(), now)
+		d.add(testFlow{src: fmt.Sprintf("192.0.2.%d", i), dst: "10.0.0.1", dstPort: 40000, flags: []string{"tcpFlagRST", "tcpFlagACK"}}.record(), now)
+
+		// A vertical scan of a single host
+		d.add(testFlow{src: "10.0.0.2", dst: "192.0.2.200", dstPort: uint16(1000 + i), flags: []string{"tcpFlagSYN"}}.record(), now)
+
+		// A busy client whose connections all succeed
+		d.add(testFlow{src: "10.0.0.3", dst: fmt.Sprintf("198.51.100.%d", i), dstPort: 443, flags: []string{"tcpFlagSYN", "tcpFlagACK"}}.record(), now)
+	}
+
+	var events []flowrecord.Attributes
+	emit := func(event flowrecord.Event) { events = append(events, event.(flowrecord.Attributes)) }
+
+	d.Tick(now.Add(30*time.Second), emit)
+	assert.Empty(t, events)
+
+	d.Tick(now.Add(time.Minute), emit)
+	assert.Equal(t, 2, len(events))
+
+	scans := make(map[string]flowrecord.Attributes)
+	for _, event := range events {
+		scans[event["scanner"].(string)] = event
+	}
+
+	horizontal := scans["10.0.0.1"]
+	assert.Equal(t, DefaultScanEventType, horizontal["eventType"])
+	assert.Equal(t, ScanTypeHorizontal, horizontal["scanType"])
+	assert.Equal(t, int64(30), horizontal["hosts"])
+	assert.Equal(t, int64(1), horizontal["ports"])
+	assert.Equal(t, int64(30), horizontal["failedFlows"])
+	assert.Equal(t, int64(30), horizontal["resets"])
+	assert.Equal(t, 1.0, horizontal["failedRatio"])
+	assert.Equal(t, "192.0.2.0:22,192.0.2.1:22,192.0.2.2:22", horizontal["targets"])
+	assert.Equal(t, now, horizontal["timestamp"])
+
+	vertical := scans["10.0.0.2"]
+	assert.Equal(t, ScanTypeVertical, vertical["scanType"])
+	assert.Equal(t, int64(1), vertical["hosts"])
+	assert.InDelta(t, 30, vertical["ports"], 1)
+
+	// The window starts over
+	assert.Empty(t, d.sources)
+	d.Flush(now.Add(2*time.Minute), emit)
+	assert.Equal(t, 2, len(events))
+}
+
+func TestScanDetectorMaxSources(t *testing.T) {
+	d, err := NewScanDetector(ScanConfig{Hosts: 5, MaxSources: 5}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	now := time.Now()
+
+	// A scanner that starts after many quiet sources filled the limit is
+	// still counted
+	for i := 0; i < 10; i++ {
+		d.add(testFlow{dst: fmt.Sprintf("192.0.2.%d", i), dstPort: 22}.record(), now)
+	}
+
+	for i := 0; i < 20; i++ {
+		d.add(testFlow{src: fmt.Sprintf("10.1.0.%d", i), dst: "192.0.2.200"}.record(), now)
+	}
+
+	for i := 0; i < 10; i++ {
+		d.add(testFlow{src: "10.0.0.2", dst: fmt.Sprintf("192.0.2.%d", i), dstPort: 22}.record(), now)
+	}
+
+	// Resets to addresses that send nothing take no room
+	for i := 0; i < 10; i++ {
+		d.add(testFlow{src: "10.0.0.3", dst: fmt.Sprintf("198.51.100.%d", i), flags: []string{"tcpFlagRST"}}.record(), now)
+	}
+
+	assert.Equal(t, 5, len(d.sources))
+	assert.NotContains(t, d.sources, "198.51.100.0")
+	assert.True(t, d.overflow)
+
+	// Without TCP flags nothing looks failed, but the ratio is not configured
+	var events []flowrecord.Event
+	d.Flush(now, func(event flowrecord.Event) { events = append(events, event) })
+	assert.Equal(t, 3, len(events))
+	assert.False(t, d.overflow)
+
+	sources := make(map[string]bool)
+	for _, event := range events {
+		sources[event.Attributes()["scanner"].(string)] = true
+	}
+
+	assert.Equal(t, map[string]bool{"10.0.0.1": true, "10.0.0.2": true, "10.0.0.3": true}, sources)
+}
+
+func TestScanDetectorNonTCP(t *testing.T) {
+	d, err := NewScanDetector(ScanConfig{Hosts: 20, Ports: 20}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	now := time.Unix(1600000000, 0).Truncate(time.Minute)
+
+	// A busy DNS client is not a scan
+	for i := 0; i < 30; i++ {
+		d.add(testFlow{dst: fmt.Sprintf("192.0.2.%d", i), protocol: 17, dstPort: 53}.record(), now)
+	}
+
+	var events []flowrecord.Attributes
+	emit := func(event flowrecord.Event) { events = append(events, event.(flowrecord.Attributes)) }
+
+	d.Flush(now.Add(time.Minute), emit)
+	assert.Empty(t, events)
+
+	// Unless sources without TCP have thresholds of their own
+	d, err = NewScanDetector(ScanConfig{Hosts: 20, Ports: 20, NonTCPHosts: 100, NonTCPPorts: 100}, []string{"sflow"})
+	assert.NoError(t, err)
+
+	for i := 0; i < 30; i++ {
+		d.add(testFlow{dst: fmt.Sprintf("192.0.2.%d", i), protocol: 17, dstPort: 53}.record(), now)
+		d.add(testFlow{src: "10.0.0.2", dst: "192.0.2.200", protocol: 17, dstPort: uint16(1000 + i)}.record(), now)
+	}
+
+	for i := 0; i < 150; i++ {
+		d.add(testFlow{src: "10.0.0.3", dst: fmt.Sprintf("198.51.100.%d", i), protocol: 17, dstPort: 161}.record(), now)
+	}
+
+	d.Flush(now.Add(time.Minute), emit)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, "10.0.0.3", events[0]["scanner"])
+	assert.Equal(t, ScanTypeHorizontal, events[0]["scanType"])
+	assert.Equal(t, 0.0, events[0]["failedRatio"])
+}
@@ -0,0 +1,106 @@
+package pipeline
+
+import (
+	"container/heap"
+)
+
+/******************************************************************************
+ *
+ * A Space-Saving sketch keeps the heaviest keys of a stream in a fixed number
+ * of counters.  When a new key arrives and the sketch is full it takes over
+ * the smallest counter, and that counter's value is kept as the new key's
+ * possible overcount.  Any key heavier than total/capacity is guaranteed to
+ * be present.
+ *
+ ******************************************************************************/
+type spaceSaving struct {
+	capacity int
+	counters map[string]*TopNEntry
+	order    topNEntryHeap
+}
+
+// TopNEntry is a key with its estimated counts
+type TopNEntry struct {
+	Key     string `json:"key"`
+	Bytes   uint64 `json:"bytes"`
+	Packets uint64 `json:"packets"`
+	Flows   uint64 `json:"flows"`
+	Error   uint64 `json:"error"` // Bytes that may belong to keys this counter replaced
+
+	index int
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{
+		capacity: capacity,
+		counters: make(map[string]*TopNEntry, capacity),
+	}
+}
+
+// add counts bytes and packets of a single flow against a key, and returns
+// the key it replaced when the sketch is full
+func (s *spaceSaving) add(key string, bytes uint64, packets uint64) string {
+	if counter, ok := s.counters[key]; ok {
+		counter.Bytes += bytes
+		counter.Packets += packets
+		counter.Flows++
+		heap.Fix(&s.order, counter.index)
+
+		return ""
+	}
+
+	if len(s.counters) < s.capacity {
+		counter := &TopNEntry{Key: key, Bytes: bytes, Packets: packets, Flows: 1}
+		s.counters[key] = counter
+		heap.Push(&s.order, counter)
+
+		return ""
+	}
+
+	// Replace the lightest key
+	counter := s.order[0]
+	replaced := counter.Key
+	delete(s.counters, counter.Key)
+
+	counter.Key = key
+	counter.Error = counter.Bytes
+	counter.Bytes += bytes
+	counter.Packets = packets
+	counter.Flows = 1
+
+	s.counters[key] = counter
+	heap.Fix(&s.order, 0)
+
+	return replaced
+}
+
+// topNEntryHeap orders counters lightest first
+type topNEntryHeap []*TopNEntry
+
+func (h topNEntryHeap) Len() int {
+	return len(h)
+}
+
+func (h topNEntryHeap) Less(i, j int) bool {
+	return h[i].Bytes < h[j].Bytes
+}
+
+func (h topNEntryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topNEntryHeap) Push(x interface{}) {
+	counter := x.(*TopNEntry)
+	counter.index = len(*h)
+	*h = append(*h, counter)
+}
+
+func (h *topNEntryHeap) Pop() interface{} {
+	old := *h
+	counter := old[len(old)-1]
+	*h = old[:len(old)-1]
+
+	return counter
+}
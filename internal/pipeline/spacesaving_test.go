@@ -0,0 +1,47 @@
+package pipeline
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := newSpaceSaving(3)
+
+	s.add("a", 100, 1)
+	s.add("b", 10, 1)
+	s.add("a", 100, 1)
+	s.add("c", 20, 1)
+
+	assert.Equal(t, uint64(200), s.counters["a"].Bytes)
+	assert.Equal(t, uint64(2), s.counters["a"].Flows)
+
+	// A new key takes over the lightest counter, keeping its count as the error
+	s.add("d", 5, 1)
+	assert.NotContains(t, s.counters, "b")
+	assert.Equal(t, uint64(15), s.counters["d"].Bytes)
+	assert.Equal(t, uint64(10), s.counters["d"].Error)
+	assert.Equal(t, 3, len(s.counters))
+	assert.Equal(t, 3, s.order.Len())
+}
+
+func TestSpaceSavingHeavyHitters(t *testing.T) {
+	s := newSpaceSaving(10)
+
+	// Heavy keys survive a long tail of light ones
+	for i := 0; i < 1000; i++ {
+		s.add(fmt.Sprintf("light%d", i), 1, 1)
+
+		if i%10 == 0 {
+			s.add("heavy1", 100, 1)
+			s.add("heavy2", 50, 1)
+		}
+	}
+
+	assert.Contains(t, s.counters, "heavy1")
+	assert.Contains(t, s.counters, "heavy2")
+	assert.True(t, s.counters["heavy1"].Bytes >= 10000)
+	assert.Equal(t, 10, len(s.counters))
+}
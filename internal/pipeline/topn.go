@@ -0,0 +1,288 @@
+package pipeline
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+const (
+	DefaultTopNCapacity = 200
+
+	// Windows are made up of slots, each with its own sketch
+	topNSlot  = 15 * time.Second
+	topNSlots = 60
+)
+
+// Dimensions flows are ranked by
+const (
+	TopNSource        = "source"
+	TopNDestination   = "destination"
+	TopNConversation  = "conversation"
+	TopNSourceAS      = "sourceAS"
+	TopNDestinationAS = "destinationAS"
+	TopNPort          = "port"
+	TopNInterface     = "interface"
+)
+
+var (
+	TopNDimensions = []string{TopNSource, TopNDestination, TopNConversation, TopNSourceAS, TopNDestinationAS, TopNPort, TopNInterface}
+	TopNWindows    = map[string]time.Duration{"1m": time.Minute, "5m": 5 * time.Minute, "15m": 15 * time.Minute}
+)
+
+type TopNConfig struct {
+	Enabled  bool `envconfig:"TOPN_ENABLED"`
+	Capacity int  `envconfig:"TOPN_CAPACITY"`
+}
+
+// TopNQuery selects a ranking, Network only applies to address dimensions
+type TopNQuery struct {
+	Dimension string
+	Window    string
+	Limit     int
+	Prefix    string
+	Network   *net.IPNet
+	MinBytes  uint64
+}
+
+// TopNResult is a ranking, heaviest first
+type TopNResult struct {
+	Dimension   string      `json:"dimension"`
+	Window      string      `json:"window"`
+	WindowStart int64       `json:"windowStart"`
+	WindowEnd   int64       `json:"windowEnd"`
+	Entries     []TopNEntry `json:"entries"`
+}
+
+/******************************************************************************
+ *
+ * Create a new TopTalkers stage
+ *
+ ******************************************************************************/
+func NewTopTalkers(config TopNConfig, flowTypes []string) *TopTalkers {
+	if config.Capacity <= 0 {
+		config.Capacity = DefaultTopNCapacity
+	}
+
+	log.Infof("topN: Keeping %d keys per dimension every %v", config.Capacity, topNSlot)
+
+	return &TopTalkers{
+		config:    config,
+		flowTypes: newEventTypes(flowTypes),
+	}
+}
+
+/******************************************************************************
+ *
+ * TopTalkers ranks flows by scaled bytes over rolling windows.  Memory is
+ * bounded by the capacity of a sketch per dimension per slot.
+ *
+ ******************************************************************************/
+type TopTalkers struct {
+	config    TopNConfig
+	flowTypes eventTypes
+
+	lock  sync.RWMutex
+	slots [topNSlots]topNSlotSketches
+}
+
+type topNSlotSketches struct {
+	start    time.Time
+	sketches map[string]*spaceSaving
+}
+
+func (t *TopTalkers) Name() string {
+	return "topN"
+}
+
+func (t *TopTalkers) Process(event flowrecord.Event, emit func(flowrecord.Event)) {
+	if rec, ok := t.flowTypes.counted(event); ok {
+		t.add(rec, time.Now())
+	}
+
+	emit(event)
+}
+
+// add counts a flow in the current slot of every dimension it has a key for
+func (t *TopTalkers) add(rec *flowrecord.FlowRecord, now time.Time) {
+	start := now.Truncate(topNSlot)
+	bytes := rec.ScaledBytes()
+	packets := rec.ScaledPackets()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	slot := &t.slots[(start.UnixNano()/int64(topNSlot))%topNSlots]
+	if !slot.start.Equal(start) {
+		slot.start = start
+		slot.sketches = make(map[string]*spaceSaving, len(TopNDimensions))
+	}
+
+	for _, dimension := range TopNDimensions {
+		key, ok := topNKey(dimension, rec)
+		if !ok {
+			continue
+		}
+
+		sketch, ok := slot.sketches[dimension]
+		if !ok {
+			sketch = newSpaceSaving(t.config.Capacity)
+			slot.sketches[dimension] = sketch
+		}
+
+		sketch.add(key, bytes, packets)
+	}
+}
+
+// topNKey is the key of a flow in a dimension
+func topNKey(dimension string, rec *flowrecord.FlowRecord) (string, bool) {
+	switch dimension {
+	case TopNSource:
+		return rec.SourceAddress.String(), rec.SourceAddress != nil
+	case TopNDestination:
+		return rec.DestinationAddress.String(), rec.DestinationAddress != nil
+	case TopNConversation:
+		if rec.SourceAddress == nil || rec.DestinationAddress == nil {
+			return "", false
+		}
+
+		// The same key for both directions
+		a, b := rec.SourceAddress.String(), rec.DestinationAddress.String()
+		if b < a {
+			a, b = b, a
+		}
+
+		return a + " <-> " + b, true
+	case TopNSourceAS:
+		return fmt.Sprint(rec.SourceAS), rec.SourceAS != 0
+	case TopNDestinationAS:
+		return fmt.Sprint(rec.DestinationAS), rec.DestinationAS != 0
+	case TopNPort:
+		return fmt.Sprintf("%d/%d", rec.Protocol, rec.DestinationPort), rec.HasPorts()
+	case TopNInterface:
+		return fmt.Sprintf("%s:%d", rec.Agent, rec.InputInterface), rec.InputInterface != 0
+	}
+
+	return "", false
+}
+
+/******************************************************************************
+ *
+ * Rank the keys of a dimension over a window, merging the sketches of the
+ * slots in it.  Counts are estimates, each entry's error is its possible
+ * overcount.
+ *
+ ******************************************************************************/
+func (t *TopTalkers) Top(query TopNQuery, now time.Time) (TopNResult, error) {
+	window, ok := TopNWindows[query.Window]
+	if !ok {
+		return TopNResult{}, fmt.Errorf("unknown window '%s'", query.Window)
+	}
+
+	if !isTopNDimension(query.Dimension) {
+		return TopNResult{}, fmt.Errorf("unknown dimension '%s'", query.Dimension)
+	}
+
+	// The current slot and the complete ones before it
+	windowEnd := now.Truncate(topNSlot).Add(topNSlot)
+	windowStart := windowEnd.Add(-window)
+
+	merged := make(map[string]*TopNEntry)
+
+	t.lock.RLock()
+
+	for i := range t.slots {
+		slot := &t.slots[i]
+		if slot.start.Before(windowStart) || !slot.start.Before(windowEnd) {
+			continue
+		}
+
+		sketch, ok := slot.sketches[query.Dimension]
+		if !ok {
+			continue
+		}
+
+		for key, counter := range sketch.counters {
+			entry, ok := merged[key]
+			if !ok {
+				entry = &TopNEntry{Key: key}
+				merged[key] = entry
+			}
+
+			entry.Bytes += counter.Bytes
+			entry.Packets += counter.Packets
+			entry.Flows += counter.Flows
+			entry.Error += counter.Error
+		}
+	}
+
+	t.lock.RUnlock()
+
+	entries := make([]TopNEntry, 0, len(merged))
+
+	for _, entry := range merged {
+		if query.matches(entry) {
+			entries = append(entries, *entry)
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Bytes == entries[j].Bytes {
+			return entries[i].Key < entries[j].Key
+		}
+
+		return entries[i].Bytes > entries[j].Bytes
+	})
+
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+
+	return TopNResult{
+		Dimension:   query.Dimension,
+		Window:      query.Window,
+		WindowStart: windowStart.UnixNano() / int64(time.Millisecond),
+		WindowEnd:   windowEnd.UnixNano() / int64(time.Millisecond),
+		Entries:     entries,
+	}, nil
+}
+
+// matches applies the filters of a query to an entry
+func (q TopNQuery) matches(entry *TopNEntry) bool {
+	if entry.Bytes < q.MinBytes {
+		return false
+	}
+
+	if q.Prefix != "" && !strings.HasPrefix(entry.Key, q.Prefix) {
+		return false
+	}
+
+	if q.Network != nil {
+		for _, address := range strings.Split(entry.Key, " <-> ") {
+			if ip := net.ParseIP(address); ip != nil && q.Network.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+func isTopNDimension(dimension string) bool {
+	for _, d := range TopNDimensions {
+		if d == dimension {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,92 @@
+package pipeline
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/newrelic/nri-network-telemetry/internal/flowrecord"
+)
+
+func TestTopTalkers(t *testing.T) {
+	talkers := NewTopTalkers(TopNConfig{}, []string{"sflow"})
+	assert.Equal(t, DefaultTopNCapacity, talkers.config.Capacity)
+
+	now := time.Unix(1600000000, 0)
+
+	talkers.add(testFlow{src: "10.0.0.1", dst: "10.0.0.2", dstPort: 443, bytes: 1000, in: 3, samplingRate: 10}.record(), now.Add(-10*time.Minute))
+	talkers.add(testFlow{src: "10.0.0.2", dst: "10.0.0.1", dstPort: 51515, bytes: 500, in: 3, samplingRate: 10}.record(), now.Add(-10*time.Second))
+	talkers.add(testFlow{src: "10.0.0.3", dst: "10.0.0.1", dstPort: 443, bytes: 100, in: 3, samplingRate: 10}.record(), now)
+
+	// Only the last minute
+	result, err := talkers.Top(TopNQuery{Dimension: TopNSource, Window: "1m"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(result.Entries))
+	assert.Equal(t, "10.0.0.2", result.Entries[0].Key)
+	assert.Equal(t, uint64(5000), result.Entries[0].Bytes)
+	assert.Equal(t, now.Truncate(topNSlot).Add(topNSlot).Add(-time.Minute).UnixNano()/int64(time.Millisecond), result.WindowStart)
+
+	// Both directions of a conversation together
+	result, err = talkers.Top(TopNQuery{Dimension: TopNConversation, Window: "15m"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "10.0.0.1 <-> 10.0.0.2", result.Entries[0].Key)
+	assert.Equal(t, uint64(15000), result.Entries[0].Bytes)
+	assert.Equal(t, uint64(2), result.Entries[0].Flows)
+
+	result, err = talkers.Top(TopNQuery{Dimension: TopNPort, Window: "15m", Limit: 1}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []TopNEntry{{Key: "6/443", Bytes: 11000, Packets: 20, Flows: 2}}, result.Entries)
+
+	result, err = talkers.Top(TopNQuery{Dimension: TopNInterface, Window: "5m"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:3", result.Entries[0].Key)
+
+	_, err = talkers.Top(TopNQuery{Dimension: TopNSource, Window: "1h"}, now)
+	assert.Error(t, err)
+
+	_, err = talkers.Top(TopNQuery{Dimension: "bogus", Window: "1m"}, now)
+	assert.Error(t, err)
+}
+
+func TestTopNQueryFilters(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/30")
+
+	tests := []struct {
+		query    TopNQuery
+		key      string
+		expected bool
+	}{
+		{TopNQuery{}, "10.0.0.1", true},
+		{TopNQuery{MinBytes: 101}, "10.0.0.1", false},
+		{TopNQuery{Prefix: "10.0."}, "10.0.0.1", true},
+		{TopNQuery{Prefix: "192."}, "10.0.0.1", false},
+		{TopNQuery{Network: network}, "10.0.0.1", true},
+		{TopNQuery{Network: network}, "10.0.0.9", false},
+		{TopNQuery{Network: network}, "10.0.0.9 <-> 10.0.0.2", true},
+		{TopNQuery{Network: network}, "6/443", false},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.query.matches(&TopNEntry{Key: test.key, Bytes: 100}), test.key)
+	}
+}
+
+func TestTopTalkersProcess(t *testing.T) {
+	talkers := NewTopTalkers(TopNConfig{Capacity: 5}, []string{"sflow"})
+
+	emitted := 0
+	emit := func(event flowrecord.Event) {
+		emitted++
+	}
+
+	// Every event continues, only flows are counted
+	talkers.Process(testFlow{src: "10.0.0.1", dst: "10.0.0.2", dstPort: 443, bytes: 1000, srcAS: 64500}.record(), emit)
+	talkers.Process(flowrecord.Attributes{"eventType": "networkThreat"}, emit)
+	assert.Equal(t, 2, emitted)
+
+	result, err := talkers.Top(TopNQuery{Dimension: TopNSourceAS, Window: "1m"}, time.Now())
+	assert.NoError(t, err)
+	assert.Equal(t, "64500", result.Entries[0].Key)
+}
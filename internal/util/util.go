@@ -1,7 +1,9 @@
 package util
 
 import (
+	"encoding/binary"
 	"fmt"
+	"net"
 
 	"github.com/google/gopacket"
 
@@ -39,6 +41,25 @@ func CombinedHash(packet gopacket.Packet) uint64 {
 	return h
 }
 
+// EndpointsHash is CombinedHash from addresses and ports, for flows that were
+// not decoded from a packet.  Both directions of a conversation hash the same.
+func EndpointsHash(srcAddr net.IP, srcPort uint16, dstAddr net.IP, dstPort uint16) uint64 {
+	return FnvHash(endpoint(srcAddr, srcPort)) + FnvHash(endpoint(dstAddr, dstPort))
+}
+
+// endpoint is an address and port as they are in a packet
+func endpoint(addr net.IP, port uint16) []byte {
+	if v4 := addr.To4(); v4 != nil {
+		addr = v4
+	}
+
+	raw := make([]byte, len(addr)+2)
+	copy(raw, addr)
+	binary.BigEndian.PutUint16(raw[len(addr):], port)
+
+	return raw
+}
+
 func LogIfErr(err error) {
 	if err != nil {
 		log.Error(err)
@@ -1,9 +1,34 @@
 package util
 
 import (
+	"net"
 	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestUtil(t *testing.T) {
 
 }
+
+func TestEndpointsHash(t *testing.T) {
+	src, dst := net.ParseIP("10.0.0.1"), net.ParseIP("192.0.2.1")
+
+	// The same as the packet's, whichever the direction
+	buf := gopacket.NewSerializeBuffer()
+	ip := &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: src.To4(), DstIP: dst.To4()}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 443}
+	assert.NoError(t, tcp.SetNetworkLayerForChecksum(ip))
+	assert.NoError(t, gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, ip, tcp))
+
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+
+	assert.Equal(t, CombinedHash(packet), EndpointsHash(src, 40000, dst, 443))
+	assert.Equal(t, EndpointsHash(src, 40000, dst, 443), EndpointsHash(dst, 443, src, 40000))
+	assert.NotEqual(t, EndpointsHash(src, 40000, dst, 443), EndpointsHash(src, 40001, dst, 443))
+
+	v6 := net.ParseIP("2001:db8::1")
+	assert.Equal(t, EndpointsHash(v6, 53, src, 1053), EndpointsHash(src, 1053, v6, 53))
+}